@@ -0,0 +1,208 @@
+package access
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/cel-go/cel"
+)
+
+// 规则动作
+const (
+	ActionAllow  = "allow"
+	ActionDeny   = "deny"
+	ActionPublic = "public" // 无需登录即可访问
+)
+
+type Rule struct {
+	Action  string   `koanf:"action"`  // allow, deny 或 public，默认 allow
+	Users   []string `koanf:"users"`   // 用户名，为空时匹配所有用户
+	Groups  []string `koanf:"groups"`  // 用户组，满足其一即可，为空时匹配所有用户
+	Methods []string `koanf:"methods"` // HTTP 方法，为空时匹配所有方法
+	Paths   []string `koanf:"paths"`   // 路径前缀或 glob，如 /api/ 或 /api/*/admin，为空时匹配所有路径
+	When    string   `koanf:"when"`    // CEL 表达式，其他条件匹配且结果为 true 时规则才生效
+}
+
+type Config struct {
+	Rules   []Rule `koanf:"rules"`
+	Default string `koanf:"default"` // 没有规则匹配时的动作，有 allow/deny 规则时默认 deny，否则 allow
+}
+
+// Request 是需要判断权限的请求
+type Request struct {
+	Username string
+	Groups   []string
+	Method   string
+	Path     string
+	Headers  http.Header
+	SourceIP string
+	Time     time.Time // 为零值时使用当前时间
+}
+
+// Policy 是编译后的访问策略，按顺序匹配，第一条匹配的规则生效
+type Policy struct {
+	rules    []compiledRule
+	fallback bool
+}
+
+type compiledRule struct {
+	Rule
+	when cel.Program // 未配置 when 时为 nil
+}
+
+// New 校验配置并创建访问策略
+func New(cfg Config) (*Policy, error) {
+	p := &Policy{}
+	hasRules := false
+	for i, rule := range cfg.Rules {
+		switch rule.Action {
+		case "":
+			rule.Action = ActionAllow
+			hasRules = true
+		case ActionAllow, ActionDeny:
+			hasRules = true
+		case ActionPublic:
+			if len(rule.Users) > 0 || len(rule.Groups) > 0 || rule.When != "" {
+				return nil, fmt.Errorf("access rule %d: public rule can not match users, groups or when", i)
+			}
+		default:
+			return nil, fmt.Errorf("access rule %d: unknown action %q", i, rule.Action)
+		}
+		for j, m := range rule.Methods {
+			rule.Methods[j] = strings.ToUpper(m)
+		}
+		for _, pattern := range rule.Paths {
+			if !strings.HasPrefix(pattern, "/") {
+				return nil, fmt.Errorf("access rule %d: path %q must start with /", i, pattern)
+			}
+			if _, err := path.Match(pattern, "/"); err != nil {
+				return nil, fmt.Errorf("access rule %d: path %q: %w", i, pattern, err)
+			}
+		}
+		compiled := compiledRule{Rule: rule}
+		if rule.When != "" {
+			prg, err := compile(rule.When)
+			if err != nil {
+				return nil, fmt.Errorf("access rule %d: when: %w", i, err)
+			}
+			compiled.when = prg
+		}
+		p.rules = append(p.rules, compiled)
+	}
+
+	switch cfg.Default {
+	case "":
+		p.fallback = !hasRules
+	case ActionAllow:
+		p.fallback = true
+	case ActionDeny:
+		p.fallback = false
+	default:
+		return nil, fmt.Errorf("access: unknown default action %q", cfg.Default)
+	}
+	return p, nil
+}
+
+// IsPublic 判断请求是否无需登录
+func (p *Policy) IsPublic(method, urlPath string) bool {
+	urlPath = cleanPath(urlPath)
+	for _, rule := range p.rules {
+		if rule.Action == ActionPublic && matchMethod(rule.Methods, method) && matchPath(rule.Paths, urlPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allow 判断已登录用户是否可以访问，表达式求值出错时拒绝访问
+func (p *Policy) Allow(r Request) bool {
+	urlPath := cleanPath(r.Path)
+	for _, rule := range p.rules {
+		if rule.Action == ActionPublic {
+			if matchMethod(rule.Methods, r.Method) && matchPath(rule.Paths, urlPath) {
+				return true
+			}
+			continue
+		}
+		if !matchMethod(rule.Methods, r.Method) || !matchPath(rule.Paths, urlPath) ||
+			!matchAny(rule.Users, r.Username) || !matchGroups(rule.Groups, r.Groups) {
+			continue
+		}
+		if rule.when != nil {
+			ok, err := eval(rule.when, r, urlPath)
+			if err != nil {
+				return false
+			}
+			if !ok {
+				continue
+			}
+		}
+		return rule.Action == ActionAllow
+	}
+	return p.fallback
+}
+
+// cleanPath 规范化路径，避免通过 /public/../admin 绕过规则
+func cleanPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	if i := strings.IndexByte(p, '?'); i >= 0 {
+		p = p[:i]
+	}
+	cleaned := path.Clean("/" + p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+func matchMethod(methods []string, method string) bool {
+	return len(methods) == 0 || matchAny(methods, strings.ToUpper(method))
+}
+
+func matchAny(values []string, v string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+func matchGroups(groups, userGroups []string) bool {
+	if len(groups) == 0 {
+		return true
+	}
+	for _, g := range userGroups {
+		if matchAny(groups, g) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPath 判断路径是否匹配，包含 *?[ 的规则按 glob 匹配，否则按路径前缀匹配
+func matchPath(patterns []string, p string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if strings.ContainsAny(pattern, "*?[") {
+			if ok, _ := path.Match(pattern, p); ok {
+				return true
+			}
+			continue
+		}
+		if p == pattern || strings.HasPrefix(p, strings.TrimSuffix(pattern, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
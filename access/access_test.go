@@ -0,0 +1,170 @@
+package access
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{"empty", Config{}, false},
+		{"unknown action", Config{Rules: []Rule{{Action: "maybe"}}}, true},
+		{"public with users", Config{Rules: []Rule{{Action: ActionPublic, Users: []string{"alice"}}}}, true},
+		{"relative path", Config{Rules: []Rule{{Paths: []string{"api"}}}}, true},
+		{"bad glob", Config{Rules: []Rule{{Paths: []string{"/api/["}}}}, true},
+		{"unknown default", Config{Default: "maybe"}, true},
+		{"expression", Config{Rules: []Rule{{When: `"ops" in groups && request.method == "GET"`}}}, false},
+		{"expression syntax", Config{Rules: []Rule{{When: `user ==`}}}, true},
+		{"expression unknown variable", Config{Rules: []Rule{{When: `role == "admin"`}}}, true},
+		{"expression not bool", Config{Rules: []Rule{{When: `user + "x"`}}}, true},
+		{"public with expression", Config{Rules: []Rule{{Action: ActionPublic, When: `true`}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.cfg)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	p, err := New(Config{
+		Rules: []Rule{
+			{Action: ActionPublic, Paths: []string{"/static/", "/health"}},
+			{Action: ActionPublic, Methods: []string{"get"}, Paths: []string{"/docs/*.html"}},
+			{Action: ActionDeny, Users: []string{"mallory"}},
+			{Action: ActionAllow, Groups: []string{"admin"}},
+			{Action: ActionDeny, Paths: []string{"/admin"}},
+			{Action: ActionAllow, Methods: []string{"GET", "HEAD"}, Paths: []string{"/api/*/status"}},
+			{Action: ActionAllow, Users: []string{"alice"}},
+		},
+	})
+	require.NoError(t, err)
+
+	t.Run("Public", func(t *testing.T) {
+		assert.True(t, p.IsPublic("GET", "/static/app.js"))
+		assert.True(t, p.IsPublic("POST", "/health"))
+		assert.True(t, p.IsPublic("GET", "/docs/index.html"))
+		assert.False(t, p.IsPublic("POST", "/docs/index.html"))
+		assert.False(t, p.IsPublic("GET", "/docs/a/index.html"))
+		assert.False(t, p.IsPublic("GET", "/healthz"))
+		assert.False(t, p.IsPublic("GET", "/static/../admin"))
+		assert.False(t, p.IsPublic("GET", "/"))
+	})
+
+	tests := []struct {
+		name string
+		req  Request
+		want bool
+	}{
+		{"public path", Request{Username: "mallory", Method: "GET", Path: "/static/a.css"}, true},
+		{"denied user", Request{Username: "mallory", Groups: []string{"admin"}, Method: "GET", Path: "/"}, false},
+		{"admin group", Request{Username: "bob", Groups: []string{"dev", "admin"}, Method: "GET", Path: "/admin/users"}, true},
+		{"admin path", Request{Username: "alice", Method: "GET", Path: "/admin/users"}, false},
+		{"dot segments", Request{Username: "alice", Method: "GET", Path: "/static/../admin"}, false},
+		{"glob", Request{Username: "carol", Method: "GET", Path: "/api/v1/status"}, true},
+		{"glob method", Request{Username: "carol", Method: "POST", Path: "/api/v1/status"}, false},
+		{"user", Request{Username: "alice", Method: "POST", Path: "/api/v1/items"}, true},
+		{"default deny", Request{Username: "carol", Method: "GET", Path: "/"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, p.Allow(tt.req))
+		})
+	}
+}
+
+func TestDefault(t *testing.T) {
+	// 没有规则时允许所有登录用户
+	p, err := New(Config{})
+	require.NoError(t, err)
+	assert.True(t, p.Allow(Request{Username: "anyone", Method: "GET", Path: "/"}))
+
+	// 只有 public 规则时同样允许
+	p, err = New(Config{Rules: []Rule{{Action: ActionPublic, Paths: []string{"/public"}}}})
+	require.NoError(t, err)
+	assert.True(t, p.Allow(Request{Username: "anyone", Method: "GET", Path: "/"}))
+
+	p, err = New(Config{Default: ActionDeny})
+	require.NoError(t, err)
+	assert.False(t, p.Allow(Request{Username: "anyone", Method: "GET", Path: "/"}))
+
+	p, err = New(Config{Default: ActionAllow, Rules: []Rule{{Action: ActionDeny, Paths: []string{"/admin"}}}})
+	require.NoError(t, err)
+	assert.True(t, p.Allow(Request{Username: "anyone", Method: "GET", Path: "/"}))
+	assert.False(t, p.Allow(Request{Username: "anyone", Method: "GET", Path: "/admin"}))
+}
+
+func TestExpressionErrorPosition(t *testing.T) {
+	_, err := New(Config{Rules: []Rule{
+		{Users: []string{"alice"}},
+		{When: "user == \"bob\" &&\n  request.path.startsWith(\"/api\") &&\n  )"},
+	}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "access rule 1: when:")
+	assert.Contains(t, err.Error(), ":3:3:")
+}
+
+func TestExpression(t *testing.T) {
+	p, err := New(Config{Rules: []Rule{
+		{Action: ActionDeny, When: `"x-debug" in request.headers && request.headers["x-debug"] == "1"`},
+		{Action: ActionAllow, Groups: []string{"ops"}, When: `time.getHours("UTC") >= 9 && time.getHours("UTC") < 18`},
+		{Action: ActionAllow, Paths: []string{"/api/"}, When: `inCIDR(source_ip, "10.0.0.0/8")`},
+		{Action: ActionAllow, When: `user.endsWith("-bot") && request.method in ["GET", "HEAD"]`},
+		{Action: ActionAllow, When: `request.path.startsWith("/reports/") && "x-ticket" in request.headers`},
+	}})
+	require.NoError(t, err)
+
+	day := time.Date(2026, 10, 17, 10, 0, 0, 0, time.UTC)
+	night := time.Date(2026, 10, 17, 22, 0, 0, 0, time.UTC)
+	header := func(k, v string) http.Header {
+		h := http.Header{}
+		h.Set(k, v)
+		return h
+	}
+	tests := []struct {
+		name string
+		req  Request
+		want bool
+	}{
+		{"office hours", Request{Username: "alice", Groups: []string{"ops"}, Method: "GET", Path: "/", Time: day}, true},
+		{"after hours", Request{Username: "alice", Groups: []string{"ops"}, Method: "GET", Path: "/", Time: night}, false},
+		{"header deny", Request{Username: "alice", Groups: []string{"ops"}, Method: "GET", Path: "/", Time: day, Headers: header("X-Debug", "1")}, false},
+		{"internal network", Request{Username: "bob", Method: "POST", Path: "/api/items", SourceIP: "10.1.2.3"}, true},
+		{"external network", Request{Username: "bob", Method: "POST", Path: "/api/items", SourceIP: "203.0.113.1"}, false},
+		{"invalid ip", Request{Username: "bob", Method: "POST", Path: "/api/items"}, false},
+		{"bot read", Request{Username: "ci-bot", Method: "get", Path: "/"}, true},
+		{"bot write", Request{Username: "ci-bot", Method: "DELETE", Path: "/"}, false},
+		{"header present", Request{Username: "bob", Method: "GET", Path: "/reports/../reports/q3", Headers: header("X-Ticket", "OPS-1")}, true},
+		{"header missing", Request{Username: "bob", Method: "GET", Path: "/reports/q3"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, p.Allow(tt.req))
+		})
+	}
+}
+
+func TestExpressionEvalError(t *testing.T) {
+	// 求值出错时拒绝访问，即使后面的规则允许
+	p, err := New(Config{Rules: []Rule{
+		{Action: ActionDeny, When: `request.headers["x-team"] == "contractors"`},
+		{Action: ActionAllow},
+	}})
+	require.NoError(t, err)
+	assert.False(t, p.Allow(Request{Username: "alice", Method: "GET", Path: "/"}))
+	assert.False(t, p.Allow(Request{Username: "alice", Method: "GET", Path: "/", Headers: http.Header{"X-Team": {"contractors"}}}))
+	assert.True(t, p.Allow(Request{Username: "alice", Method: "GET", Path: "/", Headers: http.Header{"X-Team": {"staff"}}}))
+}
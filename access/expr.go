@@ -0,0 +1,98 @@
+package access
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+)
+
+// env 是规则表达式可以使用的变量和函数：
+//
+//   - user: 用户名
+//   - groups: 用户组列表
+//   - request.method、request.path: 请求方法和规范化后的路径
+//   - request.headers: 请求头，名称为小写，同名的多个值以逗号连接，
+//     可能不存在的请求头使用 request.headers[?"x-name"].orValue("") 读取
+//   - source_ip: 客户端 IP
+//   - time: 请求时间，如 time.getHours("Asia/Shanghai")
+//   - inCIDR(ip, cidr): 判断 IP 是否属于网段
+var env = mustEnv()
+
+func mustEnv() *cel.Env {
+	e, err := cel.NewEnv(
+		cel.Variable("user", cel.StringType),
+		cel.Variable("groups", cel.ListType(cel.StringType)),
+		cel.Variable("request", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("source_ip", cel.StringType),
+		cel.Variable("time", cel.TimestampType),
+		cel.OptionalTypes(),
+		cel.Function("inCIDR",
+			cel.Overload("inCIDR_string_string", []*cel.Type{cel.StringType, cel.StringType}, cel.BoolType,
+				cel.BinaryBinding(inCIDR))),
+	)
+	if err != nil {
+		panic(err)
+	}
+	return e
+}
+
+// compile 编译规则表达式，错误信息包含行号和列号
+func compile(expr string) (cel.Program, error) {
+	ast, iss := env.Compile(expr)
+	if iss.Err() != nil {
+		return nil, iss.Err()
+	}
+	if !ast.OutputType().IsExactType(cel.BoolType) {
+		return nil, fmt.Errorf("expression must evaluate to bool, got %s", ast.OutputType())
+	}
+	return env.Program(ast)
+}
+
+// eval 计算规则表达式，结果不是 bool 时返回错误
+func eval(prg cel.Program, r Request, urlPath string) (bool, error) {
+	headers := make(map[string]string, len(r.Headers))
+	for k, v := range r.Headers {
+		headers[strings.ToLower(k)] = strings.Join(v, ", ")
+	}
+	now := r.Time
+	if now.IsZero() {
+		now = time.Now()
+	}
+	groups := r.Groups
+	if groups == nil {
+		groups = []string{}
+	}
+	out, _, err := prg.Eval(map[string]any{
+		"user":   r.Username,
+		"groups": groups,
+		"request": map[string]any{
+			"method":  strings.ToUpper(r.Method),
+			"path":    urlPath,
+			"headers": headers,
+		},
+		"source_ip": r.SourceIP,
+		"time":      now,
+	})
+	if err != nil {
+		return false, err
+	}
+	result, ok := out.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("expression returned %v", out.Type())
+	}
+	return result, nil
+}
+
+func inCIDR(ip, cidr ref.Val) ref.Val {
+	_, network, err := net.ParseCIDR(string(cidr.(types.String)))
+	if err != nil {
+		return types.NewErr("inCIDR: %v", err)
+	}
+	addr := net.ParseIP(string(ip.(types.String)))
+	return types.Bool(addr != nil && network.Contains(addr))
+}
@@ -0,0 +1,79 @@
+// Package authcode 生成登录交接使用的一次性授权码，授权码绑定目标站点，几秒内有效
+package authcode
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/ipfans/authgate/utils/random"
+)
+
+// DefaultTTL 是授权码的默认有效期
+const DefaultTTL = 30 * time.Second
+
+// ErrInvalid 表示授权码不存在、已使用、已过期或不属于当前站点
+var ErrInvalid = errors.New("authcode: invalid or expired code")
+
+// Grant 是授权码对应的登录结果
+type Grant struct {
+	Token string `json:"token"` // 兑换得到的 JWT
+	Host  string `json:"host"`  // 允许兑换的站点域名
+}
+
+// Store 保存授权码，多实例部署时可以使用共享存储（如 Redis）实现
+type Store interface {
+	// Put 保存授权码，ttl 后过期
+	Put(ctx context.Context, key string, grant Grant, ttl time.Duration) error
+	// Take 取出并删除授权码，不存在或已过期时返回 ErrInvalid，同一授权码只能成功取出一次
+	Take(ctx context.Context, key string) (Grant, error)
+}
+
+// Issuer 签发和兑换授权码
+type Issuer struct {
+	store Store
+	ttl   time.Duration
+}
+
+// New 创建 Issuer，ttl 不大于 0 时使用 DefaultTTL
+func New(store Store, ttl time.Duration) *Issuer {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	return &Issuer{store: store, ttl: ttl}
+}
+
+// key 返回授权码在存储中的键，存储中只保存哈希
+func key(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// Issue 为 host 签发兑换 token 的授权码
+func (i *Issuer) Issue(ctx context.Context, token, host string) (string, error) {
+	code, err := random.String(32)
+	if err != nil {
+		return "", err
+	}
+	if err := i.store.Put(ctx, key(code), Grant{Token: token, Host: host}, i.ttl); err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// Redeem 在 host 上兑换授权码，返回对应的 token。无论是否成功，授权码都会失效
+func (i *Issuer) Redeem(ctx context.Context, code, host string) (string, error) {
+	if code == "" {
+		return "", ErrInvalid
+	}
+	grant, err := i.store.Take(ctx, key(code))
+	if err != nil {
+		return "", err
+	}
+	if grant.Host != host {
+		return "", ErrInvalid
+	}
+	return grant.Token, nil
+}
@@ -0,0 +1,56 @@
+package authcode
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedeem(t *testing.T) {
+	ctx := context.Background()
+	issuer := New(NewMemory(), 0)
+
+	code, err := issuer.Issue(ctx, "token", "app.example.com")
+	require.NoError(t, err)
+	assert.NotContains(t, code, "token")
+
+	token, err := issuer.Redeem(ctx, code, "app.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "token", token)
+
+	// 只能兑换一次
+	_, err = issuer.Redeem(ctx, code, "app.example.com")
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	_, err = issuer.Redeem(ctx, "", "app.example.com")
+	assert.ErrorIs(t, err, ErrInvalid)
+}
+
+func TestRedeemWrongHost(t *testing.T) {
+	ctx := context.Background()
+	issuer := New(NewMemory(), 0)
+
+	code, err := issuer.Issue(ctx, "token", "app.example.com")
+	require.NoError(t, err)
+	_, err = issuer.Redeem(ctx, code, "evil.example.com")
+	assert.ErrorIs(t, err, ErrInvalid)
+	// 在其他站点尝试兑换后授权码即失效
+	_, err = issuer.Redeem(ctx, code, "app.example.com")
+	assert.ErrorIs(t, err, ErrInvalid)
+}
+
+func TestMemoryExpiry(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	require.NoError(t, m.Put(ctx, "k", Grant{Token: "token"}, -time.Second))
+	_, err := m.Take(ctx, "k")
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	require.NoError(t, m.Put(ctx, "k", Grant{Token: "token"}, time.Minute))
+	grant, err := m.Take(ctx, "k")
+	require.NoError(t, err)
+	assert.Equal(t, "token", grant.Token)
+}
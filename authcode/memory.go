@@ -0,0 +1,58 @@
+package authcode
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+var _ Store = &Memory{}
+
+// sweepInterval 是清理过期授权码的最小间隔
+const sweepInterval = time.Minute
+
+type memoryEntry struct {
+	grant   Grant
+	expires time.Time
+}
+
+// Memory 是单实例使用的内存存储
+type Memory struct {
+	mu        sync.Mutex
+	entries   map[string]memoryEntry
+	lastSweep time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{entries: make(map[string]memoryEntry)}
+}
+
+func (m *Memory) Put(ctx context.Context, key string, grant Grant, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	if now.Sub(m.lastSweep) > sweepInterval {
+		for k, e := range m.entries {
+			if !now.Before(e.expires) {
+				delete(m.entries, k)
+			}
+		}
+		m.lastSweep = now
+	}
+	m.entries[key] = memoryEntry{grant: grant, expires: now.Add(ttl)}
+	return nil
+}
+
+func (m *Memory) Take(ctx context.Context, key string) (Grant, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[key]
+	if !ok {
+		return Grant{}, ErrInvalid
+	}
+	delete(m.entries, key)
+	if !time.Now().Before(e.expires) {
+		return Grant{}, ErrInvalid
+	}
+	return e.grant, nil
+}
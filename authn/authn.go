@@ -0,0 +1,28 @@
+// Package authn 定义密码登录使用的认证器，校验用户名和密码并返回用户身份
+package authn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrInvalidCredentials 表示用户名或密码错误
+	ErrInvalidCredentials = errors.New("authn: invalid credentials")
+	// ErrUnknownUser 表示用户不存在，同时满足 errors.Is(err, ErrInvalidCredentials)
+	ErrUnknownUser = fmt.Errorf("%w: unknown user", ErrInvalidCredentials)
+)
+
+// Identity 是认证通过的用户身份，写入登录 JWT
+type Identity struct {
+	Username string
+	Email    string
+	Groups   []string
+}
+
+// Authenticator 校验用户名和密码。凭据错误时返回 ErrInvalidCredentials 或 ErrUnknownUser，
+// 其他错误表示认证器不可用
+type Authenticator interface {
+	Authenticate(ctx context.Context, username, password string) (*Identity, error)
+}
@@ -0,0 +1,124 @@
+package authn
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ipfans/authgate/models"
+	"github.com/ipfans/authgate/passwd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func hash(t *testing.T, password string) string {
+	h, err := passwd.Hash(password)
+	require.NoError(t, err)
+	return h
+}
+
+func TestStatic(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewStatic([]*models.User{
+		{Username: "admin", PasswordHash: hash(t, "break-glass"), Email: "admin@example.com", Groups: []string{"admins"}},
+	})
+	require.NoError(t, err)
+
+	identity, err := s.Authenticate(ctx, "admin", "break-glass")
+	require.NoError(t, err)
+	assert.Equal(t, &Identity{Username: "admin", Email: "admin@example.com", Groups: []string{"admins"}}, identity)
+	_, err = s.Authenticate(ctx, "admin", "wrong")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	_, err = s.Authenticate(ctx, "alice", "break-glass")
+	assert.ErrorIs(t, err, ErrUnknownUser)
+
+	_, err = NewStatic([]*models.User{{Username: "admin", PasswordHash: "plaintext"}})
+	assert.Error(t, err)
+	_, err = NewStatic([]*models.User{{PasswordHash: hash(t, "x")}})
+	assert.Error(t, err)
+}
+
+func TestHtpasswd(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	require.NoError(t, os.WriteFile(path, []byte("alice:"+hash(t, "alice-pw")+"\n"), 0o600))
+	h, err := NewHtpasswd(path)
+	require.NoError(t, err)
+
+	identity, err := h.Authenticate(ctx, "alice", "alice-pw")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", identity.Username)
+	_, err = h.Authenticate(ctx, "bob", "bob-pw")
+	assert.ErrorIs(t, err, ErrUnknownUser)
+
+	// 文件修改后自动重新加载
+	require.NoError(t, os.WriteFile(path, []byte("bob:"+hash(t, "bob-pw")+"\n"), 0o600))
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(path, future, future))
+	_, err = h.Authenticate(ctx, "bob", "bob-pw")
+	assert.NoError(t, err)
+	_, err = h.Authenticate(ctx, "alice", "alice-pw")
+	assert.ErrorIs(t, err, ErrUnknownUser)
+
+	_, err = NewHtpasswd(filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+}
+
+// stubAuthenticator 返回固定的结果并记录调用次数
+type stubAuthenticator struct {
+	identity *Identity
+	err      error
+	calls    int
+}
+
+func (s *stubAuthenticator) Authenticate(ctx context.Context, username, password string) (*Identity, error) {
+	s.calls++
+	return s.identity, s.err
+}
+
+func TestChain(t *testing.T) {
+	ctx := context.Background()
+	down := errors.New("ldap: connection refused")
+	alice := &Identity{Username: "alice"}
+
+	tests := []struct {
+		name    string
+		results []error
+		want    error
+		calls   []int
+	}{
+		{"First succeeds", []error{nil, nil}, nil, []int{1, 0}},
+		{"Unknown user tries next", []error{ErrUnknownUser, nil}, nil, []int{1, 1}},
+		{"Wrong password stops", []error{ErrInvalidCredentials, nil}, ErrInvalidCredentials, []int{1, 0}},
+		{"Unavailable tries next", []error{down, nil}, nil, []int{1, 1}},
+		{"Unavailable reported when nobody knows the user", []error{down, ErrUnknownUser}, down, []int{1, 1}},
+		{"All unknown", []error{ErrUnknownUser, ErrUnknownUser}, ErrUnknownUser, []int{1, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var chain Chain
+			var stubs []*stubAuthenticator
+			for _, err := range tt.results {
+				stub := &stubAuthenticator{err: err}
+				if err == nil {
+					stub.identity = alice
+				}
+				stubs = append(stubs, stub)
+				chain = append(chain, stub)
+			}
+			identity, err := chain.Authenticate(ctx, "alice", "pw")
+			if tt.want == nil {
+				require.NoError(t, err)
+				assert.Equal(t, alice, identity)
+			} else {
+				assert.ErrorIs(t, err, tt.want)
+			}
+			for i, stub := range stubs {
+				assert.Equal(t, tt.calls[i], stub.calls, "authenticator %d", i)
+			}
+		})
+	}
+}
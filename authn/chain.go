@@ -0,0 +1,40 @@
+package authn
+
+import (
+	"context"
+	"errors"
+)
+
+// Chain 按顺序尝试多个认证器，例如先尝试本地的应急管理员再尝试 LDAP。
+// 用户不存在时尝试下一个；密码错误时停止，不再交给后面的认证器；
+// 认证器不可用时继续尝试，全部未通过时返回第一个不可用的错误
+type Chain []Authenticator
+
+func (c Chain) Authenticate(ctx context.Context, username, password string) (*Identity, error) {
+	var unavailable error
+	for _, a := range c {
+		identity, err := a.Authenticate(ctx, username, password)
+		switch {
+		case err == nil:
+			return identity, nil
+		case errors.Is(err, ErrUnknownUser):
+		case errors.Is(err, ErrInvalidCredentials):
+			return nil, err
+		case unavailable == nil:
+			unavailable = err
+		}
+	}
+	if unavailable != nil {
+		return nil, unavailable
+	}
+	return nil, ErrUnknownUser
+}
+
+// Close 关闭链中需要释放资源的认证器，如 LDAP 连接池
+func (c Chain) Close() {
+	for _, a := range c {
+		if closer, ok := a.(interface{ Close() }); ok {
+			closer.Close()
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package authn
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ipfans/authgate/passwd"
+)
+
+// Htpasswd 使用 htpasswd 文件校验密码，文件修改后自动重新加载
+type Htpasswd struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	hashes  map[string]string
+}
+
+// NewHtpasswd 加载 htpasswd 文件，文件不存在或格式错误时返回错误
+func NewHtpasswd(path string) (*Htpasswd, error) {
+	h := &Htpasswd{path: path}
+	if _, err := h.load(); err != nil {
+		return nil, fmt.Errorf("htpasswd: %w", err)
+	}
+	return h, nil
+}
+
+// load 在文件修改时间变化时重新读取文件，返回当前的用户名到哈希的映射
+func (h *Htpasswd) load() (map[string]string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	info, err := os.Stat(h.path)
+	if err != nil {
+		return nil, err
+	}
+	if h.hashes != nil && info.ModTime().Equal(h.modTime) {
+		return h.hashes, nil
+	}
+	hashes, err := passwd.LoadHtpasswd(h.path)
+	if err != nil {
+		return nil, err
+	}
+	h.hashes, h.modTime = hashes, info.ModTime()
+	return hashes, nil
+}
+
+func (h *Htpasswd) Authenticate(ctx context.Context, username, password string) (*Identity, error) {
+	hashes, err := h.load()
+	if err != nil {
+		return nil, fmt.Errorf("htpasswd: %w", err)
+	}
+	hash, ok := hashes[username]
+	if !ok {
+		passwd.VerifyDummy(password)
+		return nil, ErrUnknownUser
+	}
+	if !passwd.Verify(hash, password) {
+		return nil, ErrInvalidCredentials
+	}
+	return &Identity{Username: username}, nil
+}
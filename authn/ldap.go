@@ -0,0 +1,296 @@
+package authn
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/ipfans/authgate/utils/defaults"
+)
+
+// LDAPConfig 是 LDAP / Active Directory 认证配置，先用服务账号搜索用户，再以用户的 DN 和密码绑定
+type LDAPConfig struct {
+	URL                string        `koanf:"url"`                  // ldap://host:389 或 ldaps://host:636
+	StartTLS           bool          `koanf:"start_tls"`            // ldap:// 连接建立后升级为 TLS
+	CA                 string        `koanf:"ca"`                   // PEM 格式的 CA 证书文件，为空时使用系统证书
+	InsecureSkipVerify bool          `koanf:"insecure_skip_verify"` // 不校验服务端证书，仅用于测试
+	BindDN             string        `koanf:"bind_dn"`              // 搜索用户的服务账号，为空时匿名搜索
+	BindPassword       string        `koanf:"bind_password"`
+	BaseDN             string        `koanf:"base_dn"`            // 搜索用户的起点
+	UserFilter         string        `koanf:"user_filter"`        // 默认 (uid={username})，AD 可使用 (sAMAccountName={username})
+	UsernameAttribute  string        `koanf:"username_attribute"` // 作为用户名的属性，默认为 user_filter 中与 {username} 比较的属性，如 uid 或 sAMAccountName
+	EmailAttribute     string        `koanf:"email_attribute"`    // 默认 mail
+	GroupBaseDN        string        `koanf:"group_base_dn"`      // 搜索用户组的起点，默认同 base_dn
+	GroupFilter        string        `koanf:"group_filter"`       // 默认 (member={dn})，{dn} 替换为用户 DN，{username} 替换为用户名
+	GroupAttribute     string        `koanf:"group_attribute"`    // 作为用户组名的属性，默认 cn
+	PoolSize           int           `koanf:"pool_size"`          // 保持的空闲连接数，默认 4
+	Timeout            time.Duration `koanf:"timeout"`            // 连接和请求超时，默认 5 秒
+}
+
+// filterAttributePattern 匹配过滤条件中与 {username} 比较的属性
+var filterAttributePattern = regexp.MustCompile(`\(([A-Za-z][A-Za-z0-9-]*)=\{username\}\)`)
+
+// LDAP 是基于 LDAP 的认证器，服务账号连接放在连接池中复用
+type LDAP struct {
+	cfg       LDAPConfig
+	tlsConfig *tls.Config
+	pool      chan *ldap.Conn
+}
+
+// NewLDAP 校验配置并创建 LDAP 认证器，不会立即连接服务器
+func NewLDAP(cfg LDAPConfig) (*LDAP, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") || u.Host == "" {
+		return nil, fmt.Errorf("ldap: invalid url %q", cfg.URL)
+	}
+	if cfg.StartTLS && u.Scheme == "ldaps" {
+		return nil, errors.New("ldap: start_tls cannot be used with ldaps://")
+	}
+	if cfg.BaseDN == "" {
+		return nil, errors.New("ldap: base_dn is required")
+	}
+	cfg.UserFilter = defaults.Get(cfg.UserFilter, "(uid={username})")
+	if cfg.UsernameAttribute == "" {
+		// 目录中的用户名不区分大小写，使用条目中的值作为用户名，避免 BOB 和 bob 成为两个用户
+		match := filterAttributePattern.FindStringSubmatch(cfg.UserFilter)
+		if match == nil {
+			return nil, fmt.Errorf("ldap: username_attribute is required for user_filter %q", cfg.UserFilter)
+		}
+		cfg.UsernameAttribute = match[1]
+	}
+	cfg.EmailAttribute = defaults.Get(cfg.EmailAttribute, "mail")
+	cfg.GroupBaseDN = defaults.Get(cfg.GroupBaseDN, cfg.BaseDN)
+	cfg.GroupFilter = defaults.Get(cfg.GroupFilter, "(member={dn})")
+	cfg.GroupAttribute = defaults.Get(cfg.GroupAttribute, "cn")
+	cfg.PoolSize = defaults.Get(cfg.PoolSize, 4)
+	cfg.Timeout = defaults.Get(cfg.Timeout, 5*time.Second)
+
+	l := &LDAP{
+		cfg: cfg,
+		tlsConfig: &tls.Config{
+			ServerName:         u.Hostname(),
+			InsecureSkipVerify: cfg.InsecureSkipVerify,
+			MinVersion:         tls.VersionTLS12,
+		},
+		pool: make(chan *ldap.Conn, cfg.PoolSize),
+	}
+	if cfg.CA != "" {
+		data, err := os.ReadFile(cfg.CA)
+		if err != nil {
+			return nil, fmt.Errorf("ldap: %w", err)
+		}
+		l.tlsConfig.RootCAs = x509.NewCertPool()
+		if !l.tlsConfig.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("ldap: no certificates found in %s", cfg.CA)
+		}
+	}
+	return l, nil
+}
+
+// dial 建立新连接，按配置升级 TLS 并以服务账号绑定
+func (l *LDAP) dial() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(l.cfg.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: l.cfg.Timeout}),
+		ldap.DialWithTLSConfig(l.tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(l.cfg.Timeout)
+	if l.cfg.StartTLS {
+		if err = conn.StartTLS(l.tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if err = l.bindService(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// bindService 以服务账号绑定，未配置服务账号时使用匿名绑定
+func (l *LDAP) bindService(conn *ldap.Conn) error {
+	if l.cfg.BindDN == "" {
+		return conn.UnauthenticatedBind("")
+	}
+	return conn.Bind(l.cfg.BindDN, l.cfg.BindPassword)
+}
+
+// get 从连接池取出空闲连接，没有时新建，reused 表示连接来自连接池
+func (l *LDAP) get() (conn *ldap.Conn, reused bool, err error) {
+	select {
+	case conn := <-l.pool:
+		if !conn.IsClosing() {
+			return conn, true, nil
+		}
+		conn.Close()
+	default:
+	}
+	conn, err = l.dial()
+	return conn, false, err
+}
+
+// put 把以服务账号绑定的连接放回连接池，连接池已满时关闭
+func (l *LDAP) put(conn *ldap.Conn) {
+	if conn.IsClosing() {
+		conn.Close()
+		return
+	}
+	select {
+	case l.pool <- conn:
+	default:
+		conn.Close()
+	}
+}
+
+// Close 关闭连接池中的连接
+func (l *LDAP) Close() {
+	for {
+		select {
+		case conn := <-l.pool:
+			conn.Close()
+		default:
+			return
+		}
+	}
+}
+
+// Authenticate 搜索用户并以用户的密码绑定，成功后查询用户所属的组。
+// 连接池中的连接可能已被服务器断开，遇到连接错误时使用新连接重试一次
+func (l *LDAP) Authenticate(ctx context.Context, username, password string) (*Identity, error) {
+	// 空密码会被当作匿名绑定而成功，必须拒绝
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+	identity, reused, err := l.authenticate(username, password)
+	if reused && connError(err) {
+		identity, _, err = l.authenticate(username, password)
+	}
+	return identity, err
+}
+
+func (l *LDAP) authenticate(username, password string) (*Identity, bool, error) {
+	conn, reused, err := l.get()
+	if err != nil {
+		return nil, false, err
+	}
+	identity, dn, err := l.search(conn, username)
+	if err != nil {
+		l.release(conn, err)
+		return nil, reused, err
+	}
+	if err = conn.Bind(dn, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			err = ErrInvalidCredentials
+		}
+		l.rebind(conn)
+		return nil, reused, err
+	}
+	l.rebind(conn)
+	return identity, reused, nil
+}
+
+// connError 判断是否为连接错误，连接被服务器断开时 go-ldap 返回的错误不带结果码
+func connError(err error) bool {
+	if err == nil || errors.Is(err, ErrInvalidCredentials) {
+		return false
+	}
+	var ldapErr *ldap.Error
+	if errors.As(err, &ldapErr) {
+		return ldapErr.ResultCode == ldap.ErrorNetwork
+	}
+	return true
+}
+
+// release 归还连接，连接出错时直接关闭
+func (l *LDAP) release(conn *ldap.Conn, err error) {
+	if connError(err) {
+		conn.Close()
+		return
+	}
+	l.put(conn)
+}
+
+// rebind 用户绑定后恢复为服务账号身份再放回连接池，失败时关闭连接
+func (l *LDAP) rebind(conn *ldap.Conn) {
+	if err := l.bindService(conn); err != nil {
+		conn.Close()
+		return
+	}
+	l.put(conn)
+}
+
+// search 以服务账号查找唯一匹配的用户和所属的组
+func (l *LDAP) search(conn *ldap.Conn, username string) (*Identity, string, error) {
+	attributes := []string{l.cfg.EmailAttribute, l.cfg.UsernameAttribute}
+	filter := strings.ReplaceAll(l.cfg.UserFilter, "{username}", ldap.EscapeFilter(username))
+	result, err := conn.Search(ldap.NewSearchRequest(
+		l.cfg.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, int(l.cfg.Timeout.Seconds()), false, filter, attributes, nil,
+	))
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, "", err
+	}
+	// 多个匹配时无法确定是哪个用户，按用户不存在处理
+	if result == nil || len(result.Entries) != 1 {
+		return nil, "", ErrUnknownUser
+	}
+	entry := result.Entries[0]
+	identity := &Identity{
+		Username: canonicalUsername(entry.GetAttributeValues(l.cfg.UsernameAttribute), username),
+		Email:    entry.GetAttributeValue(l.cfg.EmailAttribute),
+	}
+	if identity.Username == "" {
+		return nil, "", ErrUnknownUser
+	}
+	if identity.Groups, err = l.groups(conn, entry.DN, identity.Username); err != nil {
+		return nil, "", err
+	}
+	return identity, entry.DN, nil
+}
+
+// canonicalUsername 返回目录中保存的用户名，属性有多个值时优先使用与输入相同（忽略大小写和首尾空格）的值
+func canonicalUsername(values []string, input string) string {
+	input = strings.TrimSpace(input)
+	for _, v := range values {
+		if strings.EqualFold(strings.TrimSpace(v), input) {
+			return v
+		}
+	}
+	if len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// groups 查询用户所属的组
+func (l *LDAP) groups(conn *ldap.Conn, dn, username string) ([]string, error) {
+	filter := strings.NewReplacer(
+		"{dn}", ldap.EscapeFilter(dn),
+		"{username}", ldap.EscapeFilter(username),
+	).Replace(l.cfg.GroupFilter)
+	result, err := conn.Search(ldap.NewSearchRequest(
+		l.cfg.GroupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		0, int(l.cfg.Timeout.Seconds()), false, filter, []string{l.cfg.GroupAttribute}, nil,
+	))
+	if err != nil {
+		return nil, err
+	}
+	groups := make([]string, 0, len(result.Entries))
+	for _, entry := range result.Entries {
+		if name := entry.GetAttributeValue(l.cfg.GroupAttribute); name != "" {
+			groups = append(groups, name)
+		}
+	}
+	return groups, nil
+}
@@ -0,0 +1,221 @@
+package authn
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipfans/authgate/authn/ldaptest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var directory = []ldaptest.Entry{
+	{DN: "cn=authgate,ou=services,dc=example,dc=com", Password: "service-secret"},
+	{
+		DN:       "uid=alice,ou=people,dc=example,dc=com",
+		Password: "alice-ldap",
+		Attributes: map[string][]string{
+			"uid":            {"alice"},
+			"mail":           {"alice@example.com"},
+			"sAMAccountName": {"ALICE"},
+		},
+	},
+	{
+		DN:         "uid=bob,ou=people,dc=example,dc=com",
+		Password:   "bob-ldap",
+		Attributes: map[string][]string{"uid": {"bob"}},
+	},
+	// 两个条目使用相同的 uid，无法确定用户
+	{DN: "uid=dup,ou=people,dc=example,dc=com", Password: "dup", Attributes: map[string][]string{"uid": {"dup"}}},
+	{DN: "uid=dup,ou=contractors,dc=example,dc=com", Password: "dup", Attributes: map[string][]string{"uid": {"dup"}}},
+	{
+		DN:         "cn=admins,ou=groups,dc=example,dc=com",
+		Attributes: map[string][]string{"cn": {"admins"}, "member": {"uid=alice,ou=people,dc=example,dc=com"}},
+	},
+	{
+		DN:         "cn=developers,ou=groups,dc=example,dc=com",
+		Attributes: map[string][]string{"cn": {"developers"}, "memberUid": {"bob"}},
+	},
+	{
+		DN: "cn=staff,ou=groups,dc=example,dc=com",
+		Attributes: map[string][]string{"cn": {"staff"}, "member": {
+			"uid=alice,ou=people,dc=example,dc=com",
+			"uid=bob,ou=people,dc=example,dc=com",
+		}},
+	},
+}
+
+func testLDAPConfig(url string) LDAPConfig {
+	return LDAPConfig{
+		URL:          url,
+		BindDN:       "cn=authgate,ou=services,dc=example,dc=com",
+		BindPassword: "service-secret",
+		BaseDN:       "dc=example,dc=com",
+		GroupBaseDN:  "ou=groups,dc=example,dc=com",
+	}
+}
+
+func writeCA(t *testing.T, s *ldaptest.Server) string {
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(path, s.CertPEM, 0o600))
+	return path
+}
+
+func TestLDAPAuthenticate(t *testing.T) {
+	ctx := context.Background()
+	s := ldaptest.NewServer(directory...)
+	defer s.Close()
+	l, err := NewLDAP(testLDAPConfig(s.URL()))
+	require.NoError(t, err)
+	defer l.Close()
+
+	identity, err := l.Authenticate(ctx, "alice", "alice-ldap")
+	require.NoError(t, err)
+	assert.Equal(t, &Identity{Username: "alice", Email: "alice@example.com", Groups: []string{"admins", "staff"}}, identity)
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		want     error
+	}{
+		{"Wrong password", "alice", "wrong", ErrInvalidCredentials},
+		{"Empty password", "alice", "", ErrInvalidCredentials},
+		{"Unknown user", "carol", "x", ErrUnknownUser},
+		{"Ambiguous user", "dup", "dup", ErrUnknownUser},
+		{"Filter injection", "*", "alice-ldap", ErrUnknownUser},
+		{"Filter injection with or", "alice)(uid=*", "alice-ldap", ErrUnknownUser},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := l.Authenticate(ctx, tt.username, tt.password)
+			assert.ErrorIs(t, err, tt.want)
+		})
+	}
+
+	// 用户绑定失败后连接恢复为服务账号，仍可继续使用
+	identity, err = l.Authenticate(ctx, "bob", "bob-ldap")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"staff"}, identity.Groups)
+}
+
+func TestLDAPPool(t *testing.T) {
+	ctx := context.Background()
+	s := ldaptest.NewServer(directory...)
+	defer s.Close()
+	l, err := NewLDAP(testLDAPConfig(s.URL()))
+	require.NoError(t, err)
+	defer l.Close()
+
+	for i := 0; i < 5; i++ {
+		_, err = l.Authenticate(ctx, "alice", "alice-ldap")
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 1, s.Dials())
+
+	// 服务器断开空闲连接后自动重连
+	s.CloseConnections()
+	_, err = l.Authenticate(ctx, "alice", "alice-ldap")
+	require.NoError(t, err)
+	assert.Equal(t, 2, s.Dials())
+}
+
+func TestLDAPTLS(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("StartTLS", func(t *testing.T) {
+		s := ldaptest.NewServer(directory...)
+		defer s.Close()
+		cfg := testLDAPConfig(s.URL())
+		cfg.StartTLS = true
+		cfg.CA = writeCA(t, s)
+		l, err := NewLDAP(cfg)
+		require.NoError(t, err)
+		defer l.Close()
+		_, err = l.Authenticate(ctx, "alice", "alice-ldap")
+		assert.NoError(t, err)
+	})
+
+	t.Run("LDAPS", func(t *testing.T) {
+		s := ldaptest.NewTLSServer(directory...)
+		defer s.Close()
+		cfg := testLDAPConfig(s.URL())
+		cfg.CA = writeCA(t, s)
+		l, err := NewLDAP(cfg)
+		require.NoError(t, err)
+		defer l.Close()
+		_, err = l.Authenticate(ctx, "alice", "alice-ldap")
+		assert.NoError(t, err)
+
+		// 不信任服务端证书时连接失败，不能当作凭据错误
+		untrusted, err := NewLDAP(testLDAPConfig(s.URL()))
+		require.NoError(t, err)
+		_, err = untrusted.Authenticate(ctx, "alice", "alice-ldap")
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, ErrInvalidCredentials)
+	})
+}
+
+func TestLDAPAttributes(t *testing.T) {
+	s := ldaptest.NewServer(directory...)
+	defer s.Close()
+	cfg := testLDAPConfig(s.URL())
+	cfg.UserFilter = "(sAMAccountName={username})"
+	cfg.UsernameAttribute = "uid"
+	cfg.GroupFilter = "(&(cn=admins)(member={dn}))"
+	l, err := NewLDAP(cfg)
+	require.NoError(t, err)
+	defer l.Close()
+
+	identity, err := l.Authenticate(context.Background(), "alice", "alice-ldap")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", identity.Username)
+	assert.Equal(t, []string{"admins"}, identity.Groups)
+}
+
+func TestLDAPCanonicalUsername(t *testing.T) {
+	s := ldaptest.NewServer(directory...)
+	defer s.Close()
+	cfg := testLDAPConfig(s.URL())
+	// memberUid 区分大小写，必须使用目录中的用户名查询
+	cfg.GroupFilter = "(memberUid={username})"
+	l, err := NewLDAP(cfg)
+	require.NoError(t, err)
+	defer l.Close()
+
+	// 输入的大小写和空格不同，用户名都以目录中的 uid 为准
+	for _, input := range []string{"bob", "BOB", " bob", "Bob "} {
+		t.Run(input, func(t *testing.T) {
+			identity, err := l.Authenticate(context.Background(), input, "bob-ldap")
+			require.NoError(t, err)
+			assert.Equal(t, "bob", identity.Username)
+			assert.Equal(t, []string{"developers"}, identity.Groups)
+		})
+	}
+
+	// AD 的过滤条件默认使用 sAMAccountName
+	cfg = testLDAPConfig(s.URL())
+	cfg.UserFilter = "(sAMAccountName={username})"
+	l, err = NewLDAP(cfg)
+	require.NoError(t, err)
+	defer l.Close()
+	identity, err := l.Authenticate(context.Background(), "alice", "alice-ldap")
+	require.NoError(t, err)
+	assert.Equal(t, "ALICE", identity.Username)
+}
+
+func TestNewLDAPConfig(t *testing.T) {
+	for _, cfg := range []LDAPConfig{
+		{URL: "http://ldap.example.com", BaseDN: "dc=example,dc=com"},
+		{URL: "ldap://ldap.example.com"},
+		{URL: "ldaps://ldap.example.com", BaseDN: "dc=example,dc=com", StartTLS: true},
+		{URL: "ldap://ldap.example.com", BaseDN: "dc=example,dc=com", CA: "/nonexistent.pem"},
+		// 无法从过滤条件推断用户名属性
+		{URL: "ldap://ldap.example.com", BaseDN: "dc=example,dc=com", UserFilter: "(mail={username}@example.com)"},
+	} {
+		_, err := NewLDAP(cfg)
+		assert.Error(t, err, cfg.URL)
+	}
+}
@@ -0,0 +1,346 @@
+// Package ldaptest 提供测试用的进程内 LDAP 服务器，支持简单绑定、搜索、StartTLS 和 LDAPS
+package ldaptest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// startTLSOID 是 StartTLS 扩展操作的 OID
+const startTLSOID = "1.3.6.1.4.1.1466.20037"
+
+// Entry 是目录中的一个条目，Password 非空时可以用该条目的 DN 绑定
+type Entry struct {
+	DN         string
+	Password   string
+	Attributes map[string][]string
+}
+
+// Server 是模拟的 LDAP 服务器，匿名连接不能搜索
+type Server struct {
+	// CertPEM 是服务端自签名证书，用作客户端的 CA
+	CertPEM []byte
+
+	ln        net.Listener
+	scheme    string
+	tlsConfig *tls.Config
+
+	mu      sync.Mutex
+	entries []Entry
+	conns   map[net.Conn]struct{}
+	dials   int
+}
+
+// NewServer 启动明文监听的服务器，客户端可以使用 StartTLS 升级
+func NewServer(entries ...Entry) *Server {
+	s := newServer(entries)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	s.start("ldap", ln)
+	return s
+}
+
+// NewTLSServer 启动 LDAPS 服务器
+func NewTLSServer(entries ...Entry) *Server {
+	s := newServer(entries)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", s.tlsConfig)
+	if err != nil {
+		panic(err)
+	}
+	s.start("ldaps", ln)
+	return s
+}
+
+func newServer(entries []Entry) *Server {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ldaptest"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		panic(err)
+	}
+	return &Server{
+		CertPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		tlsConfig: &tls.Config{
+			Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		},
+		entries: entries,
+		conns:   make(map[net.Conn]struct{}),
+	}
+}
+
+func (s *Server) start(scheme string, ln net.Listener) {
+	s.scheme = scheme
+	s.ln = ln
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conns[conn] = struct{}{}
+			s.dials++
+			s.mu.Unlock()
+			go s.serve(conn)
+		}
+	}()
+}
+
+// URL 返回服务器地址，如 ldap://127.0.0.1:389
+func (s *Server) URL() string {
+	return s.scheme + "://" + s.ln.Addr().String()
+}
+
+// Dials 返回服务器接受的连接数
+func (s *Server) Dials() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dials
+}
+
+// CloseConnections 断开所有客户端连接，模拟服务器关闭空闲连接
+func (s *Server) CloseConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+		delete(s.conns, conn)
+	}
+}
+
+// Close 停止监听并断开所有连接
+func (s *Server) Close() {
+	s.ln.Close()
+	s.CloseConnections()
+}
+
+func (s *Server) serve(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+	bound := false
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		id := packet.Children[0].Value
+		op := packet.Children[1]
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			var code int
+			bound, code = s.bind(op)
+			writeResult(conn, id, ldap.ApplicationBindResponse, code)
+		case ldap.ApplicationSearchRequest:
+			if !bound {
+				writeResult(conn, id, ldap.ApplicationSearchResultDone, ldap.LDAPResultInsufficientAccessRights)
+				continue
+			}
+			s.search(conn, id, op)
+		case ldap.ApplicationExtendedRequest:
+			if len(op.Children) == 0 || str(op.Children[0]) != startTLSOID || s.scheme != "ldap" {
+				writeResult(conn, id, ldap.ApplicationExtendedResponse, ldap.LDAPResultProtocolError)
+				continue
+			}
+			writeResult(conn, id, ldap.ApplicationExtendedResponse, ldap.LDAPResultSuccess)
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.conns[tlsConn] = struct{}{}
+			s.mu.Unlock()
+			conn = tlsConn
+		case ldap.ApplicationUnbindRequest:
+			return
+		default:
+			return
+		}
+	}
+}
+
+// str 返回 OCTET STRING 或上下文标签中的原始内容
+func str(p *ber.Packet) string {
+	if p.Data == nil {
+		return ""
+	}
+	return p.Data.String()
+}
+
+// bind 处理简单绑定，DN 和密码均为空时为匿名绑定
+func (s *Server) bind(op *ber.Packet) (bool, int) {
+	if len(op.Children) < 3 {
+		return false, ldap.LDAPResultProtocolError
+	}
+	dn, password := str(op.Children[1]), str(op.Children[2])
+	if dn == "" && password == "" {
+		return false, ldap.LDAPResultSuccess
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, entry := range s.entries {
+		if strings.EqualFold(entry.DN, dn) && entry.Password != "" && entry.Password == password {
+			return true, ldap.LDAPResultSuccess
+		}
+	}
+	return false, ldap.LDAPResultInvalidCredentials
+}
+
+// search 返回 base 之下匹配过滤条件的条目，超出 sizeLimit 时返回 sizeLimitExceeded
+func (s *Server) search(conn net.Conn, id any, op *ber.Packet) {
+	if len(op.Children) < 8 {
+		writeResult(conn, id, ldap.ApplicationSearchResultDone, ldap.LDAPResultProtocolError)
+		return
+	}
+	base := strings.ToLower(str(op.Children[0]))
+	sizeLimit, _ := op.Children[3].Value.(int64)
+	filter := op.Children[6]
+	var attributes []string
+	for _, attr := range op.Children[7].Children {
+		attributes = append(attributes, str(attr))
+	}
+
+	s.mu.Lock()
+	var matched []Entry
+	for _, entry := range s.entries {
+		if strings.HasSuffix(strings.ToLower(entry.DN), base) && match(entry, filter) {
+			matched = append(matched, entry)
+		}
+	}
+	s.mu.Unlock()
+
+	code := ldap.LDAPResultSuccess
+	if sizeLimit > 0 && int64(len(matched)) > sizeLimit {
+		matched = matched[:sizeLimit]
+		code = ldap.LDAPResultSizeLimitExceeded
+	}
+	for _, entry := range matched {
+		result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "")
+		result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.DN, ""))
+		attrs := ber.NewSequence("")
+		for _, name := range attributes {
+			values, ok := attribute(entry, name)
+			if !ok {
+				continue
+			}
+			attr := ber.NewSequence("")
+			attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, ""))
+			set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+			for _, v := range values {
+				set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, ""))
+			}
+			attr.AppendChild(set)
+			attrs.AppendChild(attr)
+		}
+		result.AppendChild(attrs)
+		write(conn, id, result)
+	}
+	writeResult(conn, id, ldap.ApplicationSearchResultDone, code)
+}
+
+// attribute 按不区分大小写的属性名读取条目的值
+func attribute(entry Entry, name string) ([]string, bool) {
+	for k, v := range entry.Attributes {
+		if strings.EqualFold(k, name) {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// caseExactAttributes 是区分大小写比较的属性，如 RFC 2307 的 memberUid
+var caseExactAttributes = []string{"memberUid"}
+
+// equal 按属性的匹配规则比较，与目录服务器一样忽略首尾空格，大部分属性不区分大小写
+func equal(name, a, b string) bool {
+	a, b = strings.TrimSpace(a), strings.TrimSpace(b)
+	for _, exact := range caseExactAttributes {
+		if strings.EqualFold(name, exact) {
+			return a == b
+		}
+	}
+	return strings.EqualFold(a, b)
+}
+
+// match 计算过滤条件，支持与、或、非、相等和存在判断
+func match(entry Entry, filter *ber.Packet) bool {
+	switch filter.Tag {
+	case ldap.FilterAnd:
+		for _, child := range filter.Children {
+			if !match(entry, child) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterOr:
+		for _, child := range filter.Children {
+			if match(entry, child) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterNot:
+		return len(filter.Children) == 1 && !match(entry, filter.Children[0])
+	case ldap.FilterEqualityMatch:
+		if len(filter.Children) != 2 {
+			return false
+		}
+		name := str(filter.Children[0])
+		values, _ := attribute(entry, name)
+		for _, v := range values {
+			if equal(name, v, str(filter.Children[1])) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterPresent:
+		_, ok := attribute(entry, str(filter))
+		return ok
+	default:
+		return false
+	}
+}
+
+func write(conn net.Conn, id any, op *ber.Packet) {
+	packet := ber.NewSequence("")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+	packet.AppendChild(op)
+	conn.Write(packet.Bytes())
+}
+
+func writeResult(conn net.Conn, id any, tag ber.Tag, code int) {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), ""))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	write(conn, id, op)
+}
@@ -0,0 +1,42 @@
+package authn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ipfans/authgate/models"
+	"github.com/ipfans/authgate/passwd"
+)
+
+// Static 使用配置中的账号校验密码，常用于目录服务不可用时的应急管理员。
+// 账号与 users 使用相同的配置格式，但不保存到用户存储中
+type Static struct {
+	users map[string]*models.User
+}
+
+func NewStatic(users []*models.User) (*Static, error) {
+	s := &Static{users: make(map[string]*models.User, len(users))}
+	for _, u := range users {
+		if u.Username == "" {
+			return nil, errors.New("static: username is required")
+		}
+		if err := passwd.Validate(u.PasswordHash); err != nil {
+			return nil, fmt.Errorf("static: user %q: %w", u.Username, err)
+		}
+		s.users[u.Username] = u
+	}
+	return s, nil
+}
+
+func (s *Static) Authenticate(ctx context.Context, username, password string) (*Identity, error) {
+	u, ok := s.users[username]
+	if !ok {
+		passwd.VerifyDummy(password)
+		return nil, ErrUnknownUser
+	}
+	if !passwd.Verify(u.PasswordHash, password) {
+		return nil, ErrInvalidCredentials
+	}
+	return &Identity{Username: u.Username, Email: u.Email, Groups: u.Groups}, nil
+}
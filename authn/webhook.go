@@ -0,0 +1,96 @@
+package authn
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/ipfans/authgate/utils/defaults"
+)
+
+// WebhookConfig 是外部认证服务的配置
+type WebhookConfig struct {
+	URL     string            `koanf:"url"`     // 接收 POST 请求的地址，应使用 https
+	Headers map[string]string `koanf:"headers"` // 附加的请求头，如用于认证 AuthGate 的 Authorization
+	Timeout time.Duration     `koanf:"timeout"` // 默认 5 秒
+}
+
+// webhookRequest 是发送给外部认证服务的请求体
+type webhookRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// webhookResponse 是认证通过时外部认证服务返回的用户信息，username 为空时使用登录时输入的用户名
+type webhookResponse struct {
+	Username string   `json:"username"`
+	Email    string   `json:"email"`
+	Groups   []string `json:"groups"`
+}
+
+// Webhook 把用户名和密码以 JSON 发送给外部认证服务。
+// 200 表示认证通过，401 和 403 表示密码错误，404 表示用户不存在，其他状态码表示服务不可用
+type Webhook struct {
+	cfg    WebhookConfig
+	client *http.Client
+}
+
+func NewWebhook(cfg WebhookConfig) (*Webhook, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("webhook: invalid url %q", cfg.URL)
+	}
+	return &Webhook{
+		cfg:    cfg,
+		client: &http.Client{Timeout: defaults.Get(cfg.Timeout, 5*time.Second)},
+	}, nil
+}
+
+func (w *Webhook) Authenticate(ctx context.Context, username, password string) (*Identity, error) {
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+	body, err := json.Marshal(webhookRequest{Username: username, Password: password})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	for k, v := range w.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return nil, ErrInvalidCredentials
+	case http.StatusNotFound:
+		return nil, ErrUnknownUser
+	default:
+		return nil, fmt.Errorf("webhook: unexpected status %d", resp.StatusCode)
+	}
+	var result webhookResponse
+	if err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&result); err != nil {
+		return nil, errors.Join(errors.New("webhook: invalid response"), err)
+	}
+	return &Identity{
+		Username: defaults.Get(result.Username, username),
+		Email:    result.Email,
+		Groups:   result.Groups,
+	}, nil
+}
@@ -0,0 +1,76 @@
+package authn
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhook(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer hook-secret" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var req webhookRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch {
+		case req.Username == "slow":
+			time.Sleep(200 * time.Millisecond)
+		case req.Username == "carol" && req.Password == "carol-pw":
+			json.NewEncoder(w).Encode(webhookResponse{Username: "Carol", Email: "carol@example.com", Groups: []string{"ops"}})
+		case req.Username == "carol":
+			w.WriteHeader(http.StatusUnauthorized)
+		case req.Username == "broken":
+			w.Write([]byte("not json"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	w, err := NewWebhook(WebhookConfig{
+		URL:     ts.URL,
+		Headers: map[string]string{"Authorization": "Bearer hook-secret"},
+		Timeout: 100 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	identity, err := w.Authenticate(ctx, "carol", "carol-pw")
+	require.NoError(t, err)
+	assert.Equal(t, &Identity{Username: "Carol", Email: "carol@example.com", Groups: []string{"ops"}}, identity)
+
+	tests := []struct {
+		username string
+		password string
+		want     error
+	}{
+		{"carol", "wrong", ErrInvalidCredentials},
+		{"carol", "", ErrInvalidCredentials},
+		{"dave", "x", ErrUnknownUser},
+	}
+	for _, tt := range tests {
+		_, err = w.Authenticate(ctx, tt.username, tt.password)
+		assert.ErrorIs(t, err, tt.want, tt.username)
+	}
+
+	// 超时和无效响应表示服务不可用
+	for _, username := range []string{"slow", "broken"} {
+		_, err = w.Authenticate(ctx, username, "x")
+		assert.Error(t, err, username)
+		assert.NotErrorIs(t, err, ErrInvalidCredentials, username)
+	}
+
+	_, err = NewWebhook(WebhookConfig{URL: "ftp://auth.example.com"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,275 @@
+package authz
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfans/authgate/utils/defaults"
+)
+
+// sweepInterval 是清理过期缓存的最小间隔
+const sweepInterval = time.Minute
+
+// 不发送给授权服务的请求头，避免泄露 AuthGate 的令牌和 Cookie
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+}
+
+// 每个请求都不同的请求 ID 和链路追踪头，授权服务可以收到但不参与缓存，否则缓存永远不会命中
+var perRequestHeaders = map[string]bool{
+	"X-Request-Id":          true,
+	"X-Correlation-Id":      true,
+	"X-Trace-Id":            true,
+	"Request-Id":            true,
+	"Traceparent":           true,
+	"Tracestate":            true,
+	"Uber-Trace-Id":         true,
+	"Sentry-Trace":          true,
+	"B3":                    true,
+	"X-B3-Traceid":          true,
+	"X-B3-Spanid":           true,
+	"X-B3-Parentspanid":     true,
+	"X-B3-Sampled":          true,
+	"X-B3-Flags":            true,
+	"X-Amzn-Trace-Id":       true,
+	"X-Cloud-Trace-Context": true,
+}
+
+type Config struct {
+	Timeout        time.Duration     `koanf:"timeout"`         // 默认 2 秒
+	FailOpen       bool              `koanf:"fail_open"`       // 授权服务不可用时放行，默认拒绝
+	CacheTTL       time.Duration     `koanf:"cache_ttl"`       // 缓存授权结果的时间，为 0 时不缓存
+	CacheSize      int               `koanf:"cache_size"`      // 最多缓存的结果数，超出时淘汰最久未使用的，默认 10000
+	Headers        map[string]string `koanf:"headers"`         // 附加的请求头，如用于认证 AuthGate 的 Authorization
+	ForwardHeaders []string          `koanf:"forward_headers"` // 只发送这些请求头，为空时发送除敏感请求头外的全部请求头
+}
+
+// User 是已登录用户的身份
+type User struct {
+	Username string   `json:"username"`
+	Email    string   `json:"email,omitempty"`
+	Groups   []string `json:"groups"`
+}
+
+// Request 是发送给授权服务的请求体
+type Request struct {
+	User    User              `json:"user"`
+	Method  string            `json:"method"`
+	Host    string            `json:"host"`
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers"`
+}
+
+type cacheEntry struct {
+	key     string
+	allow   bool
+	expires time.Time
+}
+
+// Client 把请求交给外部授权服务判断，200 表示允许，401 和 403 表示拒绝，
+// 其他状态码、超时和网络错误按 fail_open 处理
+type Client struct {
+	url     string
+	cfg     Config
+	forward map[string]bool
+	client  *http.Client
+	now     func() time.Time
+
+	mu        sync.Mutex
+	cache     map[string]*list.Element
+	lru       *list.List // 最近使用的在前
+	lastSweep time.Time
+}
+
+// New 创建授权服务客户端，rawURL 为空时返回 nil
+func New(rawURL string, cfg Config) (*Client, error) {
+	if rawURL == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid authz_url %q", rawURL)
+	}
+	if cfg.CacheTTL < 0 {
+		return nil, fmt.Errorf("authz: cache_ttl must not be negative")
+	}
+	if cfg.CacheSize < 0 {
+		return nil, fmt.Errorf("authz: cache_size must not be negative")
+	}
+	cfg.CacheSize = defaults.Get(cfg.CacheSize, 10000)
+	var forward map[string]bool
+	if len(cfg.ForwardHeaders) > 0 {
+		forward = make(map[string]bool, len(cfg.ForwardHeaders))
+		for _, k := range cfg.ForwardHeaders {
+			forward[http.CanonicalHeaderKey(k)] = true
+		}
+	}
+	return &Client{
+		url:     rawURL,
+		cfg:     cfg,
+		forward: forward,
+		client:  &http.Client{Timeout: defaults.Get(cfg.Timeout, 2*time.Second)},
+		now:     time.Now,
+		cache:   make(map[string]*list.Element),
+		lru:     list.New(),
+	}, nil
+}
+
+// Allow 判断是否允许请求。授权服务不可用时按 fail_open 返回结果，同时返回错误以便记录日志
+func (c *Client) Allow(ctx context.Context, r Request) (bool, error) {
+	r.Headers = c.forwardHeaders(r.Headers)
+	key := cacheKey(r)
+	if allow, ok := c.cached(key); ok {
+		return allow, nil
+	}
+	allow, err := c.check(ctx, r)
+	if err != nil {
+		return c.cfg.FailOpen, err
+	}
+	c.store(key, allow)
+	return allow, nil
+}
+
+func (c *Client) check(ctx context.Context, r Request) (bool, error) {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return false, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range c.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("authz: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return false, nil
+	default:
+		return false, fmt.Errorf("authz: unexpected status %d", resp.StatusCode)
+	}
+}
+
+func (c *Client) cached(key string) (allow, ok bool) {
+	if c.cfg.CacheTTL == 0 {
+		return false, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.cache[key]
+	if !ok {
+		return false, false
+	}
+	e := el.Value.(*cacheEntry)
+	if !c.now().Before(e.expires) {
+		c.remove(el)
+		return false, false
+	}
+	c.lru.MoveToFront(el)
+	return e.allow, true
+}
+
+func (c *Client) store(key string, allow bool) {
+	if c.cfg.CacheTTL == 0 {
+		return
+	}
+	now := c.now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now.Sub(c.lastSweep) > sweepInterval {
+		for _, el := range c.cache {
+			if !now.Before(el.Value.(*cacheEntry).expires) {
+				c.remove(el)
+			}
+		}
+		c.lastSweep = now
+	}
+	if el, ok := c.cache[key]; ok {
+		c.remove(el)
+	}
+	c.cache[key] = c.lru.PushFront(&cacheEntry{key: key, allow: allow, expires: now.Add(c.cfg.CacheTTL)})
+	for c.lru.Len() > c.cfg.CacheSize {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove 删除一条缓存，调用方需持有 mu
+func (c *Client) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.cache, el.Value.(*cacheEntry).key)
+}
+
+// forwardHeaders 按 forward_headers 筛选发送给授权服务的请求头
+func (c *Client) forwardHeaders(h map[string]string) map[string]string {
+	if c.forward == nil {
+		return h
+	}
+	headers := make(map[string]string, len(c.forward))
+	for k, v := range h {
+		if c.forward[http.CanonicalHeaderKey(k)] {
+			headers[k] = v
+		}
+	}
+	return headers
+}
+
+// cacheKey 由用户、组、方法、域名、路径和发送给授权服务的请求头组成，
+// 授权服务可能依据请求头判断，请求头不同的请求不能共用缓存，请求 ID 和链路追踪头除外。
+// key 取哈希以限制缓存占用的内存
+func cacheKey(r Request) string {
+	groups := append([]string(nil), r.User.Groups...)
+	sort.Strings(groups)
+	names := make([]string, 0, len(r.Headers))
+	for k := range r.Headers {
+		if !perRequestHeaders[http.CanonicalHeaderKey(k)] {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	for _, v := range []string{r.User.Username, strings.Join(groups, ","), r.Method, r.Host, r.Path} {
+		h.Write([]byte(v))
+		h.Write([]byte{0})
+	}
+	for _, k := range names {
+		h.Write([]byte(k))
+		h.Write([]byte{0})
+		h.Write([]byte(r.Headers[k]))
+		h.Write([]byte{0})
+	}
+	return string(h.Sum(nil))
+}
+
+// FilterHeaders 去掉不应发送给授权服务的请求头，同名的多个值以逗号连接
+func FilterHeaders(h http.Header) map[string]string {
+	headers := make(map[string]string, len(h))
+	for k, v := range h {
+		k = http.CanonicalHeaderKey(k)
+		if sensitiveHeaders[k] {
+			continue
+		}
+		headers[k] = strings.Join(v, ", ")
+	}
+	return headers
+}
@@ -0,0 +1,246 @@
+package authz
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	c, err := New("", Config{})
+	require.NoError(t, err)
+	assert.Nil(t, c)
+
+	for _, u := range []string{"ftp://authz.example.com", "/authz", "http://"} {
+		_, err = New(u, Config{})
+		assert.Error(t, err, u)
+	}
+	_, err = New("https://authz.example.com", Config{CacheTTL: -time.Second})
+	assert.Error(t, err)
+	_, err = New("https://authz.example.com", Config{CacheSize: -1})
+	assert.Error(t, err)
+}
+
+func TestAllow(t *testing.T) {
+	var got Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		switch got.User.Username {
+		case "alice":
+			w.WriteHeader(http.StatusOK)
+		case "bob":
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name     string
+		username string
+		failOpen bool
+		allow    bool
+		err      bool
+	}{
+		{name: "allowed", username: "alice", allow: true},
+		{name: "denied", username: "bob"},
+		{name: "denied ignores fail open", username: "bob", failOpen: true},
+		{name: "fail closed", username: "carol", err: true},
+		{name: "fail open", username: "carol", failOpen: true, allow: true, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := New(srv.URL, Config{FailOpen: tt.failOpen, Headers: map[string]string{"Authorization": "Bearer secret"}})
+			require.NoError(t, err)
+			allow, err := c.Allow(context.Background(), Request{
+				User:    User{Username: tt.username, Groups: []string{"ops"}},
+				Method:  http.MethodGet,
+				Host:    "app.example.com",
+				Path:    "/deploy",
+				Headers: map[string]string{"X-Ticket": "OPS-1"},
+			})
+			assert.Equal(t, tt.allow, allow)
+			assert.Equal(t, tt.err, err != nil)
+			assert.Equal(t, tt.username, got.User.Username)
+			assert.Equal(t, []string{"ops"}, got.User.Groups)
+			assert.Equal(t, "/deploy", got.Path)
+			assert.Equal(t, "OPS-1", got.Headers["X-Ticket"])
+		})
+	}
+}
+
+func TestAllowForwardHeaders(t *testing.T) {
+	var got Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+	}))
+	defer srv.Close()
+
+	c, err := New(srv.URL, Config{ForwardHeaders: []string{"x-ticket"}})
+	require.NoError(t, err)
+	_, err = c.Allow(context.Background(), Request{
+		User:    User{Username: "alice"},
+		Headers: map[string]string{"X-Ticket": "OPS-1", "User-Agent": "curl/8.0"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"X-Ticket": "OPS-1"}, got.Headers)
+}
+
+func TestAllowTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	c, err := New(srv.URL, Config{Timeout: 50 * time.Millisecond})
+	require.NoError(t, err)
+	allow, err := c.Allow(context.Background(), Request{User: User{Username: "alice"}})
+	assert.False(t, allow)
+	assert.Error(t, err)
+}
+
+func TestAllowCache(t *testing.T) {
+	var calls atomic.Int32
+	status := atomic.Int32{}
+	status.Store(http.StatusOK)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer srv.Close()
+
+	c, err := New(srv.URL, Config{CacheTTL: time.Minute})
+	require.NoError(t, err)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	r := Request{User: User{Username: "alice", Groups: []string{"b", "a"}}, Method: http.MethodGet, Path: "/"}
+
+	allow, err := c.Allow(context.Background(), r)
+	require.NoError(t, err)
+	assert.True(t, allow)
+	// 组的顺序不影响缓存
+	r.User.Groups = []string{"a", "b"}
+	status.Store(http.StatusForbidden)
+	allow, err = c.Allow(context.Background(), r)
+	require.NoError(t, err)
+	assert.True(t, allow)
+	assert.Equal(t, int32(1), calls.Load())
+
+	// 请求头不同时单独判断
+	r.Headers = map[string]string{"X-Ticket": "OPS-1"}
+	allow, _ = c.Allow(context.Background(), r)
+	assert.False(t, allow)
+	assert.Equal(t, int32(2), calls.Load())
+
+	// 请求 ID 不影响缓存
+	r.Headers = map[string]string{"X-Ticket": "OPS-1", "X-Request-Id": "abc"}
+	allow, _ = c.Allow(context.Background(), r)
+	assert.False(t, allow)
+	assert.Equal(t, int32(2), calls.Load())
+	r.Headers = nil
+
+	// 不同路径单独判断
+	r.Path = "/admin"
+	allow, _ = c.Allow(context.Background(), r)
+	assert.False(t, allow)
+	assert.Equal(t, int32(3), calls.Load())
+
+	// 过期后重新询问
+	now = now.Add(time.Minute)
+	r.Path = "/"
+	allow, _ = c.Allow(context.Background(), r)
+	assert.False(t, allow)
+	assert.Equal(t, int32(4), calls.Load())
+
+	// 授权服务出错时不缓存
+	status.Store(http.StatusBadGateway)
+	r.Path = "/error"
+	_, err = c.Allow(context.Background(), r)
+	assert.Error(t, err)
+	status.Store(http.StatusOK)
+	allow, err = c.Allow(context.Background(), r)
+	require.NoError(t, err)
+	assert.True(t, allow)
+}
+
+func TestCacheKey(t *testing.T) {
+	r := Request{User: User{Username: "alice"}, Method: http.MethodGet, Host: "app.example.com", Path: "/"}
+	base := cacheKey(r)
+
+	tests := []struct {
+		name   string
+		modify func(r *Request)
+	}{
+		{"header value", func(r *Request) { r.Headers = map[string]string{"X-Ticket": "OPS-1"} }},
+		{"header name", func(r *Request) { r.Headers = map[string]string{"X-Other": ""} }},
+		{"fields do not run together", func(r *Request) { r.User.Username, r.Method = "aliceGET", "" }},
+		{"host", func(r *Request) { r.Host = "admin.example.com" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			other := r
+			tt.modify(&other)
+			assert.NotEqual(t, base, cacheKey(other))
+		})
+	}
+
+	// 请求头的顺序不影响缓存
+	a, b := r, r
+	a.Headers = map[string]string{"X-A": "1", "X-B": "2"}
+	b.Headers = map[string]string{"X-B": "2", "X-A": "1"}
+	assert.Equal(t, cacheKey(a), cacheKey(b))
+
+	// 请求 ID 和链路追踪头不影响缓存
+	b.Headers = map[string]string{"X-A": "1", "X-B": "2", "X-Request-Id": "abc", "Traceparent": "00-abc-def-01"}
+	assert.Equal(t, cacheKey(a), cacheKey(b))
+}
+
+func TestCacheSize(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+	}))
+	defer srv.Close()
+
+	c, err := New(srv.URL, Config{CacheTTL: time.Minute, CacheSize: 2})
+	require.NoError(t, err)
+	allow := func(path string) {
+		ok, err := c.Allow(context.Background(), Request{User: User{Username: "alice"}, Path: path})
+		require.NoError(t, err)
+		assert.True(t, ok)
+	}
+
+	allow("/a")
+	allow("/b")
+	allow("/a") // /a 最近使用过，/b 先被淘汰
+	assert.Equal(t, int32(2), calls.Load())
+	allow("/c")
+	assert.Equal(t, 2, c.lru.Len())
+	assert.Len(t, c.cache, 2)
+
+	allow("/a")
+	assert.Equal(t, int32(3), calls.Load())
+	allow("/b")
+	assert.Equal(t, int32(4), calls.Load())
+}
+
+func TestFilterHeaders(t *testing.T) {
+	h := http.Header{}
+	h.Set("Authorization", "Bearer token")
+	h.Set("Cookie", "authgate_token=x")
+	h.Set("proxy-authorization", "Basic x")
+	h.Add("X-Ticket", "OPS-1")
+	h.Add("X-Ticket", "OPS-2")
+	assert.Equal(t, map[string]string{"X-Ticket": "OPS-1, OPS-2"}, FilterHeaders(h))
+}
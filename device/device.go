@@ -0,0 +1,266 @@
+// Package device 实现 OAuth 2.0 设备授权（RFC 8628）的授权请求管理，
+// 没有浏览器的命令行工具通过 device_code 轮询，用户在其他设备上输入 user_code 批准
+package device
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/ipfans/authgate/utils/random"
+)
+
+var (
+	// ErrNotFound 表示授权请求不存在、已过期或已处理
+	ErrNotFound = errors.New("device: authorization not found")
+	// ErrPending 表示用户尚未批准，对应 authorization_pending
+	ErrPending = errors.New("device: authorization pending")
+	// ErrSlowDown 表示轮询过于频繁，对应 slow_down
+	ErrSlowDown = errors.New("device: slow down")
+	// ErrExpired 表示 device_code 已过期，对应 expired_token
+	ErrExpired = errors.New("device: expired")
+	// ErrDenied 表示用户拒绝了授权，对应 access_denied
+	ErrDenied = errors.New("device: access denied")
+	// ErrExists 表示 user_code 或 device_code 已被其他授权请求占用
+	ErrExists = errors.New("device: code already in use")
+)
+
+// userCodeAlphabet 不含元音和易混淆字符，避免组成单词或输错
+const userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+
+// userCodeLength 是 user_code 的字符数，显示为 XXXX-XXXX
+const userCodeLength = 8
+
+// startAttempts 是 user_code 冲突时重新生成的次数
+const startAttempts = 5
+
+type Config struct {
+	Enabled   bool          `koanf:"enabled"`
+	ExpiresIn time.Duration `koanf:"expires_in"` // device_code 的有效期，默认 10 分钟
+	Interval  time.Duration `koanf:"interval"`   // 最小轮询间隔，默认 5 秒
+}
+
+// Approver 是批准授权的用户在批准时的登录信息，令牌按这些信息签发，
+// 不要求用户存在于用户存储中
+type Approver struct {
+	Username string   `json:"username"`
+	Email    string   `json:"email,omitempty"`
+	Groups   []string `json:"groups,omitempty"`
+	AMR      []string `json:"amr,omitempty"` // 批准时登录使用的认证方式
+	IDP      string   `json:"idp,omitempty"` // 通过外部身份提供方登录时为其 issuer
+}
+
+// Authorization 是一次设备授权请求
+type Authorization struct {
+	DeviceCodeHash string        `json:"device_code_hash"`
+	UserCode       string        `json:"user_code"`
+	ClientID       string        `json:"client_id,omitempty"`
+	Approver       *Approver     `json:"approver,omitempty"` // 批准授权的用户，为空时尚未批准
+	Denied         bool          `json:"denied,omitempty"`
+	Interval       time.Duration `json:"interval"`
+	ExpiresAt      time.Time     `json:"expires_at"`
+	LastPoll       time.Time     `json:"last_poll,omitempty"`
+}
+
+// Approved 判断用户是否已批准授权
+func (a *Authorization) Approved() bool {
+	return a.Approver != nil
+}
+
+// Store 保存授权请求，多实例部署时可以使用共享存储实现。
+// Create、Resolve、SetPoll 和 Redeem 需要是原子操作，并发请求不能重复处理、重复兑换或覆盖彼此的修改
+type Store interface {
+	// Create 保存新的授权请求，过期时间为 a.ExpiresAt，user_code 或 device_code 已被未过期的请求占用时返回 ErrExists
+	Create(ctx context.Context, a *Authorization) error
+	// Resolve 在授权请求尚未被批准或拒绝时记录结果，approver 为 nil 表示拒绝。
+	// 请求不存在、在 now 时已过期或已被处理时返回 ErrNotFound，同时提交的批准和拒绝只有一个成功
+	Resolve(ctx context.Context, hash string, approver *Approver, now time.Time) error
+	// SetPoll 只更新授权请求的最近轮询时间和轮询间隔，不覆盖同时发生的批准或拒绝，请求不存在时返回 ErrNotFound
+	SetPoll(ctx context.Context, hash string, lastPoll time.Time, interval time.Duration) error
+	// Redeem 在授权请求已被批准或拒绝时将其删除并返回，尚未处理时返回 ErrPending，
+	// 请求不存在或已被其他请求兑换时返回 ErrNotFound
+	Redeem(ctx context.Context, hash string) (*Authorization, error)
+	// GetByDeviceCode 按 device_code 的哈希查找授权请求
+	GetByDeviceCode(ctx context.Context, hash string) (*Authorization, error)
+	// GetByUserCode 按 user_code 查找授权请求
+	GetByUserCode(ctx context.Context, userCode string) (*Authorization, error)
+	// Delete 删除授权请求
+	Delete(ctx context.Context, a *Authorization) error
+}
+
+// Flow 管理设备授权请求的创建、批准和轮询
+type Flow struct {
+	store     Store
+	expiresIn time.Duration
+	interval  time.Duration
+	now       func() time.Time
+}
+
+func New(cfg Config, store Store) *Flow {
+	f := &Flow{store: store, expiresIn: cfg.ExpiresIn, interval: cfg.Interval, now: time.Now}
+	if f.expiresIn <= 0 {
+		f.expiresIn = 10 * time.Minute
+	}
+	if f.interval <= 0 {
+		f.interval = 5 * time.Second
+	}
+	return f
+}
+
+// ExpiresIn 返回 device_code 的有效期
+func (f *Flow) ExpiresIn() time.Duration {
+	return f.expiresIn
+}
+
+// hashDeviceCode 返回 device_code 的哈希，存储中只保存哈希
+func hashDeviceCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// newUserCode 生成 user_code，丢弃超出字母表长度整数倍的随机字节，使每个字符的概率相同
+func newUserCode() (string, error) {
+	limit := 256 - 256%len(userCodeAlphabet)
+	code := make([]byte, 0, userCodeLength)
+	b := make([]byte, userCodeLength)
+	for len(code) < userCodeLength {
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		for _, v := range b {
+			if int(v) < limit && len(code) < userCodeLength {
+				code = append(code, userCodeAlphabet[int(v)%len(userCodeAlphabet)])
+			}
+		}
+	}
+	return string(code), nil
+}
+
+// NormalizeUserCode 规范化用户输入的 user_code，忽略大小写、空格和连字符
+func NormalizeUserCode(input string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(input) {
+		if strings.ContainsRune(userCodeAlphabet, r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// FormatUserCode 返回便于阅读的 user_code，如 BCDF-GHJK
+func FormatUserCode(code string) string {
+	if len(code) != userCodeLength {
+		return code
+	}
+	return code[:userCodeLength/2] + "-" + code[userCodeLength/2:]
+}
+
+// Start 创建授权请求，返回交给客户端的 device_code。user_code 与未过期的请求冲突时重新生成
+func (f *Flow) Start(ctx context.Context, clientID string) (string, *Authorization, error) {
+	for range startAttempts {
+		deviceCode, err := random.String(32)
+		if err != nil {
+			return "", nil, err
+		}
+		userCode, err := newUserCode()
+		if err != nil {
+			return "", nil, err
+		}
+		a := &Authorization{
+			DeviceCodeHash: hashDeviceCode(deviceCode),
+			UserCode:       userCode,
+			ClientID:       clientID,
+			Interval:       f.interval,
+			ExpiresAt:      f.now().Add(f.expiresIn),
+		}
+		err = f.store.Create(ctx, a)
+		if errors.Is(err, ErrExists) {
+			continue
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		return deviceCode, a, nil
+	}
+	return "", nil, ErrExists
+}
+
+// Lookup 按用户输入的 user_code 查找等待批准的授权请求
+func (f *Flow) Lookup(ctx context.Context, userCode string) (*Authorization, error) {
+	a, err := f.store.GetByUserCode(ctx, NormalizeUserCode(userCode))
+	if err != nil {
+		return nil, err
+	}
+	if !f.now().Before(a.ExpiresAt) || a.Approved() || a.Denied {
+		return nil, ErrNotFound
+	}
+	return a, nil
+}
+
+// Approve 由已登录的用户批准授权请求
+func (f *Flow) Approve(ctx context.Context, userCode string, approver Approver) error {
+	return f.resolve(ctx, userCode, &approver)
+}
+
+// Deny 拒绝授权请求
+func (f *Flow) Deny(ctx context.Context, userCode string) error {
+	return f.resolve(ctx, userCode, nil)
+}
+
+// resolve 记录批准或拒绝，请求已被处理时返回 ErrNotFound
+func (f *Flow) resolve(ctx context.Context, userCode string, approver *Approver) error {
+	a, err := f.Lookup(ctx, userCode)
+	if err != nil {
+		return err
+	}
+	return f.store.Resolve(ctx, a.DeviceCodeHash, approver, f.now())
+}
+
+// Poll 处理客户端的轮询，批准后返回授权请求并将其删除。兑换在存储中原子地完成，
+// 并发轮询同一个 device_code 时只有一个请求成功。过期的请求同样会被删除
+func (f *Flow) Poll(ctx context.Context, deviceCode string) (*Authorization, error) {
+	if deviceCode == "" {
+		return nil, ErrNotFound
+	}
+	hash := hashDeviceCode(deviceCode)
+	a, err := f.store.GetByDeviceCode(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	now := f.now()
+	if !now.Before(a.ExpiresAt) {
+		return nil, errors.Join(ErrExpired, f.store.Delete(ctx, a))
+	}
+	if a.Approved() || a.Denied {
+		return f.redeem(ctx, hash)
+	}
+	// 轮询过快时按 RFC 8628 3.5 增加 5 秒间隔
+	tooFast := !a.LastPoll.IsZero() && now.Sub(a.LastPoll) < a.Interval
+	interval := a.Interval
+	if tooFast {
+		interval += 5 * time.Second
+	}
+	if err = f.store.SetPoll(ctx, hash, now, interval); err != nil {
+		return nil, err
+	}
+	if tooFast {
+		return nil, ErrSlowDown
+	}
+	return nil, ErrPending
+}
+
+// redeem 兑换已批准或已拒绝的授权请求
+func (f *Flow) redeem(ctx context.Context, hash string) (*Authorization, error) {
+	a, err := f.store.Redeem(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	if a.Denied {
+		return nil, ErrDenied
+	}
+	return a, nil
+}
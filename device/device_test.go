@@ -0,0 +1,222 @@
+package device
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestFlow() (*Flow, *time.Time) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	f := New(Config{}, NewMemory())
+	f.now = func() time.Time { return now }
+	return f, &now
+}
+
+func TestNormalizeUserCode(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"BCDF-GHJK", "BCDFGHJK"},
+		{"bcdf ghjk", "BCDFGHJK"},
+		{" bcdf-ghjk\n", "BCDFGHJK"},
+		{"AEIO-0123", ""},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, NormalizeUserCode(tt.input), tt.input)
+	}
+	assert.Equal(t, "BCDF-GHJK", FormatUserCode("BCDFGHJK"))
+}
+
+func TestApproveAndPoll(t *testing.T) {
+	ctx := context.Background()
+	f, now := newTestFlow()
+
+	deviceCode, a, err := f.Start(ctx, "cli")
+	require.NoError(t, err)
+	assert.Len(t, a.UserCode, userCodeLength)
+	assert.NotContains(t, a.DeviceCodeHash, deviceCode)
+
+	_, err = f.Poll(ctx, deviceCode)
+	assert.ErrorIs(t, err, ErrPending)
+
+	// 未等待间隔再次轮询时要求放慢速度
+	_, err = f.Poll(ctx, deviceCode)
+	assert.ErrorIs(t, err, ErrSlowDown)
+	*now = now.Add(10 * time.Second)
+	_, err = f.Poll(ctx, deviceCode)
+	assert.ErrorIs(t, err, ErrPending)
+
+	require.NoError(t, f.Approve(ctx, FormatUserCode(a.UserCode), Approver{
+		Username: "alice",
+		Groups:   []string{"admins"},
+		AMR:      []string{"pwd"},
+	}))
+	// 批准后不能再次处理
+	assert.ErrorIs(t, f.Deny(ctx, a.UserCode), ErrNotFound)
+
+	got, err := f.Poll(ctx, deviceCode)
+	require.NoError(t, err)
+	assert.Equal(t, &Approver{Username: "alice", Groups: []string{"admins"}, AMR: []string{"pwd"}}, got.Approver)
+
+	// device_code 只能兑换一次
+	_, err = f.Poll(ctx, deviceCode)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestDeny(t *testing.T) {
+	ctx := context.Background()
+	f, _ := newTestFlow()
+
+	deviceCode, a, err := f.Start(ctx, "cli")
+	require.NoError(t, err)
+	require.NoError(t, f.Deny(ctx, a.UserCode))
+	_, err = f.Poll(ctx, deviceCode)
+	assert.ErrorIs(t, err, ErrDenied)
+	_, err = f.Poll(ctx, deviceCode)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestExpired(t *testing.T) {
+	ctx := context.Background()
+	f, now := newTestFlow()
+
+	deviceCode, a, err := f.Start(ctx, "cli")
+	require.NoError(t, err)
+	*now = now.Add(f.ExpiresIn())
+
+	_, err = f.Lookup(ctx, a.UserCode)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorIs(t, f.Approve(ctx, a.UserCode, Approver{Username: "alice"}), ErrNotFound)
+	_, err = f.Poll(ctx, deviceCode)
+	assert.ErrorIs(t, err, ErrExpired)
+	// 过期的请求被删除
+	_, err = f.Poll(ctx, deviceCode)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	_, err = f.Poll(ctx, "unknown")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestConcurrentPoll(t *testing.T) {
+	ctx := context.Background()
+	f, _ := newTestFlow()
+
+	deviceCode, a, err := f.Start(ctx, "cli")
+	require.NoError(t, err)
+	require.NoError(t, f.Approve(ctx, a.UserCode, Approver{Username: "alice"}))
+
+	var wg sync.WaitGroup
+	var redeemed atomic.Int32
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := f.Poll(ctx, deviceCode); err == nil {
+				redeemed.Add(1)
+			} else {
+				assert.ErrorIs(t, err, ErrNotFound)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), redeemed.Load())
+}
+
+func TestConcurrentApproveAndDeny(t *testing.T) {
+	ctx := context.Background()
+	f, _ := newTestFlow()
+
+	deviceCode, a, err := f.Start(ctx, "cli")
+	require.NoError(t, err)
+
+	// 同时提交的批准和拒绝只有一个成功，结果与成功的操作一致
+	var wg sync.WaitGroup
+	var approved, denied atomic.Int32
+	for i := range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if i%2 == 0 {
+				if f.Approve(ctx, a.UserCode, Approver{Username: "alice"}) == nil {
+					approved.Add(1)
+				}
+			} else if f.Deny(ctx, a.UserCode) == nil {
+				denied.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, int32(1), approved.Load()+denied.Load())
+
+	got, err := f.Poll(ctx, deviceCode)
+	if approved.Load() == 1 {
+		require.NoError(t, err)
+		assert.Equal(t, "alice", got.Approver.Username)
+	} else {
+		assert.ErrorIs(t, err, ErrDenied)
+	}
+}
+
+func TestNewUserCode(t *testing.T) {
+	for range 100 {
+		code, err := newUserCode()
+		require.NoError(t, err)
+		require.Len(t, code, userCodeLength)
+		assert.Equal(t, code, NormalizeUserCode(code))
+	}
+}
+
+// collidingStore 在前几次创建时模拟 user_code 冲突
+type collidingStore struct {
+	Store
+	collisions int
+}
+
+func (s *collidingStore) Create(ctx context.Context, a *Authorization) error {
+	if s.collisions > 0 {
+		s.collisions--
+		return ErrExists
+	}
+	return s.Store.Create(ctx, a)
+}
+
+func TestStartCollision(t *testing.T) {
+	ctx := context.Background()
+	s := &collidingStore{Store: NewMemory(), collisions: 2}
+	f := New(Config{}, s)
+	deviceCode, a, err := f.Start(ctx, "cli")
+	require.NoError(t, err)
+	assert.Zero(t, s.collisions)
+	_, err = f.Lookup(ctx, a.UserCode)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, deviceCode)
+
+	s.collisions = startAttempts
+	_, _, err = f.Start(ctx, "cli")
+	assert.ErrorIs(t, err, ErrExists)
+}
+
+func TestMemoryCreate(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	a := &Authorization{DeviceCodeHash: "d1", UserCode: "BCDFGHJK", ExpiresAt: time.Now().Add(time.Minute)}
+	require.NoError(t, m.Create(ctx, a))
+	assert.ErrorIs(t, m.Create(ctx, &Authorization{DeviceCodeHash: "d2", UserCode: "BCDFGHJK", ExpiresAt: a.ExpiresAt}), ErrExists)
+	assert.ErrorIs(t, m.Create(ctx, &Authorization{DeviceCodeHash: "d1", UserCode: "LMNPQRST", ExpiresAt: a.ExpiresAt}), ErrExists)
+
+	// 过期请求的 user_code 可以重新分配，删除旧请求不影响新请求
+	expired := &Authorization{DeviceCodeHash: "d3", UserCode: "VWXZBCDF", ExpiresAt: time.Now().Add(-time.Second)}
+	require.NoError(t, m.Create(ctx, expired))
+	require.NoError(t, m.Create(ctx, &Authorization{DeviceCodeHash: "d4", UserCode: "VWXZBCDF", ExpiresAt: a.ExpiresAt}))
+	require.NoError(t, m.Delete(ctx, expired))
+	got, err := m.GetByUserCode(ctx, "VWXZBCDF")
+	require.NoError(t, err)
+	assert.Equal(t, "d4", got.DeviceCodeHash)
+}
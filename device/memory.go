@@ -0,0 +1,140 @@
+package device
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+var _ Store = &Memory{}
+
+// Memory 是单实例使用的内存存储
+type Memory struct {
+	mu        sync.Mutex
+	byDevice  map[string]*Authorization
+	byUser    map[string]string // user_code -> device_code 哈希
+	lastSweep time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		byDevice: make(map[string]*Authorization),
+		byUser:   make(map[string]string),
+	}
+}
+
+// sweep 清理过期的授权请求
+func (m *Memory) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < time.Minute {
+		return
+	}
+	for hash, a := range m.byDevice {
+		if !now.Before(a.ExpiresAt) {
+			m.remove(hash, a)
+		}
+	}
+	m.lastSweep = now
+}
+
+func (m *Memory) Create(ctx context.Context, a *Authorization) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	m.sweep(now)
+	if live(m.byDevice[a.DeviceCodeHash], now) || live(m.byDevice[m.byUser[a.UserCode]], now) {
+		return ErrExists
+	}
+	m.put(a)
+	return nil
+}
+
+func (m *Memory) Resolve(ctx context.Context, hash string, approver *Approver, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a, ok := m.byDevice[hash]
+	if !ok || !now.Before(a.ExpiresAt) || a.Approved() || a.Denied {
+		return ErrNotFound
+	}
+	if approver == nil {
+		a.Denied = true
+		return nil
+	}
+	stored := *approver
+	a.Approver = &stored
+	return nil
+}
+
+// put 保存授权请求的副本，调用方需持有锁
+func (m *Memory) put(a *Authorization) {
+	stored := *a
+	m.byDevice[a.DeviceCodeHash] = &stored
+	m.byUser[a.UserCode] = a.DeviceCodeHash
+}
+
+// remove 删除授权请求，user_code 已分配给新的请求时保留新请求的索引，调用方需持有锁
+func (m *Memory) remove(hash string, a *Authorization) {
+	delete(m.byDevice, hash)
+	if m.byUser[a.UserCode] == hash {
+		delete(m.byUser, a.UserCode)
+	}
+}
+
+// live 判断授权请求存在且未过期
+func live(a *Authorization, now time.Time) bool {
+	return a != nil && now.Before(a.ExpiresAt)
+}
+
+func (m *Memory) SetPoll(ctx context.Context, hash string, lastPoll time.Time, interval time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a, ok := m.byDevice[hash]
+	if !ok {
+		return ErrNotFound
+	}
+	a.LastPoll = lastPoll
+	a.Interval = interval
+	return nil
+}
+
+func (m *Memory) Redeem(ctx context.Context, hash string) (*Authorization, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a, ok := m.byDevice[hash]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if !a.Approved() && !a.Denied {
+		return nil, ErrPending
+	}
+	m.remove(hash, a)
+	return a, nil
+}
+
+func (m *Memory) GetByDeviceCode(ctx context.Context, hash string) (*Authorization, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a, ok := m.byDevice[hash]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	stored := *a
+	return &stored, nil
+}
+
+func (m *Memory) GetByUserCode(ctx context.Context, userCode string) (*Authorization, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a, ok := m.byDevice[m.byUser[userCode]]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	stored := *a
+	return &stored, nil
+}
+
+func (m *Memory) Delete(ctx context.Context, a *Authorization) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.remove(a.DeviceCodeHash, a)
+	return nil
+}
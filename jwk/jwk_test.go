@@ -0,0 +1,56 @@
+package jwk
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoundTrip(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	edPub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		alg  string
+		pub  crypto.PublicKey
+		kty  string
+	}{
+		{"rsa", "RS256", &rsaKey.PublicKey, "RSA"},
+		{"ecdsa", "ES384", &ecKey.PublicKey, "EC"},
+		{"ed25519", "EdDSA", edPub, "OKP"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, err := New("kid-1", tt.alg, tt.pub)
+			require.NoError(t, err)
+			assert.Equal(t, tt.kty, k.Kty)
+			assert.Equal(t, "sig", k.Use)
+			assert.Equal(t, "kid-1", k.Kid)
+
+			pub, err := k.PublicKey()
+			require.NoError(t, err)
+			assert.True(t, pub.(interface{ Equal(crypto.PublicKey) bool }).Equal(tt.pub))
+		})
+	}
+}
+
+func TestUnsupported(t *testing.T) {
+	_, err := New("kid", "HS256", []byte("secret"))
+	assert.Error(t, err)
+	_, err = Key{Kty: "oct"}.PublicKey()
+	assert.Error(t, err)
+	_, err = Key{Kty: "EC", Crv: "secp256k1"}.PublicKey()
+	assert.Error(t, err)
+}
@@ -0,0 +1,225 @@
+// Package keyring 管理 AuthGate 签发和校验 JWT 使用的密钥
+package keyring
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ipfans/authgate/jwk"
+)
+
+type KeyConfig struct {
+	ID         string `koanf:"id"`          // 密钥 ID，写入 JWT 头部的 kid
+	Algorithm  string `koanf:"algorithm"`   // RS256, RS384, RS512, PS256, ES256, ES384, ES512 或 EdDSA
+	PrivateKey string `koanf:"private_key"` // PEM 格式私钥文件路径，签名密钥必须配置
+	PublicKey  string `koanf:"public_key"`  // PEM 格式公钥文件路径，配置私钥时可以省略
+}
+
+type Config struct {
+	SigningKey string      `koanf:"signing_key"` // 用于签名的密钥 ID，默认为第一个配置了私钥的密钥
+	Keys       []KeyConfig `koanf:"keys"`        // 全部有效密钥，轮换时保留旧密钥用于校验
+}
+
+type key struct {
+	id      string
+	method  jwt.SigningMethod
+	private crypto.PrivateKey
+	public  crypto.PublicKey
+}
+
+// Keyring 保存一个签名密钥和多个校验密钥，
+// 未配置非对称密钥时使用 HS256 和共享密钥
+type Keyring struct {
+	signer  *key
+	keys    map[string]*key
+	secret  []byte
+	methods []string
+}
+
+// New 加载密钥，secret 为 HS256 共享密钥，
+// 同时配置两者时只用非对称密钥签名，但仍接受 HS256 签名的旧令牌以便平滑迁移
+func New(cfg Config, secret string) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string]*key)}
+	if secret != "" {
+		k.secret = []byte(secret)
+		k.methods = append(k.methods, jwt.SigningMethodHS256.Alg())
+	}
+
+	for _, kc := range cfg.Keys {
+		if kc.ID == "" {
+			return nil, errors.New("keyring: key id is required")
+		}
+		if _, ok := k.keys[kc.ID]; ok {
+			return nil, fmt.Errorf("keyring: duplicate key id %q", kc.ID)
+		}
+		loaded, err := loadKey(kc)
+		if err != nil {
+			return nil, fmt.Errorf("keyring: key %q: %w", kc.ID, err)
+		}
+		k.keys[kc.ID] = loaded
+		if !contains(k.methods, loaded.method.Alg()) {
+			k.methods = append(k.methods, loaded.method.Alg())
+		}
+		if k.signer == nil && cfg.SigningKey == "" && loaded.private != nil {
+			k.signer = loaded
+		}
+	}
+
+	if cfg.SigningKey != "" {
+		signer, ok := k.keys[cfg.SigningKey]
+		if !ok {
+			return nil, fmt.Errorf("keyring: signing key %q not found", cfg.SigningKey)
+		}
+		if signer.private == nil {
+			return nil, fmt.Errorf("keyring: signing key %q has no private key", cfg.SigningKey)
+		}
+		k.signer = signer
+	}
+	if k.signer == nil && k.secret == nil {
+		return nil, errors.New("keyring: no signing key or jwt secret configured")
+	}
+	return k, nil
+}
+
+// Sign 签发 JWT
+func (k *Keyring) Sign(claims jwt.Claims) (string, error) {
+	if k.signer == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(k.secret)
+	}
+	token := jwt.NewWithClaims(k.signer.method, claims)
+	token.Header["kid"] = k.signer.id
+	return token.SignedString(k.signer.private)
+}
+
+// Parse 校验 JWT 并把内容解析到 claims，算法必须与 kid 对应的密钥一致
+func (k *Keyring) Parse(token string, claims jwt.Claims, opts ...jwt.ParserOption) error {
+	opts = append(opts, jwt.WithValidMethods(k.methods))
+	_, err := jwt.ParseWithClaims(token, claims, k.keyFunc, opts...)
+	return err
+}
+
+func (k *Keyring) keyFunc(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		if k.secret == nil {
+			return nil, errors.New("hmac tokens are not accepted")
+		}
+		return k.secret, nil
+	}
+	kid, _ := token.Header["kid"].(string)
+	verifier, ok := k.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id: %q", kid)
+	}
+	if verifier.method.Alg() != token.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s for key %q", token.Method.Alg(), kid)
+	}
+	return verifier.public, nil
+}
+
+// JWKS 返回全部非对称密钥的公钥
+func (k *Keyring) JWKS() jwk.Set {
+	set := jwk.Set{Keys: []jwk.Key{}}
+	for _, v := range k.keys {
+		key, err := jwk.New(v.id, v.method.Alg(), v.public)
+		if err != nil {
+			continue
+		}
+		set.Keys = append(set.Keys, key)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool {
+		return set.Keys[i].Kid < set.Keys[j].Kid
+	})
+	return set
+}
+
+func loadKey(kc KeyConfig) (*key, error) {
+	method := jwt.GetSigningMethod(kc.Algorithm)
+	if method == nil {
+		return nil, fmt.Errorf("unsupported algorithm %q", kc.Algorithm)
+	}
+	if _, ok := method.(*jwt.SigningMethodHMAC); ok {
+		return nil, fmt.Errorf("unsupported algorithm %q, use jwt_secret for HMAC", kc.Algorithm)
+	}
+
+	k := &key{id: kc.ID, method: method}
+	if kc.PrivateKey != "" {
+		data, err := os.ReadFile(kc.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		if k.private, k.public, err = parsePrivateKey(method, data); err != nil {
+			return nil, err
+		}
+	} else if kc.PublicKey != "" {
+		data, err := os.ReadFile(kc.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		if k.public, err = parsePublicKey(method, data); err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, errors.New("private_key or public_key is required")
+	}
+
+	if ec, ok := k.public.(*ecdsa.PublicKey); ok {
+		if ec.Curve.Params().BitSize != method.(*jwt.SigningMethodECDSA).CurveBits {
+			return nil, fmt.Errorf("curve %s does not match algorithm %s", ec.Curve.Params().Name, kc.Algorithm)
+		}
+	}
+	return k, nil
+}
+
+func parsePrivateKey(method jwt.SigningMethod, data []byte) (crypto.PrivateKey, crypto.PublicKey, error) {
+	switch method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		priv, err := jwt.ParseRSAPrivateKeyFromPEM(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		return priv, &priv.PublicKey, nil
+	case *jwt.SigningMethodECDSA:
+		priv, err := jwt.ParseECPrivateKeyFromPEM(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		return priv, &priv.PublicKey, nil
+	case *jwt.SigningMethodEd25519:
+		priv, err := jwt.ParseEdPrivateKeyFromPEM(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		signer, ok := priv.(crypto.Signer)
+		if !ok {
+			return nil, nil, errors.New("invalid ed25519 private key")
+		}
+		return priv, signer.Public(), nil
+	}
+	return nil, nil, fmt.Errorf("unsupported algorithm %q", method.Alg())
+}
+
+func parsePublicKey(method jwt.SigningMethod, data []byte) (crypto.PublicKey, error) {
+	switch method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		return jwt.ParseRSAPublicKeyFromPEM(data)
+	case *jwt.SigningMethodECDSA:
+		return jwt.ParseECPublicKeyFromPEM(data)
+	case *jwt.SigningMethodEd25519:
+		return jwt.ParseEdPublicKeyFromPEM(data)
+	}
+	return nil, fmt.Errorf("unsupported algorithm %q", method.Alg())
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
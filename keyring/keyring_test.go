@@ -0,0 +1,187 @@
+package keyring
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeKey 生成 PEM 文件，返回私钥和公钥文件路径
+func writeKey(t *testing.T, priv crypto.Signer) (string, string) {
+	dir := t.TempDir()
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	require.NoError(t, err)
+	pubDER, err := x509.MarshalPKIXPublicKey(priv.Public())
+	require.NoError(t, err)
+	privPath := filepath.Join(dir, "key.pem")
+	pubPath := filepath.Join(dir, "key.pub")
+	require.NoError(t, os.WriteFile(privPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}), 0o600))
+	require.NoError(t, os.WriteFile(pubPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}), 0o600))
+	return privPath, pubPath
+}
+
+func claims() jwt.MapClaims {
+	return jwt.MapClaims{"username": "alice", "exp": time.Now().Add(time.Minute).Unix()}
+}
+
+func TestAlgorithms(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	tests := []struct {
+		alg string
+		key crypto.Signer
+	}{
+		{"RS256", rsaKey},
+		{"PS256", rsaKey},
+		{"ES256", ecKey},
+		{"EdDSA", edKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.alg, func(t *testing.T) {
+			privPath, _ := writeKey(t, tt.key)
+			k, err := New(Config{Keys: []KeyConfig{{ID: "k1", Algorithm: tt.alg, PrivateKey: privPath}}}, "")
+			require.NoError(t, err)
+
+			token, err := k.Sign(claims())
+			require.NoError(t, err)
+			parsed := jwt.MapClaims{}
+			require.NoError(t, k.Parse(token, parsed))
+			assert.Equal(t, "alice", parsed["username"])
+
+			header, _, err := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
+			require.NoError(t, err)
+			assert.Equal(t, "k1", header.Header["kid"])
+			assert.Equal(t, tt.alg, header.Header["alg"])
+
+			jwks := k.JWKS()
+			require.Len(t, jwks.Keys, 1)
+			assert.Equal(t, "k1", jwks.Keys[0].Kid)
+			assert.Equal(t, tt.alg, jwks.Keys[0].Alg)
+		})
+	}
+}
+
+func TestRotation(t *testing.T) {
+	oldKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	newKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	oldPriv, oldPub := writeKey(t, oldKey)
+	newPriv, _ := writeKey(t, newKey)
+
+	before, err := New(Config{Keys: []KeyConfig{{ID: "old", Algorithm: "ES256", PrivateKey: oldPriv}}}, "")
+	require.NoError(t, err)
+	oldToken, err := before.Sign(claims())
+	require.NoError(t, err)
+
+	// 轮换后使用新密钥签名，旧密钥只用于校验
+	after, err := New(Config{
+		SigningKey: "new",
+		Keys: []KeyConfig{
+			{ID: "old", Algorithm: "ES256", PublicKey: oldPub},
+			{ID: "new", Algorithm: "ES256", PrivateKey: newPriv},
+		},
+	}, "")
+	require.NoError(t, err)
+	assert.NoError(t, after.Parse(oldToken, jwt.MapClaims{}))
+	newToken, err := after.Sign(claims())
+	require.NoError(t, err)
+	assert.NoError(t, after.Parse(newToken, jwt.MapClaims{}))
+	assert.Error(t, before.Parse(newToken, jwt.MapClaims{}))
+	assert.Len(t, after.JWKS().Keys, 2)
+}
+
+func TestHMAC(t *testing.T) {
+	k, err := New(Config{}, "secret")
+	require.NoError(t, err)
+	token, err := k.Sign(claims())
+	require.NoError(t, err)
+	assert.NoError(t, k.Parse(token, jwt.MapClaims{}))
+	assert.Empty(t, k.JWKS().Keys)
+
+	other, err := New(Config{}, "other")
+	require.NoError(t, err)
+	assert.Error(t, other.Parse(token, jwt.MapClaims{}))
+}
+
+func TestRejectsConfusion(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	privPath, pubPath := writeKey(t, rsaKey)
+	pubPEM, err := os.ReadFile(pubPath)
+	require.NoError(t, err)
+
+	k, err := New(Config{Keys: []KeyConfig{{ID: "k1", Algorithm: "RS256", PrivateKey: privPath}}}, "")
+	require.NoError(t, err)
+
+	// 使用公钥作为 HMAC 密钥伪造的令牌
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, claims())
+	forged.Header["kid"] = "k1"
+	token, err := forged.SignedString(pubPEM)
+	require.NoError(t, err)
+	assert.Error(t, k.Parse(token, jwt.MapClaims{}))
+
+	// alg 与 kid 对应的密钥不一致
+	ps := jwt.NewWithClaims(jwt.SigningMethodPS256, claims())
+	ps.Header["kid"] = "k1"
+	token, err = ps.SignedString(rsaKey)
+	require.NoError(t, err)
+	assert.Error(t, k.Parse(token, jwt.MapClaims{}))
+
+	// 未知 kid
+	unknown := jwt.NewWithClaims(jwt.SigningMethodRS256, claims())
+	unknown.Header["kid"] = "k2"
+	token, err = unknown.SignedString(rsaKey)
+	require.NoError(t, err)
+	assert.Error(t, k.Parse(token, jwt.MapClaims{}))
+}
+
+func TestNewErrors(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	privPath, pubPath := writeKey(t, ecKey)
+
+	tests := []struct {
+		name string
+		cfg  Config
+	}{
+		{"nothing configured", Config{}},
+		{"missing id", Config{Keys: []KeyConfig{{Algorithm: "ES384", PrivateKey: privPath}}}},
+		{"hmac algorithm", Config{Keys: []KeyConfig{{ID: "k", Algorithm: "HS256", PrivateKey: privPath}}}},
+		{"unknown algorithm", Config{Keys: []KeyConfig{{ID: "k", Algorithm: "XX1", PrivateKey: privPath}}}},
+		{"curve mismatch", Config{Keys: []KeyConfig{{ID: "k", Algorithm: "ES256", PrivateKey: privPath}}}},
+		{"wrong key type", Config{Keys: []KeyConfig{{ID: "k", Algorithm: "RS256", PrivateKey: privPath}}}},
+		{"no key file", Config{Keys: []KeyConfig{{ID: "k", Algorithm: "ES384"}}}},
+		{"verify only", Config{Keys: []KeyConfig{{ID: "k", Algorithm: "ES384", PublicKey: pubPath}}}},
+		{"signing key without private key", Config{SigningKey: "k", Keys: []KeyConfig{{ID: "k", Algorithm: "ES384", PublicKey: pubPath}}}},
+		{"unknown signing key", Config{SigningKey: "x", Keys: []KeyConfig{{ID: "k", Algorithm: "ES384", PrivateKey: privPath}}}},
+		{"duplicate id", Config{Keys: []KeyConfig{
+			{ID: "k", Algorithm: "ES384", PrivateKey: privPath},
+			{ID: "k", Algorithm: "ES384", PrivateKey: privPath},
+		}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.cfg, "")
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,181 @@
+// Package lockout 记录登录失败次数，对连续失败的用户名和 IP 按指数退避临时锁定
+package lockout
+
+import (
+	"context"
+	"time"
+)
+
+// Record 是一个键的连续失败记录
+type Record struct {
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"last_failure"`
+}
+
+// Store 保存失败记录，多实例部署时可以使用共享存储（如 Redis）实现
+type Store interface {
+	// Get 返回键的失败记录，记录不存在或已过期时返回零值
+	Get(ctx context.Context, key string) (Record, error)
+	// Reserve 在 allow 对当前记录返回 true 时记录一次失败，返回记录之前的记录和是否已记录，
+	// 检查和记录必须是原子的。记录在最后一次失败 ttl 后过期
+	Reserve(ctx context.Context, key string, now time.Time, ttl time.Duration, allow func(Record) bool) (Record, bool, error)
+	// Release 撤销一次 Reserve，失败次数减一，最后失败时间恢复为 prev 中的时间
+	Release(ctx context.Context, key string, prev Record) error
+	// Reset 删除键的失败记录
+	Reset(ctx context.Context, key string) error
+}
+
+type Policy struct {
+	MaxAttempts int           `koanf:"max_attempts"` // 允许的连续失败次数，超过后开始锁定，小于 0 时不限制
+	BaseDelay   time.Duration `koanf:"base_delay"`   // 首次锁定时间，之后每次失败翻倍
+	MaxDelay    time.Duration `koanf:"max_delay"`    // 最长锁定时间
+	Window      time.Duration `koanf:"window"`       // 无失败超过该时间后重新计数，不小于 max_delay
+}
+
+type Config struct {
+	User Policy `koanf:"user"` // 按用户名限制，默认 5 次后锁定 30 秒起，最长 15 分钟
+	IP   Policy `koanf:"ip"`   // 按客户端 IP 限制，默认 20 次后锁定 30 秒起，最长 15 分钟
+}
+
+// withDefaults 补全策略的默认值
+func (p Policy) withDefaults(maxAttempts int) Policy {
+	if p.MaxAttempts == 0 {
+		p.MaxAttempts = maxAttempts
+	}
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = 30 * time.Second
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = 15 * time.Minute
+	}
+	if p.MaxDelay < p.BaseDelay {
+		p.MaxDelay = p.BaseDelay
+	}
+	if p.Window < p.MaxDelay {
+		p.Window = max(p.MaxDelay, 15*time.Minute)
+	}
+	return p
+}
+
+// lockedFor 返回记录在 now 时剩余的锁定时间
+func (p Policy) lockedFor(r Record, now time.Time) time.Duration {
+	if p.MaxAttempts < 0 || r.Failures < p.MaxAttempts {
+		return 0
+	}
+	delay := p.BaseDelay
+	for i := p.MaxAttempts; i < r.Failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, p.MaxDelay)
+	return max(r.LastFailure.Add(delay).Sub(now), 0)
+}
+
+// Tracker 按用户名和 IP 跟踪登录失败
+type Tracker struct {
+	user  Policy
+	ip    Policy
+	store Store
+	now   func() time.Time
+}
+
+func New(cfg Config, store Store) *Tracker {
+	return &Tracker{
+		user:  cfg.User.withDefaults(5),
+		ip:    cfg.IP.withDefaults(20),
+		store: store,
+		now:   time.Now,
+	}
+}
+
+func userKey(username string) string { return "user:" + username }
+
+func ipKey(ip string) string { return "ip:" + ip }
+
+type trackedKey struct {
+	policy Policy
+	key    string
+}
+
+// keys 返回需要跟踪的键，不限制次数的策略不跟踪
+func (t *Tracker) keys(ip, username string) []trackedKey {
+	var keys []trackedKey
+	for _, k := range []trackedKey{{t.user, userKey(username)}, {t.ip, ipKey(ip)}} {
+		if k.policy.MaxAttempts >= 0 {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// Check 返回用户名或 IP 剩余的锁定时间，为 0 时允许尝试登录
+func (t *Tracker) Check(ctx context.Context, ip, username string) (time.Duration, error) {
+	now := t.now()
+	var locked time.Duration
+	for _, k := range t.keys(ip, username) {
+		r, err := t.store.Get(ctx, k.key)
+		if err != nil {
+			return 0, err
+		}
+		locked = max(locked, k.policy.lockedFor(r, now))
+	}
+	return locked, nil
+}
+
+// Attempt 在校验凭据前预先把本次尝试记为一次失败，检查和记录是原子的，并发的尝试不会超过允许的次数。
+// 用户名或 IP 被锁定时不记录，返回剩余的锁定时间
+func (t *Tracker) Attempt(ctx context.Context, ip, username string) (*Reservation, time.Duration, error) {
+	now := t.now()
+	res := &Reservation{tracker: t, ip: ip, username: username, prev: make(map[string]Record)}
+	for _, k := range t.keys(ip, username) {
+		var locked time.Duration
+		prev, ok, err := t.store.Reserve(ctx, k.key, now, k.policy.Window, func(r Record) bool {
+			locked = k.policy.lockedFor(r, now)
+			return locked == 0
+		})
+		if err != nil || !ok {
+			// 撤销已经记录的键
+			if releaseErr := res.Release(ctx); err == nil {
+				err = releaseErr
+			}
+			return nil, locked, err
+		}
+		res.prev[k.key] = prev
+	}
+	return res, 0, nil
+}
+
+// Reservation 是 Attempt 预先记为失败的一次尝试
+type Reservation struct {
+	tracker      *Tracker
+	ip, username string
+	prev         map[string]Record
+}
+
+// Fail 确认本次尝试失败，返回之后需要等待的时间
+func (r *Reservation) Fail(ctx context.Context) (time.Duration, error) {
+	return r.tracker.Check(ctx, r.ip, r.username)
+}
+
+// Succeed 在登录成功后清除用户名的失败记录。IP 只撤销本次尝试，
+// 避免攻击者用自己的账户登录来重置计数
+func (r *Reservation) Succeed(ctx context.Context) error {
+	key := userKey(r.username)
+	if _, ok := r.prev[key]; ok {
+		delete(r.prev, key)
+		if err := r.tracker.store.Reset(ctx, key); err != nil {
+			return err
+		}
+	}
+	return r.Release(ctx)
+}
+
+// Release 撤销本次尝试，用于凭据正确但还需要其他步骤的情况，如密码正确后等待第二步验证
+func (r *Reservation) Release(ctx context.Context) error {
+	for key, prev := range r.prev {
+		if err := r.tracker.store.Release(ctx, key, prev); err != nil {
+			return err
+		}
+		delete(r.prev, key)
+	}
+	return nil
+}
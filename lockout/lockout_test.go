@@ -0,0 +1,167 @@
+package lockout
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicyLockedFor(t *testing.T) {
+	p := Policy{MaxAttempts: 3, BaseDelay: time.Second, MaxDelay: 10 * time.Second}.withDefaults(5)
+	now := time.Now()
+	tests := []struct {
+		failures int
+		elapsed  time.Duration
+		locked   time.Duration
+	}{
+		{failures: 2, locked: 0},
+		{failures: 3, locked: time.Second},
+		{failures: 4, locked: 2 * time.Second},
+		{failures: 5, locked: 4 * time.Second},
+		{failures: 5, elapsed: time.Second, locked: 3 * time.Second},
+		{failures: 5, elapsed: 5 * time.Second, locked: 0},
+		{failures: 20, locked: 10 * time.Second},
+	}
+	for _, tt := range tests {
+		r := Record{Failures: tt.failures, LastFailure: now.Add(-tt.elapsed)}
+		assert.Equal(t, tt.locked, p.lockedFor(r, now), "failures=%d elapsed=%s", tt.failures, tt.elapsed)
+	}
+
+	disabled := Policy{MaxAttempts: -1}.withDefaults(5)
+	assert.Zero(t, disabled.lockedFor(Record{Failures: 100, LastFailure: now}, now))
+}
+
+// fail 尝试一次并确认失败，返回之后需要等待的时间
+func fail(t *testing.T, tracker *Tracker, ip, username string) time.Duration {
+	ctx := context.Background()
+	res, locked, err := tracker.Attempt(ctx, ip, username)
+	require.NoError(t, err)
+	require.Zero(t, locked)
+	locked, err = res.Fail(ctx)
+	require.NoError(t, err)
+	return locked
+}
+
+func TestTracker(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	tracker := New(Config{
+		User: Policy{MaxAttempts: 2, BaseDelay: time.Minute},
+		IP:   Policy{MaxAttempts: 3, BaseDelay: time.Minute},
+	}, NewMemory())
+	tracker.now = func() time.Time { return now }
+
+	assert.Zero(t, fail(t, tracker, "10.0.0.1", "alice"))
+	assert.Equal(t, time.Minute, fail(t, tracker, "10.0.0.1", "alice"))
+
+	// 用户名被锁定时，其他 IP 也无法登录该用户，被拒绝的尝试不计数
+	_, locked, err := tracker.Attempt(ctx, "10.0.0.2", "alice")
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, locked)
+	locked, err = tracker.Check(ctx, "10.0.0.2", "bob")
+	require.NoError(t, err)
+	assert.Zero(t, locked)
+
+	// 同一 IP 尝试不同用户名时按 IP 锁定
+	assert.Equal(t, time.Minute, fail(t, tracker, "10.0.0.1", "bob"))
+	_, locked, err = tracker.Attempt(ctx, "10.0.0.1", "carol")
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, locked)
+	// 被 IP 拒绝时撤销已经记录的用户名
+	locked, err = tracker.Check(ctx, "10.0.0.2", "carol")
+	require.NoError(t, err)
+	assert.Zero(t, locked)
+	r, err := tracker.store.Get(ctx, userKey("carol"))
+	require.NoError(t, err)
+	assert.Zero(t, r.Failures)
+
+	// 锁定结束后再次失败，锁定时间翻倍
+	now = now.Add(time.Minute)
+	assert.Equal(t, 2*time.Minute, fail(t, tracker, "10.0.0.2", "alice"))
+
+	// 登录成功清除用户名的记录，IP 只撤销本次尝试
+	now = now.Add(2 * time.Minute)
+	res, locked, err := tracker.Attempt(ctx, "10.0.0.1", "alice")
+	require.NoError(t, err)
+	require.Zero(t, locked)
+	require.NoError(t, res.Succeed(ctx))
+	r, err = tracker.store.Get(ctx, userKey("alice"))
+	require.NoError(t, err)
+	assert.Zero(t, r.Failures)
+	r, err = tracker.store.Get(ctx, ipKey("10.0.0.1"))
+	require.NoError(t, err)
+	assert.Equal(t, 3, r.Failures)
+	// 撤销后不会因为最后失败时间变化而重新锁定
+	locked, err = tracker.Check(ctx, "10.0.0.1", "dave")
+	require.NoError(t, err)
+	assert.Zero(t, locked)
+	// IP 的失败次数没有被清除，再次失败时继续翻倍
+	assert.Equal(t, 2*time.Minute, fail(t, tracker, "10.0.0.1", "alice"))
+}
+
+func TestTrackerConcurrentAttempts(t *testing.T) {
+	ctx := context.Background()
+	tracker := New(Config{
+		User: Policy{MaxAttempts: 3, BaseDelay: time.Minute},
+		IP:   Policy{MaxAttempts: -1},
+	}, NewMemory())
+
+	// 同时发起的尝试在校验凭据前就已计数，只有 MaxAttempts 个可以继续
+	var allowed atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, locked, err := tracker.Attempt(ctx, "10.0.0.1", "alice")
+			if err == nil && locked == 0 {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(3), allowed.Load())
+}
+
+func TestMemoryExpiry(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	allow := func(Record) bool { return true }
+	past := time.Now().Add(-time.Hour)
+	_, _, err := m.Reserve(ctx, "k", past, time.Minute, allow)
+	require.NoError(t, err)
+	r, err := m.Get(ctx, "k")
+	require.NoError(t, err)
+	assert.Zero(t, r.Failures)
+
+	// 过期后重新计数
+	now := time.Now()
+	prev, ok, err := m.Reserve(ctx, "k", now, time.Minute, allow)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Zero(t, prev.Failures)
+	prev, _, err = m.Reserve(ctx, "k", now.Add(time.Second), time.Minute, allow)
+	require.NoError(t, err)
+	assert.Equal(t, 1, prev.Failures)
+
+	// allow 拒绝时不修改记录
+	prev, ok, err = m.Reserve(ctx, "k", now, time.Minute, func(Record) bool { return false })
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, 2, prev.Failures)
+
+	require.NoError(t, m.Release(ctx, "k", Record{Failures: 1, LastFailure: now}))
+	r, err = m.Get(ctx, "k")
+	require.NoError(t, err)
+	assert.Equal(t, Record{Failures: 1, LastFailure: now}, r)
+
+	require.NoError(t, m.Reset(ctx, "k"))
+	r, err = m.Get(ctx, "k")
+	require.NoError(t, err)
+	assert.Zero(t, r.Failures)
+}
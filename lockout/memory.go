@@ -0,0 +1,88 @@
+package lockout
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+var _ Store = &Memory{}
+
+// sweepInterval 是清理过期记录的最小间隔
+const sweepInterval = time.Minute
+
+type memoryEntry struct {
+	record  Record
+	expires time.Time
+}
+
+// Memory 是单实例使用的内存存储
+type Memory struct {
+	mu        sync.Mutex
+	entries   map[string]*memoryEntry
+	lastSweep time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{entries: make(map[string]*memoryEntry)}
+}
+
+func (m *Memory) Get(ctx context.Context, key string) (Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[key]
+	if !ok || !time.Now().Before(e.expires) {
+		return Record{}, nil
+	}
+	return e.record, nil
+}
+
+func (m *Memory) Reserve(ctx context.Context, key string, now time.Time, ttl time.Duration, allow func(Record) bool) (Record, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if now.Sub(m.lastSweep) > sweepInterval {
+		for k, e := range m.entries {
+			if !now.Before(e.expires) {
+				delete(m.entries, k)
+			}
+		}
+		m.lastSweep = now
+	}
+
+	e, ok := m.entries[key]
+	if !ok || !now.Before(e.expires) {
+		e = &memoryEntry{}
+	}
+	prev := e.record
+	if !allow(prev) {
+		return prev, false, nil
+	}
+	e.record.Failures++
+	e.record.LastFailure = now
+	e.expires = now.Add(ttl)
+	m.entries[key] = e
+	return prev, true, nil
+}
+
+func (m *Memory) Release(ctx context.Context, key string, prev Record) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[key]
+	if !ok {
+		return nil
+	}
+	if e.record.Failures <= 1 {
+		delete(m.entries, key)
+		return nil
+	}
+	e.record.Failures--
+	e.record.LastFailure = prev.LastFailure
+	return nil
+}
+
+func (m *Memory) Reset(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, key)
+	return nil
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"github.com/cloudwego/hertz/pkg/app/server"
+	hertzconfig "github.com/cloudwego/hertz/pkg/common/config"
 	"github.com/ipfans/authgate/config"
 	"github.com/ipfans/authgate/routers"
 )
@@ -12,7 +13,17 @@ func main() {
 		panic(err)
 	}
 
-	h := server.Default()
-	routers.RegisterRoutes(h, cfg.Routes)
+	var opts []hertzconfig.Option
+	tlsConfig, err := routers.ServerTLS(cfg.Routes)
+	if err != nil {
+		panic(err)
+	}
+	if tlsConfig != nil {
+		opts = append(opts, server.WithTLS(tlsConfig))
+	}
+	h := server.Default(opts...)
+	if err = routers.RegisterRoutes(h, cfg.Routes); err != nil {
+		panic(err)
+	}
 	h.Spin()
 }
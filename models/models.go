@@ -1,7 +1,10 @@
 package models
 
 import (
+	"time"
+
 	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/ipfans/authgate/passwd"
 	"github.com/sqids/sqids-go"
 )
 
@@ -10,10 +13,31 @@ var _ webauthn.User = &User{}
 var idGenerator *sqids.Sqids
 
 type User struct {
-	ID          uint64 `json:"id"`
-	Username    string `json:"username"`
-	DisplayName string `json:"display_name"`
-	Email       string `json:"email"`
+	ID           uint64   `json:"id"`
+	Username     string   `json:"username"`
+	DisplayName  string   `json:"display_name"`
+	Email        string   `json:"email"`
+	Groups       []string `json:"groups,omitempty"`
+	PasswordHash string   `json:"password_hash,omitempty"`
+
+	TOTPSecret    string   `json:"totp_secret,omitempty"`    // base32 编码的 TOTP 密钥，为空时未启用两步验证
+	TOTPLastStep  int64    `json:"totp_last_step,omitempty"` // 最近一次使用的 TOTP 时间步，用于防止重放
+	RecoveryCodes []string `json:"recovery_codes,omitempty"` // 恢复码的哈希
+
+	Credentials []webauthn.Credential `json:"credentials,omitempty"`
+
+	AccessTokens []AccessToken `json:"access_tokens,omitempty"`
+}
+
+// AccessToken 是用户为脚本和 CI 创建的个人访问令牌，只保存令牌的哈希
+type AccessToken struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Hash      string    `json:"hash"`
+	Scopes    []string  `json:"scopes,omitempty"` // 允许访问的后端域名，为空时不限制
+	AMR       []string  `json:"amr,omitempty"`    // 创建令牌时登录使用的认证方式
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
 }
 
 func init() {
@@ -24,6 +48,26 @@ func init() {
 	}
 }
 
+// CheckPassword 以常量时间校验用户密码，支持 bcrypt 和 argon2id 哈希
+func (u *User) CheckPassword(password string) bool {
+	return passwd.Verify(u.PasswordHash, password)
+}
+
+// TOTPEnabled 判断用户是否启用了 TOTP 两步验证
+func (u *User) TOTPEnabled() bool {
+	return u.TOTPSecret != ""
+}
+
+// FindAccessToken 按 ID 查找用户的访问令牌，不存在时返回 nil
+func (u *User) FindAccessToken(id string) *AccessToken {
+	for i := range u.AccessTokens {
+		if u.AccessTokens[i].ID == id {
+			return &u.AccessTokens[i]
+		}
+	}
+	return nil
+}
+
 func (u *User) WebAuthnID() []byte {
 	id, err := idGenerator.Encode([]uint64{u.ID})
 	if err != nil {
@@ -33,7 +77,7 @@ func (u *User) WebAuthnID() []byte {
 }
 
 func (u *User) WebAuthnCredentials() []webauthn.Credential {
-	return []webauthn.Credential{}
+	return u.Credentials
 }
 
 func (u *User) WebAuthnDisplayName() string {
@@ -0,0 +1,105 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ipfans/authgate/jwk"
+)
+
+// jwksRefreshInterval 遇到未知 kid 时两次刷新之间的最短间隔
+const jwksRefreshInterval = time.Minute
+
+// keySet 缓存身份提供方的公钥，遇到未知 kid 时重新拉取
+type keySet struct {
+	url    string
+	client *http.Client
+	ttl    time.Duration
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+func newKeySet(url string, client *http.Client, ttl time.Duration) *keySet {
+	return &keySet{url: url, client: client, ttl: ttl}
+}
+
+// Get 返回 kid 对应的公钥
+func (s *keySet) Get(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expired := time.Since(s.fetched) > s.ttl
+	if key, ok := s.lookup(kid); ok && !expired {
+		return key, nil
+	}
+	// 未知 kid 可能是提供方轮换了密钥，但需要限制刷新频率
+	if expired || time.Since(s.fetched) > jwksRefreshInterval {
+		if err := s.refresh(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if key, ok := s.lookup(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key id: %q", kid)
+}
+
+func (s *keySet) lookup(kid string) (crypto.PublicKey, bool) {
+	// 没有 kid 时只接受唯一的公钥
+	if kid == "" && len(s.keys) == 1 {
+		for _, key := range s.keys {
+			return key, true
+		}
+	}
+	key, ok := s.keys[kid]
+	return key, ok
+}
+
+func (s *keySet) refresh(ctx context.Context) error {
+	var jwks jwk.Set
+	if err := getJSON(ctx, s.client, s.url, &jwks); err != nil {
+		return fmt.Errorf("fetch jwks: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(jwks.Keys))
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.PublicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return errors.New("jwks has no usable keys")
+	}
+	s.keys = keys
+	s.fetched = time.Now()
+	return nil
+}
+
+func getJSON(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
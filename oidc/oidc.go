@@ -0,0 +1,265 @@
+package oidc
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ipfans/authgate/utils/defaults"
+	"github.com/ipfans/authgate/utils/random"
+)
+
+var (
+	// ErrInvalidState 回调中的 state 与发起登录的浏览器保存的授权请求不一致
+	ErrInvalidState = errors.New("invalid or expired state")
+	// ErrInvalidNonce ID Token 中的 nonce 与登录请求不一致
+	ErrInvalidNonce = errors.New("nonce mismatch")
+)
+
+type Config struct {
+	Issuer         string        `koanf:"issuer"`          // 身份提供方地址，为空时不启用 OIDC 登录
+	ClientID       string        `koanf:"client_id"`       // 客户端 ID
+	ClientSecret   string        `koanf:"client_secret"`   // 客户端密钥，公共客户端可以为空
+	RedirectURL    string        `koanf:"redirect_url"`    // 回调地址，默认为认证域名下的 /authgate/oidc/callback
+	Scopes         []string      `koanf:"scopes"`          // 默认 openid profile email
+	UsernameClaim  string        `koanf:"username_claim"`  // 用户名声明，默认 sub，preferred_username 等声明不保证唯一
+	UsernamePrefix string        `koanf:"username_prefix"` // 用户名前缀，默认 oidc:，避免与本地账号同名
+	EmailClaim     string        `koanf:"email_claim"`     // 邮箱声明，默认 email
+	GroupsClaim    string        `koanf:"groups_claim"`    // 用户组声明，默认 groups
+	JWKSCacheTTL   time.Duration `koanf:"jwks_cache_ttl"`  // 公钥缓存时间，默认 1 小时
+}
+
+// Identity 是从 ID Token 映射出的用户信息
+type Identity struct {
+	Subject  string
+	Username string // 带有 username_prefix 前缀
+	Email    string
+	Groups   []string
+}
+
+// discovery 是 OpenID Provider 元数据中需要用到的部分
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Request 是一次尚未完成的授权请求。Provider 不保存授权请求，
+// 调用方需要把它保存在与发起登录的浏览器绑定、防篡改的位置（如签名的 Cookie），回调时交给 Finish
+type Request struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	ReturnTo string `json:"return_to,omitempty"`
+}
+
+// Provider 实现带 PKCE 的授权码流程
+type Provider struct {
+	cfg    Config
+	client *http.Client
+
+	mu        sync.Mutex
+	discovery *discovery
+	keys      *keySet
+}
+
+// New 创建 Provider，元数据在首次使用时获取
+func New(cfg Config) (*Provider, error) {
+	if cfg.Issuer == "" || cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return nil, errors.New("oidc: issuer, client_id and redirect_url are required")
+	}
+	cfg.Issuer = strings.TrimSuffix(cfg.Issuer, "/")
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "profile", "email"}
+	}
+	cfg.UsernameClaim = defaults.Get(cfg.UsernameClaim, "sub")
+	cfg.UsernamePrefix = defaults.Get(cfg.UsernamePrefix, "oidc:")
+	cfg.EmailClaim = defaults.Get(cfg.EmailClaim, "email")
+	cfg.GroupsClaim = defaults.Get(cfg.GroupsClaim, "groups")
+	cfg.JWKSCacheTTL = defaults.Get(cfg.JWKSCacheTTL, time.Hour)
+	return &Provider{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// discover 获取并缓存提供方元数据
+func (p *Provider) discover(ctx context.Context) (*discovery, *keySet, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, p.keys, nil
+	}
+	var d discovery
+	if err := getJSON(ctx, p.client, p.cfg.Issuer+"/.well-known/openid-configuration", &d); err != nil {
+		return nil, nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	if strings.TrimSuffix(d.Issuer, "/") != p.cfg.Issuer {
+		return nil, nil, fmt.Errorf("oidc discovery: issuer mismatch: %s", d.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, nil, errors.New("oidc discovery: incomplete provider metadata")
+	}
+	p.discovery = &d
+	p.keys = newKeySet(d.JWKSURI, p.client, p.cfg.JWKSCacheTTL)
+	return p.discovery, p.keys, nil
+}
+
+// Begin 开始一次登录，返回身份提供方的授权地址和需要由调用方保存的授权请求，
+// returnTo 会保存在授权请求中原样返回
+func (p *Provider) Begin(ctx context.Context, returnTo string) (string, *Request, error) {
+	d, _, err := p.discover(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	state, err := random.String(32)
+	if err != nil {
+		return "", nil, err
+	}
+	nonce, err := random.String(32)
+	if err != nil {
+		return "", nil, err
+	}
+	verifier, err := random.String(32)
+	if err != nil {
+		return "", nil, err
+	}
+	req := &Request{State: state, Nonce: nonce, Verifier: verifier, ReturnTo: returnTo}
+
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", p.cfg.ClientID)
+	query.Set("redirect_uri", p.cfg.RedirectURL)
+	query.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", codeChallenge(verifier))
+	query.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return d.AuthorizationEndpoint + sep + query.Encode(), req, nil
+}
+
+// Finish 校验回调中的 state 与 Begin 返回的授权请求一致，用授权码换取并校验 ID Token，返回用户信息
+func (p *Provider) Finish(ctx context.Context, req *Request, state, code string) (*Identity, error) {
+	// 防止攻击者把自己发起的回调链接发给受害者，使受害者登录到攻击者的账号
+	if req == nil || state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(req.State)) != 1 {
+		return nil, ErrInvalidState
+	}
+
+	d, keys, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rawIDToken, err := p.exchange(ctx, d.TokenEndpoint, code, req.Verifier)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(rawIDToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return keys.Get(ctx, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}),
+		jwt.WithIssuer(d.Issuer),
+		jwt.WithAudience(p.cfg.ClientID),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: invalid id_token: %w", err)
+	}
+	nonce, _ := claims["nonce"].(string)
+	if subtle.ConstantTimeCompare([]byte(nonce), []byte(req.Nonce)) != 1 {
+		return nil, ErrInvalidNonce
+	}
+
+	identity := p.mapClaims(claims)
+	if identity == nil {
+		return nil, fmt.Errorf("oidc: claim %q is empty", p.cfg.UsernameClaim)
+	}
+	return identity, nil
+}
+
+// exchange 用授权码换取 ID Token
+func (p *Provider) exchange(ctx context.Context, endpoint, code, verifier string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.cfg.RedirectURL)
+	form.Set("code_verifier", verifier)
+	form.Set("client_id", p.cfg.ClientID)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("oidc token exchange: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("oidc token exchange: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || body.Error != "" {
+		return "", fmt.Errorf("oidc token exchange: %s %s", body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return "", errors.New("oidc token exchange: no id_token in response")
+	}
+	return body.IDToken, nil
+}
+
+// mapClaims 按配置把上游声明映射为用户信息，用户名声明为空时返回 nil
+func (p *Provider) mapClaims(claims jwt.MapClaims) *Identity {
+	username, _ := claims[p.cfg.UsernameClaim].(string)
+	if username == "" {
+		return nil
+	}
+	identity := &Identity{Username: p.cfg.UsernamePrefix + username}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Email, _ = claims[p.cfg.EmailClaim].(string)
+	switch groups := claims[p.cfg.GroupsClaim].(type) {
+	case []interface{}:
+		for _, g := range groups {
+			if s, ok := g.(string); ok {
+				identity.Groups = append(identity.Groups, s)
+			}
+		}
+	case string:
+		identity.Groups = []string{groups}
+	}
+	return identity
+}
+
+// codeChallenge 计算 PKCE S256 challenge
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
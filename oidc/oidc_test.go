@@ -0,0 +1,147 @@
+package oidc
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/ipfans/authgate/oidc/oidctest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const redirectURL = "http://auth.example.com/authgate/oidc/callback"
+
+func newTestProvider(t *testing.T) (*Provider, *oidctest.Server) {
+	idp := oidctest.NewServer()
+	t.Cleanup(idp.Close)
+	p, err := New(Config{
+		Issuer:      idp.URL,
+		ClientID:    "authgate",
+		RedirectURL: redirectURL,
+	})
+	require.NoError(t, err)
+	return p, idp
+}
+
+// login 完成一次授权，返回授权请求和回调中的 state、code
+func login(t *testing.T, p *Provider, idp *oidctest.Server, returnTo string) (*Request, string, string) {
+	authURL, req, err := p.Begin(context.Background(), returnTo)
+	require.NoError(t, err)
+	u, err := url.Parse(authURL)
+	require.NoError(t, err)
+	assert.Equal(t, "S256", u.Query().Get("code_challenge_method"))
+	assert.NotEmpty(t, u.Query().Get("nonce"))
+
+	callback, err := idp.Authorize(authURL)
+	require.NoError(t, err)
+	cb, err := url.Parse(callback)
+	require.NoError(t, err)
+	assert.Equal(t, req.State, cb.Query().Get("state"))
+	assert.Equal(t, returnTo, req.ReturnTo)
+	return req, cb.Query().Get("state"), cb.Query().Get("code")
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(Config{})
+	assert.Error(t, err)
+}
+
+func TestProvider(t *testing.T) {
+	p, idp := newTestProvider(t)
+	ctx := context.Background()
+
+	t.Run("Login", func(t *testing.T) {
+		req, state, code := login(t, p, idp, "http://app.example.com")
+		identity, err := p.Finish(ctx, req, state, code)
+		require.NoError(t, err)
+		assert.Equal(t, &Identity{
+			Subject:  "user-1",
+			Username: "oidc:user-1",
+			Email:    "oidc-user@example.com",
+			Groups:   []string{"staff"},
+		}, identity)
+
+		// 授权码只能使用一次
+		_, err = p.Finish(ctx, req, state, code)
+		assert.ErrorContains(t, err, "invalid_grant")
+	})
+
+	t.Run("State mismatch", func(t *testing.T) {
+		req, _, code := login(t, p, idp, "")
+		_, err := p.Finish(ctx, req, "forged", code)
+		assert.ErrorIs(t, err, ErrInvalidState)
+		_, err = p.Finish(ctx, req, "", code)
+		assert.ErrorIs(t, err, ErrInvalidState)
+	})
+
+	t.Run("Other browser", func(t *testing.T) {
+		// 回调来自没有保存授权请求或保存了其他授权请求的浏览器
+		_, state, code := login(t, p, idp, "")
+		_, err := p.Finish(ctx, nil, state, code)
+		assert.ErrorIs(t, err, ErrInvalidState)
+		other, _, _ := login(t, p, idp, "")
+		_, err = p.Finish(ctx, other, state, code)
+		assert.ErrorIs(t, err, ErrInvalidState)
+	})
+
+	t.Run("Invalid code", func(t *testing.T) {
+		req, state, _ := login(t, p, idp, "")
+		_, err := p.Finish(ctx, req, state, "forged")
+		assert.ErrorContains(t, err, "invalid_grant")
+	})
+
+	t.Run("Nonce mismatch", func(t *testing.T) {
+		idp.SetClaims(map[string]interface{}{"nonce": "replayed"})
+		defer idp.SetClaims(map[string]interface{}{"nonce": nil})
+		req, state, code := login(t, p, idp, "")
+		_, err := p.Finish(ctx, req, state, code)
+		assert.ErrorIs(t, err, ErrInvalidNonce)
+	})
+
+	t.Run("Wrong audience", func(t *testing.T) {
+		idp.SetClaims(map[string]interface{}{"aud": "other-client"})
+		defer idp.SetClaims(map[string]interface{}{"aud": nil})
+		req, state, code := login(t, p, idp, "")
+		_, err := p.Finish(ctx, req, state, code)
+		assert.ErrorContains(t, err, "invalid id_token")
+	})
+
+	t.Run("Key rotation", func(t *testing.T) {
+		// 旧密钥已缓存，轮换后需要重新拉取 JWKS
+		p.keys.fetched = p.keys.fetched.Add(-2 * jwksRefreshInterval)
+		idp.RotateKey()
+		req, state, code := login(t, p, idp, "")
+		identity, err := p.Finish(ctx, req, state, code)
+		require.NoError(t, err)
+		assert.Equal(t, "oidc:user-1", identity.Username)
+	})
+}
+
+func TestMapClaims(t *testing.T) {
+	p, err := New(Config{
+		Issuer:         "https://idp.example.com/",
+		ClientID:       "authgate",
+		RedirectURL:    redirectURL,
+		UsernameClaim:  "email",
+		UsernamePrefix: "corp/",
+		GroupsClaim:    "roles",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "https://idp.example.com", p.cfg.Issuer)
+
+	identity := p.mapClaims(map[string]interface{}{
+		"sub":   "1",
+		"email": "bob@example.com",
+		"roles": "admin",
+	})
+	assert.Equal(t, "corp/bob@example.com", identity.Username)
+	assert.Equal(t, []string{"admin"}, identity.Groups)
+	assert.Nil(t, p.mapClaims(map[string]interface{}{"sub": "1"}))
+
+	// 默认使用 sub 并加上 oidc: 前缀，preferred_username 与本地账号相同时不会冒用本地账号
+	p, err = New(Config{Issuer: "https://idp.example.com", ClientID: "authgate", RedirectURL: redirectURL})
+	require.NoError(t, err)
+	identity = p.mapClaims(map[string]interface{}{"sub": "1", "preferred_username": "admin"})
+	assert.Equal(t, "oidc:1", identity.Username)
+}
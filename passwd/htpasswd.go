@@ -0,0 +1,47 @@
+package passwd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ParseHtpasswd 解析 Apache htpasswd 格式的内容，返回用户名到哈希的映射，
+// 仅支持 bcrypt 和 argon2id 哈希
+func ParseHtpasswd(r io.Reader) (map[string]string, error) {
+	users := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		username, hash, ok := strings.Cut(text, ":")
+		if !ok || username == "" {
+			return nil, fmt.Errorf("htpasswd line %d: malformed entry", line)
+		}
+		// 错误信息中不能包含哈希
+		if err := Validate(hash); err != nil {
+			return nil, fmt.Errorf("htpasswd line %d: user %q: %w", line, username, err)
+		}
+		users[username] = hash
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// LoadHtpasswd 读取 htpasswd 文件
+func LoadHtpasswd(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseHtpasswd(f)
+}
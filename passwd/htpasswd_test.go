@@ -0,0 +1,43 @@
+package passwd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseHtpasswd(t *testing.T) {
+	users, err := ParseHtpasswd(strings.NewReader(`
+# comment
+alice:` + aliceBcrypt + `
+
+bob:$2y$04$nHgUiXLCESMhPO3DNF8pne8NeUu6/SdgQUrbzY5qRZp65BAVUkqpK
+`))
+	require.NoError(t, err)
+	assert.Len(t, users, 2)
+	assert.True(t, Verify(users["alice"], "alicepass"))
+	assert.True(t, Verify(users["bob"], "bobpass"))
+
+	_, err = ParseHtpasswd(strings.NewReader("alice"))
+	assert.ErrorContains(t, err, "line 1")
+
+	// 不支持的哈希格式，错误中不包含哈希本身
+	_, err = ParseHtpasswd(strings.NewReader("carol:$apr1$salt$abcdefghijklmnopqrstuv"))
+	assert.ErrorIs(t, err, ErrUnsupported)
+	assert.NotContains(t, err.Error(), "apr1")
+}
+
+func TestLoadHtpasswd(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	require.NoError(t, os.WriteFile(path, []byte("alice:"+aliceBcrypt+"\n"), 0o600))
+	users, err := LoadHtpasswd(path)
+	require.NoError(t, err)
+	assert.Contains(t, users, "alice")
+
+	_, err = LoadHtpasswd(filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,79 @@
+package passwd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// bcrypt("alicepass", cost=4)
+const aliceBcrypt = "$2a$04$Qyxq4MX2nP/gc6brmzmUv.PweBjoFYUsik3Qz91pkNEdigAMzqKsO"
+
+func TestHash(t *testing.T) {
+	hash, err := Hash("secret")
+	require.NoError(t, err)
+	assert.Contains(t, hash, "$argon2id$v=19$")
+	assert.NoError(t, Validate(hash))
+	assert.True(t, Verify(hash, "secret"))
+	assert.False(t, Verify(hash, "Secret"))
+
+	// 每次使用不同的盐
+	other, err := Hash("secret")
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, other)
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name     string
+		hash     string
+		password string
+		want     bool
+	}{
+		{"bcrypt match", aliceBcrypt, "alicepass", true},
+		{"bcrypt mismatch", aliceBcrypt, "bobpass", false},
+		{"bcrypt $2y$", "$2y$" + aliceBcrypt[4:], "alicepass", true},
+		{"plaintext is rejected", "alicepass", "alicepass", false},
+		{"empty hash", "", "", false},
+		{"broken argon2id", "$argon2id$v=19$m=1,t=1,p=1$!!$!!", "x", false},
+		{"argon2id t=0", "$argon2id$v=19$m=8,t=0,p=1$c2FsdHNhbHQ$a2V5a2V5", "x", false},
+		{"argon2id p=0", "$argon2id$v=19$m=8,t=1,p=0$c2FsdHNhbHQ$a2V5a2V5", "x", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Verify(tt.hash, tt.password))
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Validate(aliceBcrypt))
+	assert.NoError(t, Validate("$argon2id$v=19$m=8,t=1,p=1$c2FsdA$a2V5"))
+	assert.ErrorIs(t, Validate("plaintext"), ErrUnsupported)
+	assert.ErrorIs(t, Validate("{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g="), ErrUnsupported)
+	assert.Error(t, Validate("$2a$04$short"))
+
+	tests := []struct {
+		name string
+		hash string
+	}{
+		{"version", "$argon2id$v=18$m=1,t=1,p=1$c2FsdA$a2V5"},
+		{"zero memory", "$argon2id$v=19$m=0,t=1,p=1$c2FsdA$a2V5"},
+		{"zero time", "$argon2id$v=19$m=8,t=0,p=1$c2FsdA$a2V5"},
+		{"zero threads", "$argon2id$v=19$m=8,t=1,p=0$c2FsdA$a2V5"},
+		{"empty salt", "$argon2id$v=19$m=8,t=1,p=1$$a2V5"},
+		{"empty key", "$argon2id$v=19$m=8,t=1,p=1$c2FsdA$"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.ErrorIs(t, Validate(tt.hash), ErrUnsupported)
+		})
+	}
+}
+
+func TestVerifyDummy(t *testing.T) {
+	assert.NotPanics(t, func() {
+		VerifyDummy("anything")
+	})
+}
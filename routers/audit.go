@@ -0,0 +1,154 @@
+package routers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/ipfans/authgate/lockout"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// 审计事件
+const (
+	auditLoginSucceeded = "login_succeeded"
+	auditLoginFailed    = "login_failed"
+	auditLoginLocked    = "login_locked"
+	auditDeviceApproved = "device_approved"
+	auditDeviceDenied   = "device_denied"
+)
+
+// audit 返回一条带有事件名和客户端 IP 的审计日志，调用方补充字段后调用 Send
+func (g *gate) audit(c *app.RequestContext, event string) *zerolog.Event {
+	return log.Info().Str("audit", event).Str("ip", g.clientIP(c))
+}
+
+// parseTrustedProxies 解析可信代理的 IP 或 CIDR 列表
+func parseTrustedProxies(trustedProxies []string) ([]*net.IPNet, error) {
+	cidrs := make([]*net.IPNet, 0, len(trustedProxies))
+	for _, proxy := range trustedProxies {
+		_, cidr, err := net.ParseCIDR(proxy)
+		if err != nil {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", proxy)
+			}
+			cidr = &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)}
+		}
+		cidrs = append(cidrs, cidr)
+	}
+	return cidrs, nil
+}
+
+// clientIPFunc 返回只信任指定代理转发头的客户端 IP 解析函数，
+// 未配置可信代理时直接使用连接的对端地址，避免伪造 X-Forwarded-For 绕过按 IP 的限制
+func clientIPFunc(cidrs []*net.IPNet) app.ClientIP {
+	return app.ClientIPWithOption(app.ClientIPOptions{
+		RemoteIPHeaders: []string{"X-Forwarded-For", "X-Real-IP"},
+		TrustedCIDRs:    cidrs,
+	})
+}
+
+// fromTrustedProxy 判断连接的对端地址是否为可信代理
+func (g *gate) fromTrustedProxy(c *app.RequestContext) bool {
+	host, _, err := net.SplitHostPort(c.RemoteAddr().String())
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, cidr := range g.trustedProxies {
+		if cidr.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// tooManyAttempts 返回 429 并通过 Retry-After 告知需要等待的秒数
+func (g *gate) tooManyAttempts(c *app.RequestContext, wait time.Duration) {
+	c.Header("Retry-After", fmt.Sprint(int(math.Ceil(wait.Seconds()))))
+	g.errorPage(c, http.StatusTooManyRequests, "error.too_many_attempts")
+}
+
+// checkLockout 检查用户名和 IP 是否被锁定，已锁定时写好 429 响应并返回 false
+func (g *gate) checkLockout(ctx context.Context, c *app.RequestContext, username string) bool {
+	wait, err := g.lockout.Check(ctx, g.clientIP(c), username)
+	if err != nil {
+		// 存储不可用时不阻止登录
+		log.Error().Err(err).Msg("Check lockout failed")
+		return true
+	}
+	if wait > 0 {
+		g.audit(c, auditLoginLocked).Str("username", username).Dur("retry_after", wait).Send()
+		g.tooManyAttempts(c, wait)
+		return false
+	}
+	return true
+}
+
+// beginAttempt 在校验凭据前预先把本次尝试记为失败，并发的尝试不会超过允许的次数。
+// 已锁定时写好 429 响应并返回 false，存储不可用时返回 nil 且不阻止登录
+func (g *gate) beginAttempt(ctx context.Context, c *app.RequestContext, username string) (*lockout.Reservation, bool) {
+	attempt, wait, err := g.lockout.Attempt(ctx, g.clientIP(c), username)
+	if err != nil {
+		log.Error().Err(err).Msg("Check lockout failed")
+		return nil, true
+	}
+	if wait > 0 {
+		g.audit(c, auditLoginLocked).Str("username", username).Dur("retry_after", wait).Send()
+		g.tooManyAttempts(c, wait)
+		return nil, false
+	}
+	return attempt, true
+}
+
+// recordFailure 记录失败的登录尝试，返回之后需要等待的时间
+func (g *gate) recordFailure(ctx context.Context, c *app.RequestContext, attempt *lockout.Reservation, username, reason string) time.Duration {
+	g.audit(c, auditLoginFailed).Str("username", username).Str("reason", reason).Send()
+	if attempt == nil {
+		return 0
+	}
+	wait, err := attempt.Fail(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Record login failure failed")
+	}
+	return wait
+}
+
+// loginFailed 记录失败的登录尝试，达到锁定条件时返回 429，否则返回 401
+func (g *gate) loginFailed(ctx context.Context, c *app.RequestContext, attempt *lockout.Reservation, username, reason string) {
+	if wait := g.recordFailure(ctx, c, attempt, username, reason); wait > 0 {
+		g.tooManyAttempts(c, wait)
+		return
+	}
+	g.errorPage(c, http.StatusUnauthorized, "error.invalid_credentials")
+}
+
+// loginSucceeded 记录成功的登录并清除用户名的失败记录
+func (g *gate) loginSucceeded(ctx context.Context, c *app.RequestContext, attempt *lockout.Reservation, username string, amr ...string) {
+	g.audit(c, auditLoginSucceeded).Str("username", username).Strs("amr", amr).Send()
+	if attempt == nil {
+		return
+	}
+	if err := attempt.Succeed(ctx); err != nil {
+		log.Error().Err(err).Msg("Reset lockout failed")
+	}
+}
+
+// releaseAttempt 撤销凭据正确但还没有完成登录的尝试，如等待第二步验证或服务端出错
+func releaseAttempt(ctx context.Context, attempt *lockout.Reservation) {
+	if attempt == nil {
+		return
+	}
+	if err := attempt.Release(ctx); err != nil {
+		log.Error().Err(err).Msg("Release lockout failed")
+	}
+}
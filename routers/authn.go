@@ -0,0 +1,129 @@
+package routers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ipfans/authgate/authn"
+	"github.com/ipfans/authgate/models"
+	"github.com/ipfans/authgate/passwd"
+	"github.com/ipfans/authgate/store"
+	"github.com/ipfans/authgate/utils/defaults"
+)
+
+// 密码登录使用的认证器
+const (
+	authenticatorLocal    = "local"
+	authenticatorStatic   = "static"
+	authenticatorHtpasswd = "htpasswd"
+	authenticatorLDAP     = "ldap"
+	authenticatorWebhook  = "webhook"
+)
+
+// AuthenticatorConfig 是认证器链中的一项，只需填写 type 对应的字段
+type AuthenticatorConfig struct {
+	Type    string              `koanf:"type"`  // local、static、htpasswd、ldap 或 webhook
+	Users   []UserConfig        `koanf:"users"` // static 的账号，格式与 users 相同，不保存到用户存储
+	File    string              `koanf:"file"`  // htpasswd 文件路径
+	LDAP    authn.LDAPConfig    `koanf:"ldap"`
+	Webhook authn.WebhookConfig `koanf:"webhook"`
+}
+
+// storeAuthenticator 使用用户存储中的密码哈希校验，包括 users、credential 和 htpasswd 中配置的账号
+type storeAuthenticator struct {
+	users store.UserStore
+}
+
+func (a storeAuthenticator) Authenticate(ctx context.Context, username, password string) (*authn.Identity, error) {
+	user, err := a.users.GetUser(ctx, username)
+	if errors.Is(err, store.ErrNotFound) {
+		// 用户不存在时同样计算一次哈希，避免通过响应时间探测用户名
+		passwd.VerifyDummy(password)
+		return nil, authn.ErrUnknownUser
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !user.CheckPassword(password) {
+		return nil, authn.ErrInvalidCredentials
+	}
+	return &authn.Identity{
+		Username: user.Username,
+		Email:    user.Email,
+		Groups:   user.Groups,
+	}, nil
+}
+
+// newAuthenticator 按 authenticators 配置创建密码登录使用的认证器链，
+// 未配置时使用 authenticator 指定的单个认证器
+func newAuthenticator(ctx context.Context, cfg Config, users store.UserStore) (authn.Authenticator, error) {
+	if len(cfg.Authenticators) == 0 {
+		return buildAuthenticator(ctx, AuthenticatorConfig{
+			Type: defaults.Get(cfg.Authenticator, authenticatorLocal),
+			LDAP: cfg.LDAP,
+		}, users)
+	}
+	chain := make(authn.Chain, 0, len(cfg.Authenticators))
+	for i, item := range cfg.Authenticators {
+		a, err := buildAuthenticator(ctx, item, users)
+		if err != nil {
+			chain.Close()
+			return nil, fmt.Errorf("authenticators[%d]: %w", i, err)
+		}
+		chain = append(chain, a)
+	}
+	return chain, nil
+}
+
+func buildAuthenticator(ctx context.Context, cfg AuthenticatorConfig, users store.UserStore) (authn.Authenticator, error) {
+	switch cfg.Type {
+	case authenticatorLocal:
+		return storeAuthenticator{users: users}, nil
+	case authenticatorStatic:
+		static := make([]*models.User, 0, len(cfg.Users))
+		for _, u := range cfg.Users {
+			user, err := u.user()
+			if err != nil {
+				return nil, fmt.Errorf("static: %w", err)
+			}
+			static = append(static, user)
+		}
+		if err := checkNotLocal(ctx, users, static...); err != nil {
+			return nil, err
+		}
+		return authn.NewStatic(static)
+	case authenticatorHtpasswd:
+		hashes, err := passwd.LoadHtpasswd(cfg.File)
+		if err != nil {
+			return nil, fmt.Errorf("htpasswd: %w", err)
+		}
+		for username := range hashes {
+			if err = checkNotLocal(ctx, users, &models.User{Username: username}); err != nil {
+				return nil, err
+			}
+		}
+		return authn.NewHtpasswd(cfg.File)
+	case authenticatorLDAP:
+		return authn.NewLDAP(cfg.LDAP)
+	case authenticatorWebhook:
+		return authn.NewWebhook(cfg.Webhook)
+	default:
+		return nil, fmt.Errorf("unsupported authenticator %q", cfg.Type)
+	}
+}
+
+// checkNotLocal 确认 static 和 htpasswd 认证器中的账号没有同时配置在 users、credential 或顶层 htpasswd 中。
+// 同一个账号有两份密码时，从其中一处删除后仍然可以通过另一处登录
+func checkNotLocal(ctx context.Context, users store.UserStore, accounts ...*models.User) error {
+	for _, account := range accounts {
+		_, err := users.GetUser(ctx, account.Username)
+		if err == nil {
+			return fmt.Errorf("user %q is also configured as a local account, configure it in one place only", account.Username)
+		}
+		if !errors.Is(err, store.ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package routers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/ipfans/authgate/authz"
+	"github.com/rs/zerolog/log"
+)
+
+// externalAllow 在访问策略通过后询问后端配置的授权服务，未配置 authz_url 时直接放行
+func (g *gate) externalAllow(ctx context.Context, c *app.RequestContext, host, method, path string, claims *Claims) bool {
+	client := g.authz[host]
+	if client == nil {
+		return true
+	}
+	allow, err := client.Allow(ctx, authz.Request{
+		User: authz.User{
+			Username: claims.Username,
+			Email:    claims.Email,
+			Groups:   claims.Groups,
+		},
+		Method:  method,
+		Host:    host,
+		Path:    path,
+		Headers: authz.FilterHeaders(requestHeader(c)),
+	})
+	if err != nil {
+		log.Error().Err(err).Str("host", host).Bool("allow", allow).Msg("Authorization webhook failed")
+	}
+	return allow
+}
+
+// requestHeader 把请求头转换为 http.Header
+func requestHeader(c *app.RequestContext) http.Header {
+	header := http.Header{}
+	c.Request.Header.VisitAll(func(k, v []byte) {
+		header.Add(string(k), string(v))
+	})
+	return header
+}
@@ -0,0 +1,205 @@
+package routers
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/network"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// 客户端证书中可以用作用户名的字段
+const (
+	certFieldCN    = "cn"
+	certFieldEmail = "email"
+	certFieldDNS   = "dns"
+	certFieldURI   = "uri"
+)
+
+// TLSConfig 是 AuthGate 自身监听 HTTPS 时使用的证书，启用客户端证书认证时必须配置
+type TLSConfig struct {
+	Cert string `koanf:"cert"` // PEM 格式的证书文件
+	Key  string `koanf:"key"`  // PEM 格式的私钥文件
+}
+
+// ClientCertConfig 是后端的客户端证书认证配置，通过校验的证书可以代替登录 Cookie
+type ClientCertConfig struct {
+	CA       string           `koanf:"ca"`       // PEM 格式的 CA 证书文件，可以包含多个证书，为空时不启用
+	Username string           `koanf:"username"` // 用作用户名的字段：cn（默认）、email、dns、uri
+	Users    []ClientCertUser `koanf:"users"`    // 证书到用户的映射，配置后只接受映射中的证书
+}
+
+// ClientCertUser 把证书中 username 字段的值映射为用户和用户组
+type ClientCertUser struct {
+	Match    string   `koanf:"match"` // 证书字段的值，如 CN 或 SAN 中的邮箱
+	Username string   `koanf:"username"`
+	Groups   []string `koanf:"groups"`
+}
+
+// clientCertVerifier 校验客户端证书并把证书映射为用户
+type clientCertVerifier struct {
+	roots *x509.CertPool
+	field string
+	users map[string]ClientCertUser
+}
+
+// newClientCertVerifier 加载 CA 证书，未配置 CA 时返回 nil
+func newClientCertVerifier(cfg ClientCertConfig) (*clientCertVerifier, error) {
+	if cfg.CA == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(cfg.CA)
+	if err != nil {
+		return nil, fmt.Errorf("client_cert: %w", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("client_cert: no certificates found in %s", cfg.CA)
+	}
+	v := &clientCertVerifier{roots: roots, field: cfg.Username}
+	switch v.field {
+	case "":
+		v.field = certFieldCN
+	case certFieldCN, certFieldEmail, certFieldDNS, certFieldURI:
+	default:
+		return nil, fmt.Errorf("client_cert: unsupported username field %q", cfg.Username)
+	}
+	if len(cfg.Users) > 0 {
+		v.users = make(map[string]ClientCertUser, len(cfg.Users))
+		for _, u := range cfg.Users {
+			if u.Match == "" || u.Username == "" {
+				return nil, errors.New("client_cert: users require match and username")
+			}
+			v.users[u.Match] = u
+		}
+	}
+	return v, nil
+}
+
+// values 返回证书中用作用户名的字段值，SAN 可能有多个
+func (v *clientCertVerifier) values(cert *x509.Certificate) []string {
+	switch v.field {
+	case certFieldEmail:
+		return cert.EmailAddresses
+	case certFieldDNS:
+		return cert.DNSNames
+	case certFieldURI:
+		values := make([]string, 0, len(cert.URIs))
+		for _, u := range cert.URIs {
+			values = append(values, u.String())
+		}
+		return values
+	default:
+		if cert.Subject.CommonName == "" {
+			return nil
+		}
+		return []string{cert.Subject.CommonName}
+	}
+}
+
+// identify 校验证书链，并按配置返回证书对应的用户。
+// 没有映射时直接使用证书字段作为用户名，证书的 OU 作为用户组
+func (v *clientCertVerifier) identify(certs []*x509.Certificate) (*Claims, error) {
+	if len(certs) == 0 {
+		return nil, errors.New("no client certificate")
+	}
+	leaf := certs[0]
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         v.roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		return nil, err
+	}
+	claims := &Claims{
+		AMR: []string{amrSoftwareKey},
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(leaf.NotAfter),
+		},
+	}
+	if len(leaf.EmailAddresses) > 0 {
+		claims.Email = leaf.EmailAddresses[0]
+	}
+	for _, value := range v.values(leaf) {
+		if v.users == nil {
+			claims.Username = value
+			claims.Groups = leaf.Subject.OrganizationalUnit
+			break
+		}
+		if u, ok := v.users[value]; ok {
+			claims.Username = u.Username
+			claims.Groups = u.Groups
+			break
+		}
+	}
+	if claims.Username == "" {
+		return nil, fmt.Errorf("client certificate %q is not mapped to a user", leaf.Subject)
+	}
+	claims.Subject = claims.Username
+	return claims, nil
+}
+
+// peerCertificates 返回 TLS 连接上客户端提供的证书，非 TLS 连接返回 nil
+func peerCertificates(c *app.RequestContext) []*x509.Certificate {
+	conn, ok := c.GetConn().(network.ConnTLSer)
+	if !ok {
+		return nil
+	}
+	return conn.ConnectionState().PeerCertificates
+}
+
+// ServerTLS 返回 AuthGate 监听 HTTPS 使用的配置，未配置证书时返回 nil。
+// 有后端启用客户端证书认证时请求客户端证书，但不在握手阶段校验，
+// 浏览器仍可以不带证书访问，证书由各后端使用自己的 CA 校验
+func ServerTLS(cfg Config) (*tls.Config, error) {
+	if cfg.TLS.Cert == "" {
+		for _, backend := range cfg.Backends {
+			if backend.ClientCert.CA != "" {
+				return nil, fmt.Errorf("backend %s: client_cert requires tls.cert and tls.key", backend.Host)
+			}
+		}
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.TLS.Cert, cfg.TLS.Key)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	for _, backend := range cfg.Backends {
+		if backend.ClientCert.CA != "" {
+			tlsConfig.ClientAuth = tls.RequestClientCert
+			break
+		}
+	}
+	return tlsConfig, nil
+}
+
+// certUser 使用客户端证书识别用户，后端未启用或证书未通过校验时返回 false，继续检查令牌和 Cookie
+func (g *gate) certUser(c *app.RequestContext, host string) (*Claims, bool) {
+	v := g.clientCerts[host]
+	if v == nil {
+		return nil, false
+	}
+	certs := peerCertificates(c)
+	if len(certs) == 0 {
+		return nil, false
+	}
+	claims, err := v.identify(certs)
+	if err != nil {
+		log.Debug().Err(err).Str("host", host).Msg("Client certificate rejected")
+		return nil, false
+	}
+	return claims, true
+}
@@ -0,0 +1,197 @@
+package routers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/app/server"
+	"github.com/cloudwego/hertz/pkg/common/utils"
+	"github.com/ipfans/authgate/device"
+	"github.com/rs/zerolog/log"
+)
+
+// deviceGrantType 是 RFC 8628 轮询令牌时使用的 grant_type
+const deviceGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// deviceForm 是设备授权页面的参数，Step 为空时显示输入 user_code 的表单
+type deviceForm struct {
+	Step     string // confirm、approved 或 denied
+	UserCode string
+	ClientID string
+	Username string
+	CSRF     string
+	Error    string // 错误消息 ID
+}
+
+// deviceError 按 RFC 6749 5.2 的格式返回令牌接口的错误
+func deviceError(c *app.RequestContext, code string) {
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusBadRequest, utils.H{"error": code})
+}
+
+// devicePollError 把轮询结果转换为 RFC 8628 3.5 定义的错误码
+func devicePollError(err error) string {
+	switch {
+	case errors.Is(err, device.ErrPending):
+		return "authorization_pending"
+	case errors.Is(err, device.ErrSlowDown):
+		return "slow_down"
+	case errors.Is(err, device.ErrExpired):
+		return "expired_token"
+	case errors.Is(err, device.ErrDenied):
+		return "access_denied"
+	default:
+		return "invalid_grant"
+	}
+}
+
+// registerDeviceRoutes 注册设备授权接口，供没有浏览器的命令行工具登录，
+// 签发的 JWT 可以通过 Authorization: Bearer 访问后端，与登录 Cookie 等效
+func registerDeviceRoutes(e *server.Hertz, g *gate, allowMiddleware app.HandlerFunc) {
+	if !g.cfg.Device.Enabled {
+		return
+	}
+	flow := device.New(g.cfg.Device, device.NewMemory())
+
+	e.POST("/authgate/device/code", allowMiddleware, func(ctx context.Context, c *app.RequestContext) {
+		deviceCode, a, err := flow.Start(ctx, c.PostForm("client_id"))
+		if err != nil {
+			log.Error().Err(err).Msg("Start device authorization failed")
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		userCode := device.FormatUserCode(a.UserCode)
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, utils.H{
+			"device_code":               deviceCode,
+			"user_code":                 userCode,
+			"verification_uri":          g.authURL("/authgate/device"),
+			"verification_uri_complete": g.authURL("/authgate/device?user_code=" + url.QueryEscape(userCode)),
+			"expires_in":                int(flow.ExpiresIn().Seconds()),
+			"interval":                  int(a.Interval.Seconds()),
+		})
+	})
+
+	e.POST("/authgate/device/token", allowMiddleware, func(ctx context.Context, c *app.RequestContext) {
+		if c.PostForm("grant_type") != deviceGrantType {
+			deviceError(c, "unsupported_grant_type")
+			return
+		}
+		a, err := flow.Poll(ctx, c.PostForm("device_code"))
+		if err != nil {
+			deviceError(c, devicePollError(err))
+			return
+		}
+		if clientID := c.PostForm("client_id"); clientID != "" && clientID != a.ClientID {
+			deviceError(c, "invalid_grant")
+			return
+		}
+		// 按批准时的登录信息签发，LDAP、OIDC 等不在用户存储中的用户同样可以批准
+		token, err := g.issueToken(ctx, &Claims{
+			Username: a.Approver.Username,
+			Email:    a.Approver.Email,
+			Groups:   a.Approver.Groups,
+			AMR:      a.Approver.AMR,
+			IDP:      a.Approver.IDP,
+		})
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, utils.H{
+			"access_token": token,
+			"token_type":   "Bearer",
+			"expires_in":   int(g.cfg.Session.Lifetime.Seconds()),
+		})
+	})
+
+	// 用户在浏览器中输入设备上显示的 user_code，未登录时先登录再回到该页面
+	e.GET("/authgate/device", allowMiddleware, func(ctx context.Context, c *app.RequestContext) {
+		userCode := c.Query("user_code")
+		claims, ok := g.currentUser(ctx, c)
+		if !ok {
+			target := g.authURL("/authgate/device")
+			if userCode != "" {
+				target += "?user_code=" + url.QueryEscape(userCode)
+			}
+			state, err := g.newState(c, target)
+			if err != nil {
+				c.AbortWithStatus(http.StatusInternalServerError)
+				return
+			}
+			c.Redirect(http.StatusFound, []byte(g.loginURL(target, state)))
+			return
+		}
+		if userCode == "" {
+			g.render(c, http.StatusOK, "device.html", deviceForm{})
+			return
+		}
+		a, err := flow.Lookup(ctx, userCode)
+		if err != nil {
+			g.render(c, http.StatusBadRequest, "device.html", deviceForm{Error: "device.invalid_code"})
+			return
+		}
+		csrf, err := g.csrfToken(c)
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		g.render(c, http.StatusOK, "device.html", deviceForm{
+			Step:     "confirm",
+			UserCode: device.FormatUserCode(a.UserCode),
+			ClientID: a.ClientID,
+			Username: claims.Username,
+			CSRF:     csrf,
+		})
+	})
+
+	e.POST("/authgate/device", allowMiddleware, func(ctx context.Context, c *app.RequestContext) {
+		if !g.checkCSRF(c) {
+			return
+		}
+		claims, ok := g.currentUser(ctx, c)
+		if !ok {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		userCode := c.PostForm("user_code")
+		var err error
+		form := deviceForm{}
+		switch c.PostForm("action") {
+		case "approve":
+			err = flow.Approve(ctx, userCode, device.Approver{
+				Username: claims.Username,
+				Email:    claims.Email,
+				Groups:   claims.Groups,
+				AMR:      claims.AMR,
+				IDP:      claims.IDP,
+			})
+			form.Step = "approved"
+		case "deny":
+			err = flow.Deny(ctx, userCode)
+			form.Step = "denied"
+		default:
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, device.ErrNotFound) {
+			g.render(c, http.StatusBadRequest, "device.html", deviceForm{Error: "device.invalid_code"})
+			return
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("Update device authorization failed")
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		event := auditDeviceApproved
+		if form.Step == "denied" {
+			event = auditDeviceDenied
+		}
+		g.audit(c, event).Str("username", claims.Username).Str("user_code", device.NormalizeUserCode(userCode)).Send()
+		g.render(c, http.StatusOK, "device.html", form)
+	})
+}
@@ -0,0 +1,96 @@
+package routers
+
+import (
+	"strings"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ipfans/authgate/utils/defaults"
+	"github.com/ipfans/authgate/utils/random"
+	"github.com/rs/zerolog/log"
+)
+
+// headerAssertion 是转发给后端的签名身份断言
+const headerAssertion = "X-AuthGate-Assertion"
+
+// IdentityConfig 配置转发给后端的身份信息
+type IdentityConfig struct {
+	Headers      bool          `koanf:"headers"`       // 是否设置身份请求头
+	UserHeader   string        `koanf:"user_header"`   // 用户名请求头，默认 X-Auth-User
+	EmailHeader  string        `koanf:"email_header"`  // 邮箱请求头，默认 X-Auth-Email
+	GroupsHeader string        `koanf:"groups_header"` // 用户组请求头，逗号分隔，默认 X-Auth-Groups
+	Assertion    bool          `koanf:"assertion"`     // 是否设置 X-AuthGate-Assertion 签名断言
+	AssertionTTL time.Duration `koanf:"assertion_ttl"` // 断言有效期，默认 1 分钟
+}
+
+// AssertionClaims 是 X-AuthGate-Assertion 的内容，后端可通过 JWKS 校验签名
+type AssertionClaims struct {
+	Email  string   `json:"email,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// identityDefaults 补全身份配置的默认值
+func identityDefaults(cfg IdentityConfig) IdentityConfig {
+	cfg.UserHeader = defaults.Get(cfg.UserHeader, headerAuthUser)
+	cfg.EmailHeader = defaults.Get(cfg.EmailHeader, headerAuthEmail)
+	cfg.GroupsHeader = defaults.Get(cfg.GroupsHeader, headerAuthGroups)
+	cfg.AssertionTTL = defaults.Get(cfg.AssertionTTL, time.Minute)
+	return cfg
+}
+
+// setIdentity 删除客户端伪造的身份请求头，再按配置写入当前用户的身份，
+// host 作为断言的 aud，claims 为 nil 时（如公开路径）只做删除
+func (g *gate) setIdentity(c *app.RequestContext, cfg IdentityConfig, host string, claims *Claims) {
+	header := &c.Request.Header
+	for _, name := range []string{
+		headerAuthUser, headerAuthEmail, headerAuthGroups, headerAssertion,
+		cfg.UserHeader, cfg.EmailHeader, cfg.GroupsHeader,
+	} {
+		header.Del(name)
+	}
+	if claims == nil {
+		return
+	}
+
+	if cfg.Headers {
+		header.Set(cfg.UserHeader, claims.Username)
+		if claims.Email != "" {
+			header.Set(cfg.EmailHeader, claims.Email)
+		}
+		if len(claims.Groups) > 0 {
+			header.Set(cfg.GroupsHeader, strings.Join(claims.Groups, ","))
+		}
+	}
+	if cfg.Assertion {
+		assertion, err := g.newAssertion(cfg, host, claims)
+		if err != nil {
+			log.Error().Err(err).Str("username", claims.Username).Msg("Sign assertion failed")
+			return
+		}
+		header.Set(headerAssertion, assertion)
+	}
+}
+
+// newAssertion 为后端签发短期身份断言
+func (g *gate) newAssertion(cfg IdentityConfig, host string, claims *Claims) (string, error) {
+	id, err := random.String(16)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	return g.keys.Sign(&AssertionClaims{
+		Email:  claims.Email,
+		Groups: claims.Groups,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        id,
+			Issuer:    g.authURL(""),
+			Subject:   claims.Username,
+			Audience:  jwt.ClaimStrings{host},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(cfg.AssertionTTL)),
+		},
+	})
+}
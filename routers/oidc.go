@@ -0,0 +1,117 @@
+package routers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/app/server"
+	"github.com/cloudwego/hertz/pkg/protocol"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ipfans/authgate/oidc"
+	"github.com/ipfans/authgate/utils/defaults"
+	"github.com/rs/zerolog/log"
+)
+
+// oidcCookie 保存在认证域名上，内容为签名的授权请求，把身份提供方的回调与发起登录的浏览器绑定
+const oidcCookie = "authgate_oidc"
+
+// ticketOIDC 是 OIDC 授权请求 Cookie 的用途
+const ticketOIDC = "oidc"
+
+// oidcClaims 是 OIDC 授权请求 Cookie 的内容，没有 username 字段，不会被当作登录令牌接受
+type oidcClaims struct {
+	Purpose string `json:"purpose"`
+	oidc.Request
+	jwt.RegisteredClaims
+}
+
+// registerOIDCRoutes 注册通过上游 OpenID Connect 身份提供方登录的接口，未配置 issuer 时不启用
+func registerOIDCRoutes(e *server.Hertz, g *gate, allowMiddleware app.HandlerFunc) error {
+	cfg := g.cfg.OIDC
+	if cfg.Issuer == "" {
+		return nil
+	}
+	cfg.RedirectURL = defaults.Get(cfg.RedirectURL, g.authURL("/authgate/oidc/callback"))
+	provider, err := oidc.New(cfg)
+	if err != nil {
+		return err
+	}
+
+	e.GET("/authgate/oidc/login", allowMiddleware, func(ctx context.Context, c *app.RequestContext) {
+		if !g.checkRedirect(c, c.Query("host"), c.Query("state")) {
+			return
+		}
+		// 身份提供方回调时取回 state，登录完成后据此跳转
+		authURL, req, err := provider.Begin(ctx, c.Query("state"))
+		if err != nil {
+			log.Error().Err(err).Msg("Begin OIDC login failed")
+			c.AbortWithStatus(http.StatusBadGateway)
+			return
+		}
+		// 授权请求保存在浏览器的签名 Cookie 中，服务端不保存未完成的登录
+		now := time.Now()
+		signed, err := g.keys.Sign(&oidcClaims{
+			Purpose: ticketOIDC,
+			Request: *req,
+			RegisteredClaims: jwt.RegisteredClaims{
+				IssuedAt:  jwt.NewNumericDate(now),
+				ExpiresAt: jwt.NewNumericDate(now.Add(stateTimeout)),
+			},
+		})
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		// 回调是从身份提供方跨站跳转回来的，需要 Lax 才能带上 Cookie
+		c.SetCookie(oidcCookie, signed, int(stateTimeout.Seconds()), "/authgate/oidc", "",
+			protocol.CookieSameSiteLaxMode, g.cfg.Cookies.Secure, true)
+		c.Redirect(http.StatusFound, []byte(authURL))
+	})
+
+	e.GET("/authgate/oidc/callback", allowMiddleware, func(ctx context.Context, c *app.RequestContext) {
+		if errCode := c.Query("error"); errCode != "" {
+			log.Warn().Str("error", errCode).Str("description", c.Query("error_description")).Msg("OIDC login denied")
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		claims := &oidcClaims{}
+		err := g.keys.Parse(string(c.Cookie(oidcCookie)), claims)
+		// 授权请求只能使用一次
+		c.SetCookie(oidcCookie, "", -1, "/authgate/oidc", "", protocol.CookieSameSiteLaxMode, g.cfg.Cookies.Secure, true)
+		if err != nil || claims.Purpose != ticketOIDC {
+			log.Warn().Msg("OIDC login failed: no authorization request in this browser")
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		identity, err := provider.Finish(ctx, &claims.Request, c.Query("state"), c.Query("code"))
+		if err != nil {
+			log.Warn().Err(err).Msg("OIDC login failed")
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		state := claims.ReturnTo
+
+		// OIDC 用户不对应本地账号，令牌中记录 issuer 以便区分
+		token, err := g.issueToken(ctx, &Claims{
+			Username: identity.Username,
+			Email:    identity.Email,
+			Groups:   identity.Groups,
+			IDP:      g.cfg.OIDC.Issuer,
+		})
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		g.audit(c, auditLoginSucceeded).Str("username", identity.Username).Str("issuer", g.cfg.OIDC.Issuer).Send()
+		g.setTokenCookie(c, token)
+		redirect, err := g.loginRedirect(ctx, token, state)
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		c.Redirect(http.StatusTemporaryRedirect, []byte(redirect))
+	})
+	return nil
+}
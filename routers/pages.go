@@ -0,0 +1,144 @@
+package routers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/app/server"
+	"github.com/rs/zerolog/log"
+)
+
+// langCookie 保存用户选择的页面语言，优先于 Accept-Language
+const langCookie = "lang"
+
+// render 按请求的语言使用 ui 模板渲染页面，模板执行失败时返回 500
+func (g *gate) render(c *app.RequestContext, status int, name string, data any) {
+	lang := g.ui.Language(string(c.GetHeader("Accept-Language")), string(c.Cookie(langCookie)))
+	var buf bytes.Buffer
+	if err := g.ui.Render(&buf, lang, name, data); err != nil {
+		log.Error().Err(err).Str("page", name).Msg("Render page failed")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.Header("Content-Language", lang)
+	c.Header("Vary", "Accept-Language, Cookie")
+	c.Data(status, "text/html; charset=utf-8", buf.Bytes())
+}
+
+// errorPage 是错误页面的参数，均为翻译文件中的消息 ID
+type errorPage struct {
+	Title   string
+	Message string
+}
+
+// errorPage 返回通用的错误页面，标题为状态码对应的消息，message 为消息 ID
+func (g *gate) errorPage(c *app.RequestContext, status int, message string) {
+	g.render(c, status, "error.html", errorPage{
+		Title:   fmt.Sprintf("status.%d", status),
+		Message: message,
+	})
+}
+
+// forbidden 返回 403 页面，用于已登录但没有访问权限的请求
+func (g *gate) forbidden(c *app.RequestContext) {
+	g.render(c, http.StatusForbidden, "forbidden.html", errorPage{
+		Message: "forbidden.message",
+	})
+}
+
+// mfaRequired 返回 403 页面，用于要求两步验证的后端
+func (g *gate) mfaRequired(c *app.RequestContext) {
+	g.render(c, http.StatusForbidden, "forbidden.html", errorPage{
+		Message: "forbidden.mfa_required",
+	})
+}
+
+// logoutForm 是确认注销页面的参数
+type logoutForm struct {
+	CSRF string
+}
+
+// logoutPage 返回确认注销页面，注销只接受带 CSRF 令牌的 POST 请求
+func (g *gate) logoutPage(c *app.RequestContext) {
+	token, err := g.csrfToken(c)
+	if err != nil {
+		log.Error().Err(err).Msg("Generate CSRF token failed")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	g.render(c, http.StatusOK, "logout.html", logoutForm{CSRF: token})
+}
+
+// loggedOut 返回注销成功页面
+func (g *gate) loggedOut(c *app.RequestContext) {
+	g.render(c, http.StatusOK, "logout.html", nil)
+}
+
+// invalidRedirect 返回 400 页面，用于登录后返回的站点不被允许的请求
+func (g *gate) invalidRedirect(c *app.RequestContext) {
+	g.errorPage(c, http.StatusBadRequest, "error.invalid_redirect")
+}
+
+// totpForm 是输入 TOTP 验证码页面的参数
+type totpForm struct {
+	Ticket string
+	Error  string // 错误消息 ID
+}
+
+// totpPrompt 返回登录第二步输入 TOTP 验证码或恢复码的页面
+func (g *gate) totpPrompt(c *app.RequestContext, status int, ticket string) {
+	form := totpForm{Ticket: ticket}
+	if status == http.StatusUnauthorized {
+		form.Error = "totp.invalid_code"
+	}
+	g.render(c, status, "totp.html", form)
+}
+
+// loginForm 是登录页的参数
+type loginForm struct {
+	Host  string // 登录后返回的站点
+	State string // 受保护域名签发的 state
+	CSRF  string
+	OIDC  string // OpenID Connect 登录入口，未配置时为空
+}
+
+// loginPage 返回密码登录页面
+func (g *gate) loginPage(c *app.RequestContext, form loginForm) {
+	if g.cfg.OIDC.Issuer != "" {
+		query := url.Values{}
+		query.Set("host", form.Host)
+		query.Set("state", form.State)
+		form.OIDC = "/authgate/oidc/login?" + query.Encode()
+	}
+	g.render(c, http.StatusOK, "login.html", form)
+}
+
+// registerStaticRoutes 在所有域名上提供页面使用的静态资源，受保护域名上的错误页面同样需要
+func registerStaticRoutes(e *server.Hertz, g *gate) {
+	e.GET("/authgate/static/*filepath", func(ctx context.Context, c *app.RequestContext) {
+		name := strings.TrimPrefix(c.Param("filepath"), "/")
+		if !fs.ValidPath(name) {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		data, err := fs.ReadFile(g.ui.Static(), name)
+		if err != nil {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		contentType := mime.TypeByExtension(path.Ext(name))
+		if contentType == "" {
+			contentType = http.DetectContentType(data)
+		}
+		c.Header("Cache-Control", "public, max-age=3600")
+		c.Data(http.StatusOK, contentType, data)
+	})
+}
@@ -0,0 +1,354 @@
+package routers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/app/server"
+	"github.com/cloudwego/hertz/pkg/common/utils"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ipfans/authgate/models"
+	"github.com/ipfans/authgate/store"
+	"github.com/ipfans/authgate/utils/random"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// accessTokenPrefix 是个人访问令牌的前缀，便于识别和扫描泄露的令牌
+	accessTokenPrefix = "agp_"
+	// defaultAccessTokenLifetime 是未指定 expires_in 时令牌的有效期
+	defaultAccessTokenLifetime = 90 * 24 * time.Hour
+	// maxAccessTokenLifetime 是令牌的最长有效期
+	maxAccessTokenLifetime = 366 * 24 * time.Hour
+)
+
+var errInvalidScope = errors.New("invalid scope")
+
+// hashAccessToken 返回令牌密钥的哈希，令牌本身是高熵随机值，不需要慢哈希
+func hashAccessToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// newAccessToken 生成个人访问令牌，返回令牌明文和需要保存的记录，令牌格式为 agp_<ID>_<密钥>
+func newAccessToken(name string, scopes, amr []string, lifetime time.Duration, now time.Time) (string, models.AccessToken, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", models.AccessToken{}, err
+	}
+	secret, err := random.String(32)
+	if err != nil {
+		return "", models.AccessToken{}, err
+	}
+	token := models.AccessToken{
+		ID:        hex.EncodeToString(id),
+		Name:      name,
+		Hash:      hashAccessToken(secret),
+		Scopes:    scopes,
+		AMR:       amr,
+		CreatedAt: now.UTC().Truncate(time.Second),
+		ExpiresAt: now.Add(lifetime).UTC().Truncate(time.Second),
+	}
+	return accessTokenPrefix + token.ID + "_" + secret, token, nil
+}
+
+// splitAccessToken 拆分令牌中的 ID 和密钥，ID 为十六进制，不含下划线
+func splitAccessToken(token string) (id, secret string, ok bool) {
+	rest, ok := strings.CutPrefix(token, accessTokenPrefix)
+	if !ok {
+		return "", "", false
+	}
+	id, secret, ok = strings.Cut(rest, "_")
+	return id, secret, ok && id != "" && secret != ""
+}
+
+// requestAccessToken 从 Authorization 头中读取个人访问令牌，支持 Bearer 和 Basic（用户名加令牌作为密码），
+// 其他凭据原样交给后端处理
+func requestAccessToken(c *app.RequestContext) (username, token string, ok bool) {
+	auth := string(c.GetHeader("Authorization"))
+	if bearer, found := strings.CutPrefix(auth, "Bearer "); found {
+		token = strings.TrimSpace(bearer)
+		return "", token, strings.HasPrefix(token, accessTokenPrefix)
+	}
+	if basic, found := strings.CutPrefix(auth, "Basic "); found {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(basic))
+		if err != nil {
+			return "", "", false
+		}
+		username, token, found = strings.Cut(string(decoded), ":")
+		return username, token, found && strings.HasPrefix(token, accessTokenPrefix)
+	}
+	return "", "", false
+}
+
+// accessTokenUser 校验个人访问令牌，令牌有效且允许访问 host 时返回对应的用户信息
+func (g *gate) accessTokenUser(ctx context.Context, username, token, host string) (*Claims, bool) {
+	id, secret, ok := splitAccessToken(token)
+	if !ok {
+		return nil, false
+	}
+	user, err := g.users.GetUserByAccessToken(ctx, id)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			log.Error().Err(err).Msg("Load access token failed")
+		}
+		return nil, false
+	}
+	t := user.FindAccessToken(id)
+	if t == nil || subtle.ConstantTimeCompare([]byte(hashAccessToken(secret)), []byte(t.Hash)) != 1 {
+		return nil, false
+	}
+	if username != "" && username != user.Username {
+		return nil, false
+	}
+	if !time.Now().Before(t.ExpiresAt) || !scopeAllows(t.Scopes, host) {
+		return nil, false
+	}
+	return &Claims{
+		Username: user.Username,
+		Email:    user.Email,
+		Groups:   user.Groups,
+		AMR:      t.AMR,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        t.ID,
+			Subject:   user.Username,
+			ExpiresAt: jwt.NewNumericDate(t.ExpiresAt),
+		},
+	}, true
+}
+
+// scopeAllows 判断令牌能否访问 host，scopes 为空时不限制，支持 "*.example.com" 通配子域名
+func scopeAllows(scopes []string, host string) bool {
+	if len(scopes) == 0 {
+		return true
+	}
+	host = strings.ToLower(host)
+	for _, scope := range scopes {
+		if (redirectPattern{host: scope}).match("", host) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseScopes 校验并规范化创建令牌时提交的 scope
+func parseScopes(values []string) ([]string, error) {
+	var scopes []string
+	for _, value := range values {
+		for _, scope := range strings.Split(value, ",") {
+			scope = strings.ToLower(strings.TrimSpace(scope))
+			if scope == "" {
+				continue
+			}
+			if strings.Contains(scope, "://") {
+				return nil, errInvalidScope
+			}
+			if _, err := parseRedirectPatterns([]string{scope}); err != nil {
+				return nil, errInvalidScope
+			}
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes, nil
+}
+
+// unauthorized 返回 401，用于携带 Authorization 的脚本请求，不跳转到登录页
+func unauthorized(c *app.RequestContext) {
+	c.Header("WWW-Authenticate", `Bearer realm="AuthGate"`)
+	c.Response.Header.Add("WWW-Authenticate", `Basic realm="AuthGate"`)
+	c.JSON(http.StatusUnauthorized, utils.H{"error": "invalid_token"})
+}
+
+// requestUser 识别请求的用户，依次使用个人访问令牌、Bearer 方式携带的 AuthGate JWT（如设备授权签发的令牌）和登录 Cookie。
+// 未登录时 machine 为 true 表示请求携带了 Authorization，应返回 401 而不是跳转到登录页
+func (g *gate) requestUser(ctx context.Context, c *app.RequestContext, host string) (claims *Claims, ok, machine bool) {
+	if username, token, found := requestAccessToken(c); found {
+		claims, ok = g.accessTokenUser(ctx, username, token, host)
+		if ok {
+			// 令牌只用于 AuthGate，不转发给后端
+			c.Request.Header.Del("Authorization")
+		}
+		return claims, ok, true
+	}
+	// 不是 AuthGate 签发的 Bearer 令牌可能属于后端自身，继续检查 Cookie
+	if token, found := strings.CutPrefix(string(c.GetHeader("Authorization")), "Bearer "); found {
+		if claims, err := g.parseToken(ctx, strings.TrimSpace(token)); err == nil {
+			c.Request.Header.Del("Authorization")
+			return claims, true, true
+		}
+	}
+	claims, ok = g.currentUser(ctx, c)
+	return claims, ok, len(c.GetHeader("Authorization")) > 0
+}
+
+// accessTokenInfo 是接口返回的令牌信息，不包含哈希
+type accessTokenInfo struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Scopes    []string  `json:"scopes"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Token     string    `json:"token,omitempty"` // 只在创建时返回
+}
+
+func accessTokenInfos(tokens []models.AccessToken) []accessTokenInfo {
+	infos := make([]accessTokenInfo, 0, len(tokens))
+	for _, t := range tokens {
+		infos = append(infos, accessTokenInfo{
+			ID:        t.ID,
+			Name:      t.Name,
+			Scopes:    append([]string{}, t.Scopes...),
+			CreatedAt: t.CreatedAt,
+			ExpiresAt: t.ExpiresAt,
+		})
+	}
+	return infos
+}
+
+// removeAccessToken 删除用户的令牌，令牌不存在时返回 404
+func (g *gate) removeAccessToken(ctx context.Context, c *app.RequestContext, user *models.User, id string) {
+	if user.FindAccessToken(id) == nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if err := g.users.RemoveAccessToken(ctx, user.Username, id); err != nil {
+		log.Error().Err(err).Str("username", user.Username).Msg("Revoke access token failed")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	log.Info().Str("username", user.Username).Str("token_id", id).Msg("Access token revoked")
+	c.Status(http.StatusNoContent)
+}
+
+// adminUser 为管理接口读取路径中的用户，用户不存在时返回 404
+func (g *gate) adminUser(ctx context.Context, c *app.RequestContext) (*models.User, bool) {
+	if !g.checkAdmin(c) {
+		return nil, false
+	}
+	username := c.Param("username")
+	user, err := g.users.GetUser(ctx, username)
+	if errors.Is(err, store.ErrNotFound) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		log.Error().Err(err).Str("username", username).Msg("Load user failed")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return nil, false
+	}
+	return user, true
+}
+
+// registerAccessTokenRoutes 注册个人访问令牌的管理接口，只能使用登录 Cookie 调用，令牌不能用于创建新令牌
+func registerAccessTokenRoutes(e *server.Hertz, g *gate, allowMiddleware app.HandlerFunc) {
+	e.GET("/authgate/tokens", allowMiddleware, func(ctx context.Context, c *app.RequestContext) {
+		claims, ok := g.currentUser(ctx, c)
+		if !ok {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		user, ok := g.localUser(ctx, c, claims)
+		if !ok {
+			return
+		}
+		// 创建和删除令牌需要带上这里返回的 CSRF 令牌
+		csrf, err := g.csrfToken(c)
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		c.JSON(http.StatusOK, utils.H{"tokens": accessTokenInfos(user.AccessTokens), "csrf_token": csrf})
+	})
+
+	// 创建令牌，明文只在响应中返回一次
+	e.POST("/authgate/tokens", allowMiddleware, func(ctx context.Context, c *app.RequestContext) {
+		claims, ok := g.currentUser(ctx, c)
+		if !ok {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		if !checkAPICSRF(c) {
+			return
+		}
+		name := strings.TrimSpace(c.PostForm("name"))
+		if name == "" {
+			c.JSON(http.StatusBadRequest, utils.H{"error": "name is required"})
+			return
+		}
+		scopes, err := parseScopes(c.PostFormArray("scope"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, utils.H{"error": "invalid scope"})
+			return
+		}
+		lifetime := defaultAccessTokenLifetime
+		if v := c.PostForm("expires_in"); v != "" {
+			if lifetime, err = time.ParseDuration(v); err != nil || lifetime <= 0 || lifetime > maxAccessTokenLifetime {
+				c.JSON(http.StatusBadRequest, utils.H{"error": "invalid expires_in"})
+				return
+			}
+		}
+		if _, ok = g.localUser(ctx, c, claims); !ok {
+			return
+		}
+		plain, token, err := newAccessToken(name, scopes, claims.AMR, lifetime, time.Now())
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		if err = g.users.AddAccessToken(ctx, claims.Username, token); err != nil {
+			log.Error().Err(err).Str("username", claims.Username).Msg("Create access token failed")
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		log.Info().Str("username", claims.Username).Str("token_id", token.ID).Str("name", name).Msg("Access token created")
+		info := accessTokenInfos([]models.AccessToken{token})[0]
+		info.Token = plain
+		c.JSON(http.StatusCreated, info)
+	})
+
+	e.DELETE("/authgate/tokens/:id", allowMiddleware, func(ctx context.Context, c *app.RequestContext) {
+		claims, ok := g.currentUser(ctx, c)
+		if !ok {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		if !checkAPICSRF(c) {
+			return
+		}
+		user, ok := g.localUser(ctx, c, claims)
+		if !ok {
+			return
+		}
+		g.removeAccessToken(ctx, c, user, c.Param("id"))
+	})
+
+	if g.cfg.AdminToken == "" {
+		return
+	}
+
+	// 管理接口：查看和吊销任意用户的令牌
+	e.GET("/authgate/admin/users/:username/tokens", allowMiddleware, func(ctx context.Context, c *app.RequestContext) {
+		user, ok := g.adminUser(ctx, c)
+		if !ok {
+			return
+		}
+		c.JSON(http.StatusOK, utils.H{"tokens": accessTokenInfos(user.AccessTokens)})
+	})
+
+	e.DELETE("/authgate/admin/users/:username/tokens/:id", allowMiddleware, func(ctx context.Context, c *app.RequestContext) {
+		user, ok := g.adminUser(ctx, c)
+		if !ok {
+			return
+		}
+		g.removeAccessToken(ctx, c, user, c.Param("id"))
+	})
+}
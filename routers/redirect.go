@@ -0,0 +1,128 @@
+package routers
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/cloudwego/hertz/pkg/app"
+)
+
+// errTargetNotAllowed 表示登录后返回的站点不是已配置的后端，也不在白名单中
+var errTargetNotAllowed = errors.New("redirect target is not allowed")
+
+// redirectPattern 是一条跳转白名单规则，scheme 为空时允许 http 和 https，
+// host 以 "*." 开头时匹配任意子域名（不含该域名本身）
+type redirectPattern struct {
+	scheme string
+	host   string
+}
+
+// parseRedirectPatterns 解析 redirect_allowlist，如 "app.example.com"、"*.example.com"、"https://*.example.com"
+func parseRedirectPatterns(allowlist []string) ([]redirectPattern, error) {
+	patterns := make([]redirectPattern, 0, len(allowlist))
+	for _, entry := range allowlist {
+		p := redirectPattern{host: strings.ToLower(entry)}
+		if scheme, host, ok := strings.Cut(p.host, "://"); ok {
+			p.scheme, p.host = scheme, host
+		}
+		if p.scheme != "" && p.scheme != "http" && p.scheme != "https" {
+			return nil, fmt.Errorf("invalid redirect_allowlist entry %q: unsupported scheme", entry)
+		}
+		if p.host == "" || p.host == "*." || strings.ContainsAny(p.host, "/?#@") || strings.Contains(strings.TrimPrefix(p.host, "*."), "*") {
+			return nil, fmt.Errorf("invalid redirect_allowlist entry %q", entry)
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns, nil
+}
+
+func (p redirectPattern) match(scheme, host string) bool {
+	if p.scheme != "" && p.scheme != scheme {
+		return false
+	}
+	if suffix, ok := strings.CutPrefix(p.host, "*"); ok {
+		return strings.HasSuffix(host, suffix) && len(host) > len(suffix)
+	}
+	return host == p.host
+}
+
+// parseTarget 解析登录后返回的地址，只接受带站点的绝对地址，路径为空或以单个 "/" 开头
+func parseTarget(target string) (*url.URL, bool) {
+	u, err := url.Parse(target)
+	if err != nil || u.User != nil || u.Host == "" || u.Opaque != "" || u.Fragment != "" {
+		return nil, false
+	}
+	if p := u.EscapedPath(); p != "" && (p[0] != '/' || strings.HasPrefix(p, "//")) {
+		return nil, false
+	}
+	return u, true
+}
+
+// loginTarget 拼接登录后返回的地址，uri 不是以单个 "/" 开头的路径时只返回站点首页
+func loginTarget(proto, host, uri string) string {
+	if !strings.HasPrefix(uri, "/") || strings.HasPrefix(uri, "//") {
+		uri = ""
+	}
+	return proto + "://" + host + uri
+}
+
+// targetOrigin 返回地址中的协议和站点，如 https://app.example.com
+func targetOrigin(u *url.URL) string {
+	return u.Scheme + "://" + u.Host
+}
+
+// targetPath 返回地址中的路径和查询参数，在受保护域名上作为登录完成后的跳转地址
+func targetPath(u *url.URL) string {
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	return path
+}
+
+// allowTarget 判断登录后能否返回 target，只允许 http(s) 协议下的认证域名、已配置的后端域名或白名单中的域名
+func (g *gate) allowTarget(target string) bool {
+	u, ok := parseTarget(target)
+	if !ok {
+		return false
+	}
+	scheme := strings.ToLower(u.Scheme)
+	if scheme != "http" && scheme != "https" {
+		return false
+	}
+	host := strings.ToLower(u.Host)
+	if host == strings.ToLower(g.cfg.AuthHost) {
+		return true
+	}
+	for _, backend := range g.cfg.Backends {
+		if strings.ToLower(backend.Host) == host {
+			return true
+		}
+	}
+	for _, p := range g.redirects {
+		if p.match(scheme, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkRedirect 校验登录页收到的 host 和 state，目标站点不被允许时返回错误页面
+func (g *gate) checkRedirect(c *app.RequestContext, host, state string) bool {
+	if host != "" && !g.allowTarget(host) {
+		g.invalidRedirect(c)
+		return false
+	}
+	if state != "" {
+		if _, err := g.parseState(state); errors.Is(err, errTargetNotAllowed) {
+			g.invalidRedirect(c)
+			return false
+		}
+	}
+	return true
+}
@@ -2,18 +2,27 @@ package routers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
-	"net/url"
-	"time"
 
 	"github.com/cloudwego/hertz/pkg/app"
 	"github.com/cloudwego/hertz/pkg/app/server"
-	"github.com/cloudwego/hertz/pkg/common/utils"
-	"github.com/cloudwego/hertz/pkg/protocol"
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/ipfans/authgate/access"
+	"github.com/ipfans/authgate/authcode"
+	"github.com/ipfans/authgate/authn"
+	"github.com/ipfans/authgate/authz"
+	"github.com/ipfans/authgate/device"
 	"github.com/ipfans/authgate/iterator"
+	"github.com/ipfans/authgate/keyring"
+	"github.com/ipfans/authgate/lockout"
+	"github.com/ipfans/authgate/models"
+	"github.com/ipfans/authgate/oidc"
 	"github.com/ipfans/authgate/proxy"
+	"github.com/ipfans/authgate/session"
+	"github.com/ipfans/authgate/store"
+	"github.com/ipfans/authgate/ui"
+	"github.com/ipfans/authgate/webauth"
 	"github.com/rs/zerolog/log"
 )
 
@@ -24,6 +33,12 @@ type Backend struct {
 	UpStream     []string           `koanf:"upstream"`
 	HealthCheck  proxy.HealthCheck  `koanf:"health_check"`
 	ClientConfig proxy.ClientConfig `koanf:"client"`
+	Access       access.Config      `koanf:"access"`
+	Identity     IdentityConfig     `koanf:"identity"`
+	Require2FA   bool               `koanf:"require_2fa"` // 要求使用 TOTP 或通行密钥登录
+	ClientCert   ClientCertConfig   `koanf:"client_cert"` // 客户端证书认证，需要 AuthGate 直接监听 HTTPS
+	AuthzURL     string             `koanf:"authz_url"`   // 外部授权服务，访问策略通过后再询问
+	Authz        authz.Config       `koanf:"authz"`
 }
 
 type CookieConfig struct {
@@ -44,14 +59,115 @@ type Config struct {
 	Backends   []Backend        `koanf:"backends"`
 	AuthHost   string           `koanf:"auth_host"`
 	SSL        bool             `koanf:"ssl"`
-	JWTSecret  string           `koanf:"jwt_secret"`
+	JWTSecret  string           `koanf:"jwt_secret"` // HS256 共享密钥
+	JWTKeys    keyring.Config   `koanf:"jwt_keys"`   // 非对称签名密钥
 	Cookies    CookieConfig     `koanf:"cookies"`
-	Credential CredentialConfig `koanf:"credential"`
+	Credential CredentialConfig `koanf:"credential"` // 已废弃，请使用 Users
+	Users      []UserConfig     `koanf:"users"`
+	Htpasswd   string           `koanf:"htpasswd"` // htpasswd 文件路径
+	WebAuthn   webauth.Config   `koanf:"webauthn"`
+	Store      store.Config     `koanf:"store"`
+	OIDC       oidc.Config      `koanf:"oidc"`
+	Session    session.Config   `koanf:"session"`
+	AdminToken string           `koanf:"admin_token"` // 管理接口的 Bearer 令牌，为空时不启用
+	Lockout    lockout.Config   `koanf:"lockout"`
+	// TrustedProxies 是可信的前置代理 IP 或 CIDR，只有来自这些地址的 X-Forwarded-For 和 X-Real-IP 才会被采用
+	TrustedProxies []string `koanf:"trusted_proxies"`
+	// VerifyHosts 是认证域名以外提供转发认证接口 /authgate/verify 的域名，如前置代理访问 AuthGate 使用的内部地址
+	VerifyHosts []string `koanf:"verify_hosts"`
+	// RedirectAllowlist 是后端以外允许登录后返回的站点，支持 "*.example.com" 通配子域名
+	RedirectAllowlist []string      `koanf:"redirect_allowlist"`
+	UI                ui.Config     `koanf:"ui"`     // 页面模板、静态资源和品牌设置
+	TLS               TLSConfig     `koanf:"tls"`    // AuthGate 监听 HTTPS 使用的证书
+	Device            device.Config `koanf:"device"` // 命令行工具使用的设备授权（RFC 8628）
+	// Authenticator 是密码登录使用的认证器：local（默认，使用 users、credential 和 htpasswd）或 ldap
+	Authenticator string           `koanf:"authenticator"`
+	LDAP          authn.LDAPConfig `koanf:"ldap"`
+	// Authenticators 是按顺序尝试的认证器链，配置后忽略 authenticator 和 ldap
+	Authenticators []AuthenticatorConfig `koanf:"authenticators"`
 }
 
 func RegisterRoutes(e *server.Hertz, cfg Config) (err error) {
+	users, err := store.New(cfg.Store)
+	if err != nil {
+		return err
+	}
+	e.OnShutdown = append(e.OnShutdown, func(ctx context.Context) {
+		users.Close()
+	})
+	removed, err := seedUsers(context.Background(), users, cfg)
+	if err != nil {
+		return err
+	}
+	sessionDefaults(&cfg)
+	keys, err := keyring.New(cfg.JWTKeys, cfg.JWTSecret)
+	if err != nil {
+		return err
+	}
+	sessions, err := session.New(cfg.Session)
+	if err != nil {
+		return err
+	}
+	if sessions != nil {
+		e.OnShutdown = append(e.OnShutdown, func(ctx context.Context) {
+			sessions.Close()
+		})
+		// 已删除账号在持久化会话存储中的会话同样失效
+		for _, username := range removed {
+			if _, err = sessions.DeleteUser(context.Background(), username); err != nil {
+				return err
+			}
+		}
+	}
+	g := &gate{
+		cfg:         cfg,
+		keys:        keys,
+		users:       users,
+		sessions:    sessions,
+		require2FA:  make(map[string]bool, len(cfg.Backends)),
+		clientCerts: make(map[string]*clientCertVerifier),
+		authz:       make(map[string]*authz.Client),
+		lockout:     lockout.New(cfg.Lockout, lockout.NewMemory()),
+		codes:       authcode.New(authcode.NewMemory(), authcode.DefaultTTL),
+	}
+	if g.trustedProxies, err = parseTrustedProxies(cfg.TrustedProxies); err != nil {
+		return err
+	}
+	g.clientIP = clientIPFunc(g.trustedProxies)
+	if g.redirects, err = parseRedirectPatterns(cfg.RedirectAllowlist); err != nil {
+		return err
+	}
+	if g.ui, err = ui.New(cfg.UI); err != nil {
+		return err
+	}
+	if g.authenticator, err = newAuthenticator(context.Background(), cfg, users); err != nil {
+		return err
+	}
+	if closer, ok := g.authenticator.(interface{ Close() }); ok {
+		e.OnShutdown = append(e.OnShutdown, func(ctx context.Context) {
+			closer.Close()
+		})
+	}
+	e.SetClientIPFunc(g.clientIP)
+
 	backends := make(map[string]iterator.Iterator, len(cfg.Backends))
+	policies := make(map[string]*access.Policy, len(cfg.Backends))
+	identities := make(map[string]IdentityConfig, len(cfg.Backends))
 	for _, backend := range cfg.Backends {
+		policy, err := access.New(backend.Access)
+		if err != nil {
+			return fmt.Errorf("backend %s: %w", backend.Host, err)
+		}
+		policies[backend.Host] = policy
+		identities[backend.Host] = identityDefaults(backend.Identity)
+		g.require2FA[backend.Host] = backend.Require2FA
+		if g.clientCerts[backend.Host], err = newClientCertVerifier(backend.ClientCert); err != nil {
+			return fmt.Errorf("backend %s: %w", backend.Host, err)
+		}
+		if g.authz[backend.Host], err = authz.New(backend.AuthzURL, backend.Authz); err != nil {
+			return fmt.Errorf("backend %s: %w", backend.Host, err)
+		}
+
 		proxies := make([]*proxy.Proxy, 0, len(backend.UpStream))
 		for _, upstream := range backend.UpStream {
 			p, err := proxy.New(upstream, backend.HealthCheck, backend.ClientConfig)
@@ -88,7 +204,8 @@ func RegisterRoutes(e *server.Hertz, cfg Config) (err error) {
 	}
 
 	proxyFunc := func(ctx context.Context, c *app.RequestContext) {
-		rp, ok := backends[string(c.Host())]
+		host := string(c.Host())
+		rp, ok := backends[host]
 		if !ok {
 			c.Header("X-Error", "No backend found")
 			c.Status(http.StatusNotFound)
@@ -101,121 +218,196 @@ func RegisterRoutes(e *server.Hertz, cfg Config) (err error) {
 			c.String(http.StatusServiceUnavailable, "Internal Server Error")
 			return
 		}
+		value, _ := c.Get(claimsKey)
+		claims, _ := value.(*Claims)
+		g.setIdentity(c, identities[host], host, claims)
+		// 转发使用的协议由 upstream 地址决定，与客户端连接是否为 HTTPS 无关
+		c.Request.SetIsTLS(false)
 		proxy.ServeHTTP(ctx, c)
 	}
 
-	allowMiddleware := func(ctx context.Context, c *app.RequestContext) {
-		host := string(c.Host())
-		if host != cfg.AuthHost {
-			proxyFunc(ctx, c)
-			return
+	// authorize 校验登录状态和访问策略，未通过时写好响应并返回 false
+	authorize := func(ctx context.Context, c *app.RequestContext) bool {
+		policy := policies[requestHost(c)]
+		method := string(c.Method())
+		path := string(c.Path())
+		if policy != nil && policy.IsPublic(method, path) {
+			return true
 		}
-		c.Next(ctx)
-	}
 
-	authCheckMiddleware := func(ctx context.Context, c *app.RequestContext) {
-		token := string(c.Cookie(cfg.Cookies.Name))
-		prefix := "http://"
-		if cfg.SSL {
-			prefix = "https://"
-		}
-		query := url.Values{}
-		targetHost := string(c.Host())
-		proto := string(c.GetHeader("X-Forwarded-Proto"))
-		if proto == "" {
-			proto = "http"
-		}
-		targetHost = proto + "://" + targetHost
-		query.Add("host", targetHost)
-		host := prefix + cfg.AuthHost + "/authgate/login?" + query.Encode()
-		if token == "" {
-			c.Redirect(http.StatusTemporaryRedirect, []byte(host))
-			return
+		// 客户端证书优先，未提供或未通过校验时使用令牌和 Cookie
+		machine := false
+		claims, ok := g.certUser(c, requestHost(c))
+		if !ok {
+			claims, ok, machine = g.requestUser(ctx, c, requestHost(c))
+		}
+		if !ok && machine {
+			unauthorized(c)
+			return false
+		}
+		if !ok {
+			proto := string(c.GetHeader("X-Forwarded-Proto"))
+			if proto == "" {
+				proto = "http"
+			}
+			// 带上路径和查询参数，登录后回到原来的页面
+			target := loginTarget(proto, requestHost(c), string(c.Request.URI().RequestURI()))
+			state, err := g.newState(c, target)
+			if errors.Is(err, errTargetNotAllowed) {
+				g.invalidRedirect(c)
+				return false
+			}
+			if err != nil {
+				c.AbortWithStatus(http.StatusInternalServerError)
+				return false
+			}
+			c.Redirect(http.StatusTemporaryRedirect, []byte(g.loginURL(target, state)))
+			return false
 		}
+		c.Set(claimsKey, claims)
 
-		_, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
-			return []byte(cfg.JWTSecret), nil
-		})
-		if err != nil {
-			c.Redirect(http.StatusTemporaryRedirect, []byte(host))
+		if g.require2FA[requestHost(c)] && !claims.secondFactor() {
+			g.mfaRequired(c)
+			return false
+		}
+		if policy != nil && !policy.Allow(access.Request{
+			Username: claims.Username,
+			Groups:   claims.Groups,
+			Method:   method,
+			Path:     path,
+			Headers:  requestHeader(c),
+			SourceIP: g.clientIP(c),
+		}) {
+			g.forbidden(c)
+			return false
+		}
+		if !g.externalAllow(ctx, c, requestHost(c), method, path, claims) {
+			g.forbidden(c)
+			return false
+		}
+		return true
+	}
+
+	// 非认证域名的请求一律经过登录和权限校验后转发
+	allowMiddleware := func(ctx context.Context, c *app.RequestContext) {
+		if requestHost(c) != cfg.AuthHost {
+			if authorize(ctx, c) {
+				proxyFunc(ctx, c)
+			}
+			c.Abort()
 			return
 		}
 		c.Next(ctx)
 	}
 
-	e.NoRoute(authCheckMiddleware, func(ctx context.Context, c *app.RequestContext) {
-		host := string(c.GetRequest().Header.Get("Host"))
-		if host == cfg.AuthHost {
+	e.NoRoute(func(ctx context.Context, c *app.RequestContext) {
+		if requestHost(c) == cfg.AuthHost {
 			c.AbortWithStatus(http.StatusNotFound)
 			return
 		}
-
-		token := string(c.Cookie(cfg.Cookies.Name))
-		prefix := "http://"
-		if cfg.SSL {
-			prefix = "https://"
-		}
-		query := url.Values{}
-		targetHost := host
-		proto := string(c.GetHeader("X-Forwarded-Proto"))
-		if proto == "" {
-			proto = "http"
-		}
-		targetHost = proto + "://" + targetHost
-		query.Add("host", targetHost)
-		host = prefix + cfg.AuthHost + "/authgate/login?" + query.Encode()
-		if token == "" {
-			c.Redirect(http.StatusTemporaryRedirect, []byte(host))
-			return
+		if authorize(ctx, c) {
+			proxyFunc(ctx, c)
 		}
+	})
 
-		_, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
-			return []byte(cfg.JWTSecret), nil
-		})
-		if err != nil {
-			c.Redirect(http.StatusTemporaryRedirect, []byte(host))
-			return
-		}
+	if err = registerVerifyRoutes(e, g, policies); err != nil {
+		return err
+	}
+	registerStaticRoutes(e, g)
 
-		proxyFunc(ctx, c)
+	e.GET("/", allowMiddleware, func(ctx context.Context, c *app.RequestContext) {
+		c.String(http.StatusOK, "AuthGate is running...")
 	})
 
-	e.GET("/", func(ctx context.Context, c *app.RequestContext) {
-		c.String(http.StatusOK, "AuthGate is running...")
-	}, allowMiddleware)
+	// JWKS 公钥集合，供上游服务校验 AuthGate 签发的 JWT
+	e.GET("/.well-known/jwks.json", allowMiddleware, func(ctx context.Context, c *app.RequestContext) {
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(http.StatusOK, g.keys.JWKS())
+	})
 
-	e.GET("/login", allowMiddleware, func(ctx context.Context, c *app.RequestContext) {
-		host := c.Query("host")
-		c.HTML(http.StatusOK, "login.html", utils.H{
-			"host": host,
+	loginPage := func(ctx context.Context, c *app.RequestContext) {
+		if !g.checkRedirect(c, c.Query("host"), c.Query("state")) {
+			return
+		}
+		csrf, err := g.csrfToken(c)
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		g.loginPage(c, loginForm{
+			Host:  c.Query("host"),
+			State: c.Query("state"),
+			CSRF:  csrf,
 		})
-	})
+	}
+	// 受保护域名跳转到 /authgate/login，/login 保留兼容
+	e.GET("/authgate/login", allowMiddleware, loginPage)
+	e.GET("/login", allowMiddleware, loginPage)
 
 	e.POST("/login", allowMiddleware, func(ctx context.Context, c *app.RequestContext) {
-		var host, token string
-
-		host = c.PostForm("host")
+		if !g.checkCSRF(c) {
+			return
+		}
+		state := c.PostForm("state")
+		if !g.checkRedirect(c, c.PostForm("host"), state) {
+			return
+		}
 		username := c.PostForm("username")
 		password := c.PostForm("password")
-		if username != cfg.Credential.Username || password != cfg.Credential.Password {
-			c.AbortWithStatus(http.StatusUnauthorized)
+		attempt, ok := g.beginAttempt(ctx, c, username)
+		if !ok {
+			return
+		}
+		identity, err := g.authenticator.Authenticate(ctx, username, password)
+		switch {
+		case errors.Is(err, authn.ErrUnknownUser):
+			g.loginFailed(ctx, c, attempt, username, "unknown_user")
+			return
+		case errors.Is(err, authn.ErrInvalidCredentials):
+			g.loginFailed(ctx, c, attempt, username, "bad_password")
+			return
+		case err != nil:
+			releaseAttempt(ctx, attempt)
+			log.Error().Err(err).Str("username", username).Msg("Authenticate failed")
+			c.AbortWithStatus(http.StatusInternalServerError)
 			return
 		}
 
-		tokenData := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-			"username": username,
-			"exp":      time.Now().Add(time.Hour * 24).Unix(),
-		})
-		var err error
-		token, err = tokenData.SignedString([]byte(cfg.JWTSecret))
+		// 用户存储中启用了 TOTP 的用户需要先完成第二步验证
+		user, err := users.GetUser(ctx, identity.Username)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			releaseAttempt(ctx, attempt)
+			log.Error().Err(err).Str("username", identity.Username).Msg("Load user failed")
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		if user != nil && user.TOTPEnabled() {
+			// 密码正确，第二步验证单独计数
+			releaseAttempt(ctx, attempt)
+			g.beginSecondFactor(c, user, state)
+			return
+		}
+
+		token, err := g.newToken(ctx, &models.User{
+			Username: identity.Username,
+			Email:    identity.Email,
+			Groups:   identity.Groups,
+		}, amrPassword)
 		if err != nil {
+			releaseAttempt(ctx, attempt)
 			c.AbortWithStatus(http.StatusInternalServerError)
 			return
 		}
+		g.loginSucceeded(ctx, c, attempt, identity.Username, amrPassword)
 
-		query := url.Values{}
-		query.Add("token", token)
-		c.Redirect(http.StatusTemporaryRedirect, []byte(host+"/authgate/login/finish?"+query.Encode()))
+		// 认证域名自身也保存登录状态，供 WebAuthn 注册等操作使用
+		g.setTokenCookie(c, token)
+		redirect, err := g.loginRedirect(ctx, token, state)
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		c.Redirect(http.StatusTemporaryRedirect, []byte(redirect))
 	})
 
 	e.GET("/authgate/login/finish", func(ctx context.Context, c *app.RequestContext) {
@@ -224,36 +416,38 @@ func RegisterRoutes(e *server.Hertz, cfg Config) (err error) {
 			c.AbortWithStatus(http.StatusNotFound)
 			return
 		}
-		token := c.Query("token")
-		if token == "" {
+		code := c.Query("code")
+		if code == "" {
 			c.AbortWithStatus(http.StatusBadRequest)
 			return
 		}
-		_, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
-			// Don't forget to validate the alg is what you expect:
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-
-			// hmacSampleSecret is a []byte containing your secret, e.g. []byte("my_secret_key")
-			return []byte(cfg.JWTSecret), nil
-		})
+		// 只接受由当前浏览器发起的登录，防止攻击者把受害者登录到攻击者的账户
+		path, ok := g.checkState(c, c.Query("state"))
+		if !ok {
+			g.errorPage(c, http.StatusBadRequest, "error.invalid_state")
+			return
+		}
+		// 授权码只能在签发时绑定的站点上兑换一次，JWT 不会出现在地址栏和日志中
+		token, err := g.codes.Redeem(ctx, code, requestHost(c))
 		if err != nil {
+			g.errorPage(c, http.StatusBadRequest, "error.invalid_code")
+			return
+		}
+		if _, err := g.parseToken(ctx, token); err != nil {
 			c.AbortWithStatus(http.StatusUnauthorized)
 			return
 		}
-		c.SetCookie(
-			cfg.Cookies.Name,
-			token,
-			cfg.Cookies.MaxAge,
-			cfg.Cookies.Path,
-			cfg.Cookies.Domain,
-			protocol.CookieSameSiteDefaultMode,
-			cfg.Cookies.Secure,
-			cfg.Cookies.HttpOnly,
-		)
-		c.Redirect(http.StatusTemporaryRedirect, []byte("/"))
+		g.clearStateCookie(c)
+		g.setTokenCookie(c, token)
+		c.Redirect(http.StatusTemporaryRedirect, []byte(path))
 	})
 
-	return
+	registerSessionRoutes(e, g, allowMiddleware)
+	registerAccessTokenRoutes(e, g, allowMiddleware)
+	registerDeviceRoutes(e, g, allowMiddleware)
+	registerTOTPRoutes(e, g, allowMiddleware)
+	if err = registerOIDCRoutes(e, g, allowMiddleware); err != nil {
+		return err
+	}
+	return registerWebAuthnRoutes(e, g, allowMiddleware)
 }
@@ -0,0 +1,132 @@
+package routers
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/app/server"
+	"github.com/cloudwego/hertz/pkg/common/utils"
+	"github.com/rs/zerolog/log"
+)
+
+// sessionDefaults 补全会话有效期的默认值
+func sessionDefaults(cfg *Config) {
+	if cfg.Session.Lifetime <= 0 {
+		cfg.Session.Lifetime = time.Duration(cfg.Cookies.MaxAge) * time.Second
+	}
+	if cfg.Session.Lifetime <= 0 {
+		cfg.Session.Lifetime = 24 * time.Hour
+	}
+	if cfg.Session.RefreshThreshold <= 0 {
+		cfg.Session.RefreshThreshold = cfg.Session.IdleTimeout / 2
+	}
+}
+
+// revokeToken 删除 JWT 对应的服务端会话，JWT 无效时忽略
+func (g *gate) revokeToken(ctx context.Context, token string) {
+	if g.sessions == nil || token == "" {
+		return
+	}
+	claims := &Claims{}
+	if err := g.keys.Parse(token, claims); err != nil || claims.ID == "" {
+		return
+	}
+	if err := g.sessions.Delete(ctx, claims.ID); err != nil {
+		log.Error().Err(err).Str("username", claims.Username).Msg("Revoke session failed")
+	}
+}
+
+// logoutTicketTimeout 是受保护域名注销后跳转到认证域名继续注销的时间限制
+const logoutTicketTimeout = time.Minute
+
+// logout 删除当前会话和 Cookie，受保护域名上注销后跳转到认证域名
+func (g *gate) logout(ctx context.Context, c *app.RequestContext) {
+	token := string(c.Cookie(g.cfg.Cookies.Name))
+	g.revokeToken(ctx, token)
+	g.clearTokenCookie(c)
+	if requestHost(c) == g.cfg.AuthHost {
+		g.loggedOut(c)
+		return
+	}
+	target := g.authURL("/authgate/logout")
+	claims := &Claims{}
+	if err := g.keys.Parse(token, claims); err == nil && claims.ID != "" {
+		ticket, err := g.newTicket(&ticketClaims{Purpose: ticketLogout, Nonce: claims.ID}, claims.Username, logoutTicketTimeout)
+		if err != nil {
+			log.Error().Err(err).Msg("Issue logout ticket failed")
+		} else {
+			target += "?ticket=" + url.QueryEscape(ticket)
+		}
+	}
+	c.Redirect(http.StatusFound, []byte(target))
+}
+
+// sameSessionTicket 判断注销票据是否属于认证域名 Cookie 中的会话，
+// 其他用户的票据不能用来注销当前用户
+func (g *gate) sameSessionTicket(c *app.RequestContext, ticket string) bool {
+	if ticket == "" {
+		return false
+	}
+	t, err := g.parseTicket(ticketLogout, ticket)
+	if err != nil {
+		return false
+	}
+	claims := &Claims{}
+	if err := g.keys.Parse(string(c.Cookie(g.cfg.Cookies.Name)), claims); err != nil {
+		return false
+	}
+	return claims.ID != "" && subtle.ConstantTimeCompare([]byte(claims.ID), []byte(t.Nonce)) == 1
+}
+
+func registerSessionRoutes(e *server.Hertz, g *gate, allowMiddleware app.HandlerFunc) {
+	// 注销在认证域名和受保护域名上都可用。GET 只显示确认页面，避免其他页面通过图片等方式注销用户；
+	// 受保护域名注销后带着票据跳转到认证域名，继续删除那里同一会话的 Cookie
+	e.GET("/authgate/logout", func(ctx context.Context, c *app.RequestContext) {
+		if requestHost(c) == g.cfg.AuthHost && g.sameSessionTicket(c, c.Query("ticket")) {
+			g.logout(ctx, c)
+			return
+		}
+		g.logoutPage(c)
+	})
+	e.POST("/authgate/logout", func(ctx context.Context, c *app.RequestContext) {
+		if !g.checkCSRF(c) {
+			return
+		}
+		g.logout(ctx, c)
+	})
+
+	if g.sessions == nil || g.cfg.AdminToken == "" {
+		return
+	}
+
+	// 管理接口：吊销用户的全部会话
+	e.DELETE("/authgate/admin/users/:username/sessions", allowMiddleware, func(ctx context.Context, c *app.RequestContext) {
+		if !g.checkAdmin(c) {
+			return
+		}
+		username := c.Param("username")
+		n, err := g.sessions.DeleteUser(ctx, username)
+		if err != nil {
+			log.Error().Err(err).Str("username", username).Msg("Revoke sessions failed")
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		log.Info().Str("username", username).Int("sessions", n).Msg("Sessions revoked")
+		c.JSON(http.StatusOK, utils.H{"revoked": n})
+	})
+}
+
+// checkAdmin 校验管理接口的 Bearer 令牌，不匹配时返回 401
+func (g *gate) checkAdmin(c *app.RequestContext) bool {
+	token, ok := strings.CutPrefix(string(c.GetHeader("Authorization")), "Bearer ")
+	if !ok || g.cfg.AdminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(g.cfg.AdminToken)) != 1 {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,154 @@
+package routers
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/utils"
+	"github.com/cloudwego/hertz/pkg/protocol"
+	"github.com/ipfans/authgate/utils/random"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// stateCookie 保存在受保护域名上，与登录地址中的 state 绑定
+	stateCookie = "authgate_state"
+	// csrfCookie 保存在认证域名上，与登录表单中的 csrf_token 比对
+	csrfCookie = "authgate_csrf"
+	// stateTimeout 是从跳转到登录页到完成登录的时间限制
+	stateTimeout = 10 * time.Minute
+	// ticketState 是 state 票据的用途
+	ticketState = "state"
+)
+
+// hashNonce 返回 nonce 的哈希，state 中只保存哈希
+func hashNonce(nonce string) string {
+	sum := sha256.Sum256([]byte(nonce))
+	return hex.EncodeToString(sum[:])
+}
+
+// browserNonce 读取或生成浏览器 Cookie 中的随机值，已有 Cookie 时沿用，
+// 这样同时打开多个页面跳转登录也不会互相覆盖
+func browserNonce(c *app.RequestContext, name string) (string, error) {
+	if nonce := string(c.Cookie(name)); len(nonce) >= 16 {
+		return nonce, nil
+	}
+	return random.String(32)
+}
+
+// newState 在受保护域名上写入 state Cookie，并返回签名的 state，target 为登录后返回的完整地址
+func (g *gate) newState(c *app.RequestContext, target string) (string, error) {
+	if !g.allowTarget(target) {
+		return "", errTargetNotAllowed
+	}
+	nonce, err := browserNonce(c, stateCookie)
+	if err != nil {
+		return "", err
+	}
+	// 登录完成时从认证域名跨站跳转回来，需要 Lax 才能带上 Cookie
+	c.SetCookie(stateCookie, nonce, int(stateTimeout.Seconds()), "/", "",
+		protocol.CookieSameSiteLaxMode, g.cfg.Cookies.Secure, true)
+	return g.newTicket(&ticketClaims{Purpose: ticketState, Nonce: hashNonce(nonce)}, target, stateTimeout)
+}
+
+// parseState 校验 state 的签名和有效期，返回其中登录后返回的地址
+func (g *gate) parseState(state string) (string, error) {
+	claims, err := g.parseTicket(ticketState, state)
+	if err != nil {
+		return "", err
+	}
+	// 配置变更后不再允许的站点同样拒绝
+	if !g.allowTarget(claims.Subject) {
+		return "", errTargetNotAllowed
+	}
+	return claims.Subject, nil
+}
+
+// checkState 在受保护域名上校验 state 与 Cookie 是否属于同一浏览器，且站点与当前域名一致，
+// 返回登录前访问的路径
+func (g *gate) checkState(c *app.RequestContext, state string) (string, bool) {
+	claims, err := g.parseTicket(ticketState, state)
+	if err != nil || !g.allowTarget(claims.Subject) {
+		return "", false
+	}
+	target, ok := parseTarget(claims.Subject)
+	if !ok || target.Host != requestHost(c) {
+		return "", false
+	}
+	nonce := string(c.Cookie(stateCookie))
+	if nonce == "" || subtle.ConstantTimeCompare([]byte(hashNonce(nonce)), []byte(claims.Nonce)) != 1 {
+		return "", false
+	}
+	return targetPath(target), true
+}
+
+// clearStateCookie 在登录完成后删除 state Cookie
+func (g *gate) clearStateCookie(c *app.RequestContext) {
+	c.SetCookie(stateCookie, "", -1, "/", "", protocol.CookieSameSiteLaxMode, g.cfg.Cookies.Secure, true)
+}
+
+// csrfToken 在认证域名上写入 CSRF Cookie，并返回需要放入登录表单的值
+func (g *gate) csrfToken(c *app.RequestContext) (string, error) {
+	token, err := browserNonce(c, csrfCookie)
+	if err != nil {
+		return "", err
+	}
+	c.SetCookie(csrfCookie, token, 0, "/", "", protocol.CookieSameSiteStrictMode, g.cfg.Cookies.Secure, true)
+	return token, nil
+}
+
+// validCSRF 判断 token 与 CSRF Cookie 一致
+func validCSRF(c *app.RequestContext, token []byte) bool {
+	cookie := c.Cookie(csrfCookie)
+	return len(cookie) > 0 && subtle.ConstantTimeCompare(cookie, token) == 1
+}
+
+// checkCSRF 校验表单中的 csrf_token 与 Cookie 一致，不一致时返回 403
+func (g *gate) checkCSRF(c *app.RequestContext) bool {
+	if !validCSRF(c, []byte(c.PostForm("csrf_token"))) {
+		g.errorPage(c, http.StatusForbidden, "error.invalid_csrf")
+		return false
+	}
+	return true
+}
+
+// checkAPICSRF 校验使用登录 Cookie 调用的 JSON 接口的 CSRF 令牌，
+// 令牌放在 X-CSRF-Token 请求头或 csrf_token 表单字段中，不一致时返回 403
+func checkAPICSRF(c *app.RequestContext) bool {
+	token := c.GetHeader("X-CSRF-Token")
+	if len(token) == 0 {
+		token = []byte(c.PostForm("csrf_token"))
+	}
+	if !validCSRF(c, token) {
+		c.JSON(http.StatusForbidden, utils.H{"error": "invalid_csrf"})
+		return false
+	}
+	return true
+}
+
+// loginRedirect 返回登录成功后的跳转地址，有 state 时为目标站点签发兑换 JWT 的一次性授权码，否则留在认证域名
+func (g *gate) loginRedirect(ctx context.Context, token, state string) (string, error) {
+	target, err := g.parseState(state)
+	if state == "" || err != nil {
+		return g.authURL("/"), nil
+	}
+	u, ok := parseTarget(target)
+	if !ok {
+		return g.authURL("/"), nil
+	}
+	// 认证域名上的页面（如设备授权）已经写入了登录 Cookie，直接返回
+	if u.Host == g.cfg.AuthHost {
+		return target, nil
+	}
+	code, err := g.codes.Issue(ctx, token, u.Host)
+	if err != nil {
+		log.Error().Err(err).Msg("Issue login code failed")
+		return "", err
+	}
+	return finishURL(targetOrigin(u), code, state), nil
+}
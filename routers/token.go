@@ -0,0 +1,254 @@
+package routers
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ipfans/authgate/authcode"
+	"github.com/ipfans/authgate/authn"
+	"github.com/ipfans/authgate/authz"
+	"github.com/ipfans/authgate/keyring"
+	"github.com/ipfans/authgate/lockout"
+	"github.com/ipfans/authgate/models"
+	"github.com/ipfans/authgate/session"
+	"github.com/ipfans/authgate/store"
+	"github.com/ipfans/authgate/ui"
+	"github.com/ipfans/authgate/utils/defaults"
+	"github.com/ipfans/authgate/utils/random"
+	"github.com/rs/zerolog/log"
+)
+
+// claimsKey 是请求上下文中保存当前用户 Claims 的键
+const claimsKey = "authgate.claims"
+
+// RFC 8176 定义的认证方式
+const (
+	amrPassword    = "pwd"
+	amrOTP         = "otp"
+	amrHardwareKey = "hwk"
+	amrSoftwareKey = "swk" // 客户端证书
+)
+
+// Claims 是 AuthGate 签发的 JWT 内容
+type Claims struct {
+	Username string   `json:"username"`
+	Email    string   `json:"email,omitempty"`
+	Groups   []string `json:"groups,omitempty"`
+	// AuthTime 是用户实际登录的时间，续期时保持不变
+	AuthTime *jwt.NumericDate `json:"auth_time,omitempty"`
+	// AMR 是本次登录使用的认证方式，取值参考 RFC 8176，如 pwd、otp、hwk
+	AMR []string `json:"amr,omitempty"`
+	// IDP 是外部身份提供方的 issuer，非空时会话不对应用户存储中的本地账号
+	IDP string `json:"idp,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// gate 保存各路由共享的配置和组件
+type gate struct {
+	cfg      Config
+	keys     *keyring.Keyring
+	users    store.UserStore
+	sessions session.Store // 未启用服务端会话时为 nil
+
+	require2FA     map[string]bool // 要求两步验证的后端域名
+	lockout        *lockout.Tracker
+	clientIP       app.ClientIP                   // 按可信代理配置解析客户端 IP
+	trustedProxies []*net.IPNet                   // 可信的前置代理地址
+	codes          *authcode.Issuer               // 登录交接使用的一次性授权码
+	redirects      []redirectPattern              // 后端以外允许登录后返回的站点
+	clientCerts    map[string]*clientCertVerifier // 启用客户端证书认证的后端域名
+	authz          map[string]*authz.Client       // 配置了外部授权服务的后端域名
+	ui             *ui.UI
+	authenticator  authn.Authenticator // 密码登录使用的认证器
+}
+
+// authURL 返回认证域名上的地址
+func (g *gate) authURL(path string) string {
+	prefix := "http://"
+	if g.cfg.SSL {
+		prefix = "https://"
+	}
+	return prefix + g.cfg.AuthHost + path
+}
+
+// newToken 为用户签发 JWT，amr 为本次登录使用的认证方式，启用服务端会话时同时保存会话
+func (g *gate) newToken(ctx context.Context, user *models.User, amr ...string) (string, error) {
+	return g.issueToken(ctx, &Claims{
+		Username: user.Username,
+		Email:    user.Email,
+		Groups:   user.Groups,
+		AMR:      amr,
+	})
+}
+
+// issueToken 为一次新的登录签发令牌，设置会话 ID 和登录时间
+func (g *gate) issueToken(ctx context.Context, claims *Claims) (string, error) {
+	id, err := random.String(16)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims.ID = id
+	claims.AuthTime = jwt.NewNumericDate(now)
+	return g.signToken(ctx, claims, now)
+}
+
+// expiresAt 返回在 now 时签发的令牌的过期时间，不超过登录时间加上最长有效期
+func (g *gate) expiresAt(claims *Claims, now time.Time) time.Time {
+	expires := claims.AuthTime.Add(g.cfg.Session.Lifetime)
+	if idle := g.cfg.Session.IdleTimeout; idle > 0 && now.Add(idle).Before(expires) {
+		expires = now.Add(idle)
+	}
+	return expires
+}
+
+// signToken 设置签发和过期时间后签名，启用服务端会话时同时保存会话
+func (g *gate) signToken(ctx context.Context, claims *Claims, now time.Time) (string, error) {
+	expires := g.expiresAt(claims, now)
+	claims.IssuedAt = jwt.NewNumericDate(now)
+	claims.ExpiresAt = jwt.NewNumericDate(expires)
+	if g.sessions != nil {
+		err := g.sessions.Create(ctx, &session.Session{
+			ID:        claims.ID,
+			Username:  claims.Username,
+			CreatedAt: claims.AuthTime.Time,
+			ExpiresAt: expires,
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	return g.keys.Sign(claims)
+}
+
+// refreshToken 在令牌签发超过续期阈值时重新签发，会话 ID 和登录时间保持不变，
+// 不需要续期时返回空字符串
+func (g *gate) refreshToken(ctx context.Context, claims *Claims) (string, error) {
+	threshold := g.cfg.Session.RefreshThreshold
+	if threshold <= 0 || claims.AuthTime == nil || claims.IssuedAt == nil || claims.ExpiresAt == nil {
+		return "", nil
+	}
+	now := time.Now()
+	if now.Sub(claims.IssuedAt.Time) < threshold || !g.expiresAt(claims, now).After(claims.ExpiresAt.Time) {
+		return "", nil
+	}
+	refreshed := *claims
+	return g.signToken(ctx, &refreshed, now)
+}
+
+// secondFactor 判断本次登录是否使用了第二因素，TOTP 和通行密钥均视为满足
+func (claims *Claims) secondFactor() bool {
+	for _, m := range claims.AMR {
+		if m == amrOTP || m == amrHardwareKey {
+			return true
+		}
+	}
+	return false
+}
+
+// parseToken 校验 JWT 并返回其中的用户信息，启用服务端会话时已注销或吊销的 JWT 视为无效
+func (g *gate) parseToken(ctx context.Context, token string) (*Claims, error) {
+	claims := &Claims{}
+	if err := g.keys.Parse(token, claims); err != nil {
+		return nil, err
+	}
+	if claims.Username == "" {
+		return nil, errors.New("token has no username")
+	}
+	if g.sessions == nil {
+		return claims, nil
+	}
+	if claims.ID == "" {
+		return nil, session.ErrNotFound
+	}
+	sess, err := g.sessions.Get(ctx, claims.ID)
+	if err != nil {
+		if !errors.Is(err, session.ErrNotFound) {
+			log.Error().Err(err).Msg("Load session failed")
+		}
+		return nil, err
+	}
+	if sess.Username != claims.Username {
+		return nil, session.ErrNotFound
+	}
+	return claims, nil
+}
+
+// currentUser 从 Cookie 中读取当前登录的用户
+func (g *gate) currentUser(ctx context.Context, c *app.RequestContext) (*Claims, bool) {
+	token := string(c.Cookie(g.cfg.Cookies.Name))
+	if token == "" {
+		return nil, false
+	}
+	claims, err := g.parseToken(ctx, token)
+	if err != nil {
+		return nil, false
+	}
+	// 活跃用户的令牌在有效期内自动续期，避免长时间打开的页面中途跳转到登录页
+	refreshed, err := g.refreshToken(ctx, claims)
+	if err != nil {
+		log.Error().Err(err).Str("username", claims.Username).Msg("Refresh token failed")
+	} else if refreshed != "" {
+		g.setTokenCookie(c, refreshed)
+	}
+	return claims, true
+}
+
+// setTokenCookie 把 JWT 写入当前域名的 Cookie，Cookie 有效期与会话最长有效期一致
+func (g *gate) setTokenCookie(c *app.RequestContext, token string) {
+	c.SetCookie(
+		g.cfg.Cookies.Name,
+		token,
+		int(g.cfg.Session.Lifetime.Seconds()),
+		g.cfg.Cookies.Path,
+		g.cfg.Cookies.Domain,
+		protocol.CookieSameSiteDefaultMode,
+		g.cfg.Cookies.Secure,
+		g.cfg.Cookies.HttpOnly,
+	)
+}
+
+// clearTokenCookie 删除当前域名的登录 Cookie
+func (g *gate) clearTokenCookie(c *app.RequestContext) {
+	cookie := protocol.AcquireCookie()
+	defer protocol.ReleaseCookie(cookie)
+	cookie.SetKey(g.cfg.Cookies.Name)
+	cookie.SetPath(defaults.Get(g.cfg.Cookies.Path, "/"))
+	cookie.SetDomain(g.cfg.Cookies.Domain)
+	cookie.SetExpire(protocol.CookieExpireDelete)
+	cookie.SetSecure(g.cfg.Cookies.Secure)
+	cookie.SetHTTPOnly(g.cfg.Cookies.HttpOnly)
+	c.Response.Header.SetCookie(cookie)
+}
+
+// loginURL 返回认证域名上的登录地址，target 为登录后返回的地址。
+// host 参数只包含站点，如 https://app.example.com，完整地址由签名的 state 携带
+func (g *gate) loginURL(target, state string) string {
+	host := target
+	if u, ok := parseTarget(target); ok {
+		host = targetOrigin(u)
+	}
+	query := url.Values{}
+	query.Add("host", host)
+	query.Add("state", state)
+	return g.authURL("/authgate/login?" + query.Encode())
+}
+
+// requestHost 返回请求的 Host 头
+func requestHost(c *app.RequestContext) string {
+	if host := c.Request.Header.Host(); len(host) > 0 {
+		return string(host)
+	}
+	return string(c.Host())
+}
+
+// finishURL 返回目标站点的登录完成地址，code 是兑换 JWT 的一次性授权码，state 用于确认登录由同一浏览器发起
+func finishURL(host, code, state string) string {
+	return host + "/authgate/login/finish?code=" + url.QueryEscape(code) + "&state=" + url.QueryEscape(state)
+}
@@ -0,0 +1,278 @@
+package routers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/app/server"
+	"github.com/cloudwego/hertz/pkg/common/utils"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ipfans/authgate/models"
+	"github.com/ipfans/authgate/store"
+	"github.com/ipfans/authgate/totp"
+	"github.com/rs/zerolog/log"
+)
+
+// 短期票据的用途，防止不同用途的票据混用
+const (
+	ticketMFA        = "mfa"
+	ticketTOTPEnroll = "totp_enroll"
+	ticketLogout     = "logout"
+)
+
+const (
+	// mfaTicketTimeout 是密码验证通过后输入第二因素的时间限制
+	mfaTicketTimeout = 5 * time.Minute
+	// enrollTicketTimeout 是启用 TOTP 时扫码并输入验证码的时间限制
+	enrollTicketTimeout = 10 * time.Minute
+	// totpIssuer 是验证器 App 中显示的服务名称
+	totpIssuer = "AuthGate"
+)
+
+var errInvalidTicket = errors.New("invalid ticket")
+
+// ticketClaims 是登录和启用 TOTP 过程中使用的短期票据，
+// 没有 username 字段，不会被当作登录令牌接受
+type ticketClaims struct {
+	Purpose string `json:"purpose"`
+	State   string `json:"state,omitempty"`
+	Nonce   string `json:"nonce,omitempty"`
+	Secret  string `json:"totp_secret,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// newTicket 签发短期票据
+func (g *gate) newTicket(claims *ticketClaims, username string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims.Subject = username
+	claims.IssuedAt = jwt.NewNumericDate(now)
+	claims.ExpiresAt = jwt.NewNumericDate(now.Add(ttl))
+	return g.keys.Sign(claims)
+}
+
+// parseTicket 校验短期票据及其用途
+func (g *gate) parseTicket(purpose, ticket string) (*ticketClaims, error) {
+	claims := &ticketClaims{}
+	if err := g.keys.Parse(ticket, claims); err != nil {
+		return nil, err
+	}
+	if claims.Purpose != purpose || claims.Subject == "" {
+		return nil, errInvalidTicket
+	}
+	return claims, nil
+}
+
+// verifySecondFactor 校验 TOTP 验证码或恢复码，在存储中原子地保存已使用的时间步或删除已使用的恢复码，
+// 并发提交同一个验证码或恢复码时只有一个请求成功
+func (g *gate) verifySecondFactor(ctx context.Context, user *models.User, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+	if step, ok := totp.Validate(user.TOTPSecret, code, time.Now()); ok {
+		// 同一个验证码只能使用一次
+		err := g.users.UseTOTPStep(ctx, user.Username, user.TOTPSecret, step)
+		if errors.Is(err, store.ErrCodeUsed) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	remaining, err := g.users.UseRecoveryCode(ctx, user.Username, code)
+	if errors.Is(err, store.ErrCodeUsed) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	log.Info().Str("username", user.Username).Int("remaining", remaining).Msg("Recovery code used")
+	return true, nil
+}
+
+// beginSecondFactor 在密码验证通过后返回输入验证码的页面，票据绑定用户和登录的 state
+func (g *gate) beginSecondFactor(c *app.RequestContext, user *models.User, state string) {
+	ticket, err := g.newTicket(&ticketClaims{Purpose: ticketMFA, State: state}, user.Username, mfaTicketTimeout)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	g.totpPrompt(c, http.StatusOK, ticket)
+}
+
+func registerTOTPRoutes(e *server.Hertz, g *gate, allowMiddleware app.HandlerFunc) {
+	// 登录第二步：校验 TOTP 验证码或恢复码后签发登录令牌
+	e.POST("/login/totp", allowMiddleware, func(ctx context.Context, c *app.RequestContext) {
+		ticket := c.PostForm("ticket")
+		claims, err := g.parseTicket(ticketMFA, ticket)
+		if err != nil {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		user, ok := g.loadUser(ctx, c, claims.Subject)
+		if !ok {
+			return
+		}
+		if !user.TOTPEnabled() {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		attempt, ok := g.beginAttempt(ctx, c, user.Username)
+		if !ok {
+			return
+		}
+		ok, err = g.verifySecondFactor(ctx, user, c.PostForm("code"))
+		if err != nil {
+			releaseAttempt(ctx, attempt)
+			log.Error().Err(err).Str("username", user.Username).Msg("Save TOTP state failed")
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			if wait := g.recordFailure(ctx, c, attempt, user.Username, "bad_second_factor"); wait > 0 {
+				g.tooManyAttempts(c, wait)
+				return
+			}
+			g.totpPrompt(c, http.StatusUnauthorized, ticket)
+			return
+		}
+
+		token, err := g.newToken(ctx, user, amrPassword, amrOTP)
+		if err != nil {
+			releaseAttempt(ctx, attempt)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		g.loginSucceeded(ctx, c, attempt, user.Username, amrPassword, amrOTP)
+		g.setTokenCookie(c, token)
+		redirect, err := g.loginRedirect(ctx, token, claims.State)
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		c.Redirect(http.StatusTemporaryRedirect, []byte(redirect))
+	})
+
+	// 为已登录用户生成 TOTP 密钥，返回的票据在确认时提交
+	e.POST("/authgate/totp/enroll/begin", allowMiddleware, func(ctx context.Context, c *app.RequestContext) {
+		claims, ok := g.currentUser(ctx, c)
+		if !ok {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		if !checkAPICSRF(c) {
+			return
+		}
+		user, ok := g.localUser(ctx, c, claims)
+		if !ok {
+			return
+		}
+		if user.TOTPEnabled() {
+			c.AbortWithStatus(http.StatusConflict)
+			return
+		}
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		ticket, err := g.newTicket(&ticketClaims{Purpose: ticketTOTPEnroll, Secret: secret}, user.Username, enrollTicketTimeout)
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		c.JSON(http.StatusOK, utils.H{
+			"secret": secret,
+			"uri":    totp.URI(totpIssuer, user.Username, secret),
+			"ticket": ticket,
+		})
+	})
+
+	// 校验验证器 App 生成的验证码后启用 TOTP，恢复码只在此时返回一次
+	e.POST("/authgate/totp/enroll/finish", allowMiddleware, func(ctx context.Context, c *app.RequestContext) {
+		claims, ok := g.currentUser(ctx, c)
+		if !ok {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		if !checkAPICSRF(c) {
+			return
+		}
+		ticket, err := g.parseTicket(ticketTOTPEnroll, c.PostForm("ticket"))
+		if err != nil || ticket.Subject != claims.Username {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		user, ok := g.localUser(ctx, c, claims)
+		if !ok {
+			return
+		}
+		if user.TOTPEnabled() {
+			c.AbortWithStatus(http.StatusConflict)
+			return
+		}
+		step, ok := totp.Validate(ticket.Secret, strings.TrimSpace(c.PostForm("code")), time.Now())
+		if !ok {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		codes, hashes, err := totp.GenerateRecoveryCodes()
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		if err = g.users.SetTOTP(ctx, user.Username, ticket.Secret, step, hashes); err != nil {
+			log.Error().Err(err).Str("username", user.Username).Msg("Enable TOTP failed")
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		log.Info().Str("username", user.Username).Msg("TOTP enabled")
+		c.JSON(http.StatusOK, utils.H{"recovery_codes": codes})
+	})
+
+	// 停用 TOTP 需要再次提供验证码或恢复码
+	e.POST("/authgate/totp/disable", allowMiddleware, func(ctx context.Context, c *app.RequestContext) {
+		claims, ok := g.currentUser(ctx, c)
+		if !ok {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		if !checkAPICSRF(c) {
+			return
+		}
+		user, ok := g.localUser(ctx, c, claims)
+		if !ok {
+			return
+		}
+		if !user.TOTPEnabled() {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		attempt, ok := g.beginAttempt(ctx, c, user.Username)
+		if !ok {
+			return
+		}
+		ok, err := g.verifySecondFactor(ctx, user, c.PostForm("code"))
+		if err != nil {
+			releaseAttempt(ctx, attempt)
+			log.Error().Err(err).Str("username", user.Username).Msg("Save TOTP state failed")
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			if wait := g.recordFailure(ctx, c, attempt, user.Username, "bad_second_factor"); wait > 0 {
+				g.tooManyAttempts(c, wait)
+				return
+			}
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		releaseAttempt(ctx, attempt)
+		if err = g.users.SetTOTP(ctx, user.Username, "", 0, nil); err != nil {
+			log.Error().Err(err).Str("username", user.Username).Msg("Disable TOTP failed")
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		log.Info().Str("username", user.Username).Msg("TOTP disabled")
+		c.Status(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,182 @@
+package routers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/utils"
+	"github.com/ipfans/authgate/models"
+	"github.com/ipfans/authgate/passwd"
+	"github.com/ipfans/authgate/store"
+	"github.com/ipfans/authgate/utils/defaults"
+	"github.com/rs/zerolog/log"
+)
+
+type UserConfig struct {
+	Username     string   `koanf:"username"`
+	PasswordHash string   `koanf:"password_hash"` // bcrypt 或 argon2id 哈希
+	DisplayName  string   `koanf:"display_name"`
+	Email        string   `koanf:"email"`
+	Groups       []string `koanf:"groups"`
+}
+
+// user 校验配置并转换为用户，password_hash 可以为空（只使用通行密钥登录）
+func (u UserConfig) user() (*models.User, error) {
+	if u.Username == "" {
+		return nil, errors.New("username is required")
+	}
+	if u.PasswordHash != "" {
+		if err := passwd.Validate(u.PasswordHash); err != nil {
+			return nil, fmt.Errorf("user %q: %w", u.Username, err)
+		}
+	}
+	return &models.User{
+		Username:     u.Username,
+		DisplayName:  u.DisplayName,
+		Email:        u.Email,
+		Groups:       u.Groups,
+		PasswordHash: u.PasswordHash,
+	}, nil
+}
+
+// configUsers 汇总 credential、users 和 htpasswd 文件中配置的账号
+func configUsers(cfg Config) ([]*models.User, error) {
+	var users []*models.User
+	index := make(map[string]*models.User)
+	add := func(user *models.User) {
+		if old, ok := index[user.Username]; ok {
+			*old = *user
+			return
+		}
+		index[user.Username] = user
+		users = append(users, user)
+	}
+
+	if cfg.Credential.Username != "" {
+		log.Warn().Str("username", cfg.Credential.Username).Msg("Plaintext credential is deprecated, use users with password_hash instead")
+		hash, err := passwd.Hash(cfg.Credential.Password)
+		if err != nil {
+			return nil, err
+		}
+		add(&models.User{
+			Username:     cfg.Credential.Username,
+			DisplayName:  cfg.Credential.Username,
+			PasswordHash: hash,
+		})
+	}
+
+	for _, u := range cfg.Users {
+		user, err := u.user()
+		if err != nil {
+			return nil, fmt.Errorf("users: %w", err)
+		}
+		add(user)
+	}
+
+	if cfg.Htpasswd != "" {
+		hashes, err := passwd.LoadHtpasswd(cfg.Htpasswd)
+		if err != nil {
+			return nil, err
+		}
+		for username, hash := range hashes {
+			// users 中已配置的账号只补充密码
+			if user, ok := index[username]; ok {
+				if user.PasswordHash == "" {
+					user.PasswordHash = hash
+				}
+				continue
+			}
+			add(&models.User{
+				Username:     username,
+				DisplayName:  username,
+				PasswordHash: hash,
+			})
+		}
+	}
+	return users, nil
+}
+
+// seedUsers 把配置文件中的账号同步到用户存储。用户存储中的账号都来自配置，
+// 配置中已删除的账号同样从存储中删除，避免持久化的密码哈希在重启后仍然可以登录。返回被删除的用户名
+func seedUsers(ctx context.Context, users store.UserStore, cfg Config) ([]string, error) {
+	configured, err := configUsers(cfg)
+	if err != nil {
+		return nil, err
+	}
+	listed := make(map[string]bool, len(configured))
+	for _, u := range configured {
+		listed[u.Username] = true
+		u.DisplayName = defaults.Get(u.DisplayName, u.Username)
+		user, err := users.GetUser(ctx, u.Username)
+		if errors.Is(err, store.ErrNotFound) {
+			if err = users.CreateUser(ctx, u); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		// 保留已注册的通行密钥等数据
+		user.DisplayName = u.DisplayName
+		user.Email = u.Email
+		user.Groups = u.Groups
+		user.PasswordHash = u.PasswordHash
+		if err = users.UpdateUser(ctx, user); err != nil {
+			return nil, err
+		}
+	}
+
+	stored, err := users.ListUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	for _, user := range stored {
+		if listed[user.Username] {
+			continue
+		}
+		if err = users.DeleteUser(ctx, user.Username); err != nil {
+			return nil, err
+		}
+		log.Info().Str("username", user.Username).Msg("User removed from config, deleted from store")
+		removed = append(removed, user.Username)
+	}
+	return removed, nil
+}
+
+// loadUser 读取用户，失败时写好 401 或 500 响应并返回 false
+func (g *gate) loadUser(ctx context.Context, c *app.RequestContext, username string) (*models.User, bool) {
+	user, err := g.users.GetUser(ctx, username)
+	if errors.Is(err, store.ErrNotFound) {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return nil, false
+	}
+	if err != nil {
+		log.Error().Err(err).Str("username", username).Msg("Load user failed")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return nil, false
+	}
+	return user, true
+}
+
+// localUser 读取当前会话对应的本地账号。OIDC 登录的会话，以及 LDAP、webhook 等认证器中
+// 不在用户存储里的用户没有本地账号，不能管理个人访问令牌、TOTP 和通行密钥，返回 403
+func (g *gate) localUser(ctx context.Context, c *app.RequestContext, claims *Claims) (*models.User, bool) {
+	if claims.IDP == "" {
+		user, err := g.users.GetUser(ctx, claims.Username)
+		if err == nil {
+			return user, true
+		}
+		if !errors.Is(err, store.ErrNotFound) {
+			log.Error().Err(err).Str("username", claims.Username).Msg("Load user failed")
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return nil, false
+		}
+	}
+	c.JSON(http.StatusForbidden, utils.H{"error": "local_account_required"})
+	return nil, false
+}
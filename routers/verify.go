@@ -0,0 +1,155 @@
+package routers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/app/server"
+	"github.com/ipfans/authgate/access"
+	"github.com/rs/zerolog/log"
+)
+
+// 转发认证时返回给前置代理的身份信息
+const (
+	headerAuthUser   = "X-Auth-User"
+	headerAuthEmail  = "X-Auth-Email"
+	headerAuthGroups = "X-Auth-Groups"
+)
+
+// forwardedRequest 是前置代理转发认证时的原始请求
+type forwardedRequest struct {
+	Method string
+	Proto  string
+	Host   string
+	URI    string
+}
+
+// parseForwardedRequest 从前置代理的请求头中还原原始请求，缺少原始域名时返回 false。支持以下约定：
+//
+//   - nginx auth_request: X-Original-URL，或 X-Original-URI 加 X-Forwarded-Host/X-Forwarded-Proto
+//   - Traefik forwardAuth 和 Caddy forward_auth: X-Forwarded-Method/Proto/Host/Uri
+func parseForwardedRequest(c *app.RequestContext) (forwardedRequest, bool) {
+	r := forwardedRequest{
+		Method: string(c.GetHeader("X-Forwarded-Method")),
+		Proto:  string(c.GetHeader("X-Forwarded-Proto")),
+		Host:   string(c.GetHeader("X-Forwarded-Host")),
+		URI:    string(c.GetHeader("X-Forwarded-Uri")),
+	}
+	if original := string(c.GetHeader("X-Original-URL")); original != "" {
+		if u, err := url.Parse(original); err == nil && u.Host != "" {
+			r.Proto = u.Scheme
+			r.Host = u.Host
+			r.URI = u.RequestURI()
+		}
+	}
+	if r.URI == "" {
+		r.URI = string(c.GetHeader("X-Original-URI"))
+	}
+	if r.Method == "" {
+		r.Method = string(c.GetHeader("X-Original-Method"))
+	}
+	if r.Host == "" {
+		return r, false
+	}
+	if r.Proto == "" {
+		r.Proto = "http"
+	}
+	if r.URI == "" {
+		r.URI = "/"
+	}
+	if r.Method == "" {
+		r.Method = http.MethodGet
+	}
+	return r, true
+}
+
+func registerVerifyRoutes(e *server.Hertz, g *gate, policies map[string]*access.Policy) error {
+	// 只在认证域名和配置的内部域名上提供，避免通过受保护站点调用
+	hosts := map[string]bool{g.cfg.AuthHost: true}
+	for _, host := range g.cfg.VerifyHosts {
+		if _, ok := policies[host]; ok {
+			return fmt.Errorf("verify_hosts: %s is a backend host", host)
+		}
+		hosts[host] = true
+	}
+
+	// 转发认证接口，供 nginx auth_request、Traefik forwardAuth 和 Caddy forward_auth 使用
+	e.Any("/authgate/verify", func(ctx context.Context, c *app.RequestContext) {
+		if !hosts[requestHost(c)] {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		// 原始请求来自前置代理的请求头，只接受可信代理的调用
+		if !g.fromTrustedProxy(c) {
+			log.Warn().Str("remote", c.RemoteAddr().String()).Msg("Forward auth request from untrusted address")
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		r, ok := parseForwardedRequest(c)
+		if !ok {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		policy := policies[r.Host]
+		path, _, _ := strings.Cut(r.URI, "?")
+		if policy != nil && policy.IsPublic(r.Method, path) {
+			c.Status(http.StatusOK)
+			return
+		}
+
+		claims, ok, machine := g.requestUser(ctx, c, r.Host)
+		if !ok && machine {
+			unauthorized(c)
+			return
+		}
+		if !ok {
+			target := loginTarget(r.Proto, r.Host, r.URI)
+			state, err := g.newState(c, target)
+			if errors.Is(err, errTargetNotAllowed) {
+				g.invalidRedirect(c)
+				return
+			}
+			if err != nil {
+				c.AbortWithStatus(http.StatusInternalServerError)
+				return
+			}
+			login := g.loginURL(target, state)
+			// nginx 通过 auth_request_set 读取 Location，Traefik 和 Caddy 会把响应原样返回给浏览器
+			c.Header("Location", login)
+			c.Data(http.StatusUnauthorized, "text/html; charset=utf-8",
+				[]byte(`<a href="`+html.EscapeString(login)+`">Login required</a>`))
+			return
+		}
+		if g.require2FA[r.Host] && !claims.secondFactor() {
+			g.mfaRequired(c)
+			return
+		}
+		if policy != nil && !policy.Allow(access.Request{
+			Username: claims.Username,
+			Groups:   claims.Groups,
+			Method:   r.Method,
+			Path:     path,
+			Headers:  requestHeader(c),
+			SourceIP: g.clientIP(c),
+		}) {
+			g.forbidden(c)
+			return
+		}
+		if !g.externalAllow(ctx, c, r.Host, r.Method, path, claims) {
+			g.forbidden(c)
+			return
+		}
+
+		c.Header(headerAuthUser, claims.Username)
+		c.Header(headerAuthEmail, claims.Email)
+		c.Header(headerAuthGroups, strings.Join(claims.Groups, ","))
+		c.Status(http.StatusOK)
+	})
+	return nil
+}
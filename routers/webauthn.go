@@ -0,0 +1,245 @@
+package routers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"net/http"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/app/server"
+	"github.com/cloudwego/hertz/pkg/common/utils"
+	"github.com/cloudwego/hertz/pkg/protocol"
+	wprotocol "github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/ipfans/authgate/lockout"
+	"github.com/ipfans/authgate/models"
+	"github.com/ipfans/authgate/store"
+	"github.com/ipfans/authgate/utils/defaults"
+	"github.com/ipfans/authgate/webauth"
+	"github.com/rs/zerolog/log"
+)
+
+// webauthnCookie 保存注册/登录仪式会话 ID 的 Cookie
+const webauthnCookie = "authgate_webauthn"
+
+func registerWebAuthnRoutes(e *server.Hertz, g *gate, allowMiddleware app.HandlerFunc) error {
+	cfg := g.cfg.WebAuthn
+	cfg.Origin = defaults.Get(cfg.Origin, g.authURL(""))
+	wa, err := webauth.Init(cfg)
+	if err != nil {
+		return err
+	}
+	sessions := webauth.NewSessions()
+	decoyKey := make([]byte, 32)
+	if _, err = rand.Read(decoyKey); err != nil {
+		return err
+	}
+
+	// decoyUser 为不存在或没有通行密钥的用户生成固定的虚假凭据，
+	// 使登录挑战与已注册用户的形式相同，避免借此枚举用户名
+	decoyUser := func(username string) *models.User {
+		mac := hmac.New(sha256.New, decoyKey)
+		mac.Write([]byte(username))
+		return &models.User{
+			Username:    username,
+			Credentials: []webauthn.Credential{{ID: mac.Sum(nil)}},
+		}
+	}
+
+	// loginFailed 记录失败的通行密钥登录，达到锁定条件时返回 429，否则返回 401
+	loginFailed := func(ctx context.Context, c *app.RequestContext, attempt *lockout.Reservation, username, reason string) {
+		if wait := g.recordFailure(ctx, c, attempt, username, reason); wait > 0 {
+			g.tooManyAttempts(c, wait)
+			return
+		}
+		c.AbortWithStatus(http.StatusUnauthorized)
+	}
+
+	setSessionCookie := func(c *app.RequestContext, id string) {
+		c.SetCookie(
+			webauthnCookie,
+			id,
+			int(webauth.SessionTimeout.Seconds()),
+			"/authgate/webauthn",
+			"",
+			protocol.CookieSameSiteStrictMode,
+			g.cfg.Cookies.Secure,
+			true,
+		)
+	}
+
+	takeSession := func(c *app.RequestContext) (*webauth.Session, bool) {
+		id := string(c.Cookie(webauthnCookie))
+		if id == "" {
+			return nil, false
+		}
+		// 仪式会话只能使用一次
+		c.SetCookie(webauthnCookie, "", -1, "/authgate/webauthn", "", protocol.CookieSameSiteStrictMode, g.cfg.Cookies.Secure, true)
+		return sessions.Take(id)
+	}
+
+	// 为已登录用户注册新的通行密钥
+	e.POST("/authgate/webauthn/register/begin", allowMiddleware, func(ctx context.Context, c *app.RequestContext) {
+		claims, ok := g.currentUser(ctx, c)
+		if !ok {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		username := claims.Username
+		user, ok := g.localUser(ctx, c, claims)
+		if !ok {
+			return
+		}
+
+		exclusions := make([]wprotocol.CredentialDescriptor, 0, len(user.Credentials))
+		for _, credential := range user.Credentials {
+			exclusions = append(exclusions, credential.Descriptor())
+		}
+		creation, data, err := wa.BeginRegistration(user, webauthn.WithExclusions(exclusions))
+		if err != nil {
+			log.Error().Err(err).Str("username", username).Msg("Begin WebAuthn registration failed")
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		id, err := sessions.Save(username, data)
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		setSessionCookie(c, id)
+		c.JSON(http.StatusOK, creation)
+	})
+
+	e.POST("/authgate/webauthn/register/finish", allowMiddleware, func(ctx context.Context, c *app.RequestContext) {
+		session, ok := takeSession(c)
+		if !ok {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		claims, ok := g.currentUser(ctx, c)
+		if !ok || claims.Username != session.Username {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		username := claims.Username
+		user, ok := g.localUser(ctx, c, claims)
+		if !ok {
+			return
+		}
+
+		parsed, err := wprotocol.ParseCredentialCreationResponseBody(bytes.NewReader(c.Request.Body()))
+		if err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		credential, err := wa.CreateCredential(user, session.Data, parsed)
+		if err != nil {
+			log.Warn().Err(err).Str("username", username).Msg("WebAuthn registration rejected")
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		if err = g.users.AddCredential(ctx, username, *credential); err != nil {
+			log.Error().Err(err).Str("username", username).Msg("Save WebAuthn credential failed")
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		c.JSON(http.StatusOK, utils.H{"status": "ok"})
+	})
+
+	// 使用通行密钥登录，成功后与密码登录一样跳转到目标站点完成登录
+	e.POST("/authgate/webauthn/login/begin", allowMiddleware, func(ctx context.Context, c *app.RequestContext) {
+		username := c.PostForm("username")
+		if username == "" {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		if !g.checkLockout(ctx, c, username) {
+			return
+		}
+		user, err := g.users.GetUser(ctx, username)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			log.Error().Err(err).Str("username", username).Msg("Load user failed")
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		if err != nil || len(user.Credentials) == 0 {
+			user = decoyUser(username)
+		}
+		assertion, data, err := wa.BeginLogin(user)
+		if err != nil {
+			log.Error().Err(err).Str("username", username).Msg("Begin WebAuthn login failed")
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		id, err := sessions.Save(username, data)
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		setSessionCookie(c, id)
+		c.JSON(http.StatusOK, assertion)
+	})
+
+	e.POST("/authgate/webauthn/login/finish", allowMiddleware, func(ctx context.Context, c *app.RequestContext) {
+		state := c.Query("state")
+		if !g.checkRedirect(c, c.Query("host"), state) {
+			return
+		}
+		session, ok := takeSession(c)
+		if !ok {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		parsed, err := wprotocol.ParseCredentialRequestResponseBody(bytes.NewReader(c.Request.Body()))
+		if err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		attempt, ok := g.beginAttempt(ctx, c, session.Username)
+		if !ok {
+			return
+		}
+		user, err := g.users.GetUser(ctx, session.Username)
+		if errors.Is(err, store.ErrNotFound) {
+			loginFailed(ctx, c, attempt, session.Username, "unknown_user")
+			return
+		}
+		if err != nil {
+			releaseAttempt(ctx, attempt)
+			log.Error().Err(err).Str("username", session.Username).Msg("Load user failed")
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		credential, err := wa.ValidateLogin(user, session.Data, parsed)
+		if err != nil {
+			log.Warn().Err(err).Str("username", user.Username).Msg("WebAuthn login rejected")
+			loginFailed(ctx, c, attempt, user.Username, "bad_passkey")
+			return
+		}
+		// 更新签名计数器
+		if err = g.users.AddCredential(ctx, user.Username, *credential); err != nil {
+			log.Error().Err(err).Str("username", user.Username).Msg("Save WebAuthn credential failed")
+		}
+
+		token, err := g.newToken(ctx, user, amrHardwareKey)
+		if err != nil {
+			releaseAttempt(ctx, attempt)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		g.loginSucceeded(ctx, c, attempt, user.Username, amrHardwareKey)
+		g.setTokenCookie(c, token)
+		redirect, err := g.loginRedirect(ctx, token, state)
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		c.JSON(http.StatusOK, utils.H{"redirect": redirect})
+	})
+
+	return nil
+}
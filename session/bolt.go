@@ -0,0 +1,151 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var _ Store = &Bolt{}
+
+var (
+	sessionsBucket     = []byte("sessions")      // 会话 ID -> 会话
+	userSessionsBucket = []byte("user_sessions") // 用户名 -> 会话 ID 集合
+)
+
+// Bolt 是基于 BoltDB 文件的会话存储
+type Bolt struct {
+	db *bolt.DB
+}
+
+func NewBolt(path string) (*Bolt, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{sessionsBucket, userSessionsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Bolt{db: db}, nil
+}
+
+func getSession(tx *bolt.Tx, id string) (*Session, error) {
+	data := tx.Bucket(sessionsBucket).Get([]byte(id))
+	if data == nil {
+		return nil, ErrNotFound
+	}
+	var s Session
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func deleteSession(tx *bolt.Tx, s *Session) error {
+	if err := tx.Bucket(sessionsBucket).Delete([]byte(s.ID)); err != nil {
+		return err
+	}
+	ids := tx.Bucket(userSessionsBucket).Bucket([]byte(s.Username))
+	if ids == nil {
+		return nil
+	}
+	return ids.Delete([]byte(s.ID))
+}
+
+func (b *Bolt) Create(ctx context.Context, s *Session) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		ids, err := tx.Bucket(userSessionsBucket).CreateBucketIfNotExists([]byte(s.Username))
+		if err != nil {
+			return err
+		}
+		// 顺便清理该用户已过期的会话
+		now := time.Now()
+		var expired []*Session
+		err = ids.ForEach(func(id, _ []byte) error {
+			old, err := getSession(tx, string(id))
+			if err == ErrNotFound || err == nil && old.Expired(now) {
+				expired = append(expired, &Session{ID: string(id), Username: s.Username})
+				return nil
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		for _, old := range expired {
+			if err = deleteSession(tx, old); err != nil {
+				return err
+			}
+		}
+
+		if err = tx.Bucket(sessionsBucket).Put([]byte(s.ID), data); err != nil {
+			return err
+		}
+		return ids.Put([]byte(s.ID), nil)
+	})
+}
+
+func (b *Bolt) Get(ctx context.Context, id string) (s *Session, err error) {
+	err = b.db.View(func(tx *bolt.Tx) error {
+		s, err = getSession(tx, id)
+		return err
+	})
+	if err == nil && s.Expired(time.Now()) {
+		return nil, ErrNotFound
+	}
+	return
+}
+
+func (b *Bolt) Delete(ctx context.Context, id string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		s, err := getSession(tx, id)
+		if err == ErrNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return deleteSession(tx, s)
+	})
+}
+
+func (b *Bolt) DeleteUser(ctx context.Context, username string) (n int, err error) {
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		users := tx.Bucket(userSessionsBucket)
+		ids := users.Bucket([]byte(username))
+		if ids == nil {
+			return nil
+		}
+		sessions := tx.Bucket(sessionsBucket)
+		err := ids.ForEach(func(id, _ []byte) error {
+			if sessions.Get(id) != nil {
+				n++
+			}
+			return sessions.Delete(id)
+		})
+		if err != nil {
+			return err
+		}
+		return users.DeleteBucket([]byte(username))
+	})
+	return
+}
+
+func (b *Bolt) Close() error {
+	return b.db.Close()
+}
@@ -0,0 +1,76 @@
+package session
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+var _ Store = &Memory{}
+
+// sweepInterval 是清理过期会话的最小间隔
+const sweepInterval = time.Minute
+
+// Memory 是内存中的会话存储，重启后全部会话失效
+type Memory struct {
+	mu        sync.Mutex
+	sessions  map[string]*Session
+	lastSweep time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{sessions: make(map[string]*Session)}
+}
+
+func (m *Memory) Create(ctx context.Context, s *Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// 顺便清理已过期的会话
+	now := time.Now()
+	if now.Sub(m.lastSweep) > sweepInterval {
+		for id, old := range m.sessions {
+			if old.Expired(now) {
+				delete(m.sessions, id)
+			}
+		}
+		m.lastSweep = now
+	}
+	saved := *s
+	m.sessions[s.ID] = &saved
+	return nil
+}
+
+func (m *Memory) Get(ctx context.Context, id string) (*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok || s.Expired(time.Now()) {
+		return nil, ErrNotFound
+	}
+	saved := *s
+	return &saved, nil
+}
+
+func (m *Memory) Delete(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, id)
+	return nil
+}
+
+func (m *Memory) DeleteUser(ctx context.Context, username string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for id, s := range m.sessions {
+		if s.Username == username {
+			delete(m.sessions, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
@@ -0,0 +1,61 @@
+// Package session 保存服务端会话，用于注销和吊销已签发的 JWT
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNotFound 会话不存在、已过期或已被吊销
+var ErrNotFound = errors.New("session not found")
+
+// Session 对应一个已签发的 JWT，ID 即 JWT 的 jti
+type Session struct {
+	ID        string    `json:"id"`
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Expired 判断会话在 now 时是否已过期
+func (s *Session) Expired(now time.Time) bool {
+	return !now.Before(s.ExpiresAt)
+}
+
+// Store 是会话的存储
+type Store interface {
+	// Create 保存会话，相同 ID 的会话会被替换
+	Create(ctx context.Context, s *Session) error
+	// Get 按 ID 查找未过期的会话
+	Get(ctx context.Context, id string) (*Session, error)
+	// Delete 删除会话，会话不存在时不返回错误
+	Delete(ctx context.Context, id string) error
+	// DeleteUser 删除用户的全部会话，返回删除的数量
+	DeleteUser(ctx context.Context, username string) (int, error)
+	// Close 释放存储占用的资源
+	Close() error
+}
+
+type Config struct {
+	Type             string        `koanf:"type"`              // 存储类型: memory, bolt，为空时不启用服务端会话
+	Path             string        `koanf:"path"`              // bolt 数据文件路径
+	Lifetime         time.Duration `koanf:"lifetime"`          // 登录后的最长有效期，默认取 cookies.max_age，都未配置时为 24 小时
+	IdleTimeout      time.Duration `koanf:"idle_timeout"`      // 无请求超过该时间后失效，为 0 时不限制
+	RefreshThreshold time.Duration `koanf:"refresh_threshold"` // 令牌签发超过该时间后自动续期，默认为 idle_timeout 的一半
+}
+
+// New 根据配置创建会话存储，未启用时返回 nil
+func New(cfg Config) (Store, error) {
+	switch cfg.Type {
+	case "":
+		return nil, nil
+	case "memory":
+		return NewMemory(), nil
+	case "bolt":
+		return NewBolt(cfg.Path)
+	default:
+		return nil, fmt.Errorf("unknown session store type: %s", cfg.Type)
+	}
+}
@@ -0,0 +1,90 @@
+package session
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testStore 是所有 Store 实现共用的测试
+func testStore(t *testing.T, s Store) {
+	ctx := context.Background()
+	now := time.Now()
+	for _, sess := range []*Session{
+		{ID: "a1", Username: "alice", CreatedAt: now, ExpiresAt: now.Add(time.Hour)},
+		{ID: "a2", Username: "alice", CreatedAt: now, ExpiresAt: now.Add(time.Hour)},
+		{ID: "b1", Username: "bob", CreatedAt: now, ExpiresAt: now.Add(time.Hour)},
+		{ID: "old", Username: "bob", CreatedAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour)},
+	} {
+		require.NoError(t, s.Create(ctx, sess))
+	}
+
+	sess, err := s.Get(ctx, "a1")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", sess.Username)
+	assert.WithinDuration(t, now.Add(time.Hour), sess.ExpiresAt, time.Second)
+
+	_, err = s.Get(ctx, "old")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = s.Get(ctx, "missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	require.NoError(t, s.Delete(ctx, "b1"))
+	_, err = s.Get(ctx, "b1")
+	assert.ErrorIs(t, err, ErrNotFound)
+	require.NoError(t, s.Delete(ctx, "b1"))
+
+	n, err := s.DeleteUser(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	_, err = s.Get(ctx, "a2")
+	assert.ErrorIs(t, err, ErrNotFound)
+	n, err = s.DeleteUser(ctx, "alice")
+	require.NoError(t, err)
+	assert.Zero(t, n)
+}
+
+func TestMemory(t *testing.T) {
+	s := NewMemory()
+	defer s.Close()
+	testStore(t, s)
+}
+
+func TestBolt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.db")
+	s, err := NewBolt(path)
+	require.NoError(t, err)
+	testStore(t, s)
+	now := time.Now()
+	require.NoError(t, s.Create(context.Background(), &Session{ID: "c1", Username: "carol", ExpiresAt: now.Add(time.Hour)}))
+	require.NoError(t, s.Close())
+
+	// 重新打开后会话仍然存在
+	s, err = NewBolt(path)
+	require.NoError(t, err)
+	defer s.Close()
+	_, err = s.Get(context.Background(), "c1")
+	assert.NoError(t, err)
+}
+
+func TestNew(t *testing.T) {
+	s, err := New(Config{})
+	require.NoError(t, err)
+	assert.Nil(t, s)
+
+	s, err = New(Config{Type: "memory"})
+	require.NoError(t, err)
+	assert.IsType(t, &Memory{}, s)
+
+	s, err = New(Config{Type: "bolt", Path: filepath.Join(t.TempDir(), "sessions.db")})
+	require.NoError(t, err)
+	assert.IsType(t, &Bolt{}, s)
+	s.Close()
+
+	_, err = New(Config{Type: "unknown"})
+	assert.Error(t, err)
+}
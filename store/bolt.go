@@ -0,0 +1,268 @@
+package store
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/ipfans/authgate/models"
+	bolt "go.etcd.io/bbolt"
+)
+
+var _ UserStore = &Bolt{}
+
+var (
+	usersBucket        = []byte("users")         // 用户名 -> 用户
+	userIDsBucket      = []byte("user_ids")      // 用户 ID -> 用户名
+	accessTokensBucket = []byte("access_tokens") // 访问令牌 ID -> 用户名
+)
+
+// Bolt 是基于 BoltDB 文件的用户存储
+type Bolt struct {
+	db *bolt.DB
+}
+
+func NewBolt(path string) (*Bolt, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{usersBucket, userIDsBucket, accessTokensBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Bolt{db: db}, nil
+}
+
+func idKey(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
+
+func getUser(tx *bolt.Tx, username string) (*models.User, error) {
+	data := tx.Bucket(usersBucket).Get([]byte(username))
+	if data == nil {
+		return nil, ErrNotFound
+	}
+	var user models.User
+	if err := json.Unmarshal(data, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func putUser(tx *bolt.Tx, user *models.User) error {
+	data, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+	// 同步访问令牌索引，先删除旧的令牌 ID
+	if old, err := getUser(tx, user.Username); err == nil {
+		if err = deleteAccessTokenIndex(tx, old); err != nil {
+			return err
+		}
+	}
+	tokens := tx.Bucket(accessTokensBucket)
+	for _, token := range user.AccessTokens {
+		if err = tokens.Put([]byte(token.ID), []byte(user.Username)); err != nil {
+			return err
+		}
+	}
+	if err = tx.Bucket(usersBucket).Put([]byte(user.Username), data); err != nil {
+		return err
+	}
+	return tx.Bucket(userIDsBucket).Put(idKey(user.ID), []byte(user.Username))
+}
+
+// deleteAccessTokenIndex 删除用户全部访问令牌的索引
+func deleteAccessTokenIndex(tx *bolt.Tx, user *models.User) error {
+	tokens := tx.Bucket(accessTokensBucket)
+	for _, token := range user.AccessTokens {
+		if err := tokens.Delete([]byte(token.ID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Bolt) GetUser(ctx context.Context, username string) (user *models.User, err error) {
+	err = b.db.View(func(tx *bolt.Tx) error {
+		user, err = getUser(tx, username)
+		return err
+	})
+	return
+}
+
+func (b *Bolt) GetUserByID(ctx context.Context, id uint64) (user *models.User, err error) {
+	err = b.db.View(func(tx *bolt.Tx) error {
+		username := tx.Bucket(userIDsBucket).Get(idKey(id))
+		if username == nil {
+			return ErrNotFound
+		}
+		user, err = getUser(tx, string(username))
+		return err
+	})
+	return
+}
+
+func (b *Bolt) ListUsers(ctx context.Context) (users []*models.User, err error) {
+	err = b.db.View(func(tx *bolt.Tx) error {
+		// 按 ID 顺序遍历
+		return tx.Bucket(userIDsBucket).ForEach(func(_, username []byte) error {
+			user, err := getUser(tx, string(username))
+			if err != nil {
+				return err
+			}
+			users = append(users, user)
+			return nil
+		})
+	})
+	return
+}
+
+func (b *Bolt) CreateUser(ctx context.Context, user *models.User) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(usersBucket).Get([]byte(user.Username)) != nil {
+			return ErrExists
+		}
+		ids := tx.Bucket(userIDsBucket)
+		if user.ID == 0 {
+			id, err := ids.NextSequence()
+			if err != nil {
+				return err
+			}
+			user.ID = id
+		} else if user.ID > ids.Sequence() {
+			if err := ids.SetSequence(user.ID); err != nil {
+				return err
+			}
+		}
+		return putUser(tx, user)
+	})
+}
+
+func (b *Bolt) UpdateUser(ctx context.Context, user *models.User) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		old, err := getUser(tx, user.Username)
+		if err != nil {
+			return err
+		}
+		u := *user
+		u.ID = old.ID
+		return putUser(tx, &u)
+	})
+}
+
+func (b *Bolt) DeleteUser(ctx context.Context, username string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		user, err := getUser(tx, username)
+		if err != nil {
+			return err
+		}
+		if err = tx.Bucket(userIDsBucket).Delete(idKey(user.ID)); err != nil {
+			return err
+		}
+		if err = deleteAccessTokenIndex(tx, user); err != nil {
+			return err
+		}
+		return tx.Bucket(usersBucket).Delete([]byte(username))
+	})
+}
+
+// updateUser 在同一事务中读取、修改并写回用户，fn 返回错误时不写回
+func (b *Bolt) updateUser(username string, fn func(user *models.User) error) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		user, err := getUser(tx, username)
+		if err != nil {
+			return err
+		}
+		if err = fn(user); err != nil {
+			return err
+		}
+		return putUser(tx, user)
+	})
+}
+
+func (b *Bolt) SetTOTP(ctx context.Context, username, secret string, step int64, recoveryCodes []string) error {
+	return b.updateUser(username, func(user *models.User) error {
+		user.TOTPSecret = secret
+		user.TOTPLastStep = step
+		user.RecoveryCodes = recoveryCodes
+		return nil
+	})
+}
+
+func (b *Bolt) UseTOTPStep(ctx context.Context, username, secret string, step int64) error {
+	return b.updateUser(username, func(user *models.User) error {
+		return useTOTPStep(user, secret, step)
+	})
+}
+
+func (b *Bolt) UseRecoveryCode(ctx context.Context, username, code string) (remaining int, err error) {
+	err = b.updateUser(username, func(user *models.User) error {
+		if err := useRecoveryCode(user, code); err != nil {
+			return err
+		}
+		remaining = len(user.RecoveryCodes)
+		return nil
+	})
+	return
+}
+
+func (b *Bolt) AddCredential(ctx context.Context, username string, credential webauthn.Credential) error {
+	return b.updateUser(username, func(user *models.User) error {
+		user.Credentials = putCredential(user.Credentials, credential)
+		return nil
+	})
+}
+
+func (b *Bolt) RemoveCredential(ctx context.Context, username string, credentialID []byte) error {
+	return b.updateUser(username, func(user *models.User) error {
+		user.Credentials = deleteCredential(user.Credentials, credentialID)
+		return nil
+	})
+}
+
+func (b *Bolt) AddAccessToken(ctx context.Context, username string, token models.AccessToken) error {
+	return b.updateUser(username, func(user *models.User) error {
+		user.AccessTokens = append(user.AccessTokens, token)
+		return nil
+	})
+}
+
+func (b *Bolt) RemoveAccessToken(ctx context.Context, username, id string) error {
+	return b.updateUser(username, func(user *models.User) error {
+		user.AccessTokens = deleteAccessToken(user.AccessTokens, id)
+		return nil
+	})
+}
+
+func (b *Bolt) GetUserByAccessToken(ctx context.Context, id string) (user *models.User, err error) {
+	err = b.db.View(func(tx *bolt.Tx) error {
+		username := tx.Bucket(accessTokensBucket).Get([]byte(id))
+		if username == nil {
+			return ErrNotFound
+		}
+		user, err = getUser(tx, string(username))
+		if err == nil && user.FindAccessToken(id) == nil {
+			return ErrNotFound
+		}
+		return err
+	})
+	return
+}
+
+func (b *Bolt) Close() error {
+	return b.db.Close()
+}
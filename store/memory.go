@@ -0,0 +1,225 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/ipfans/authgate/models"
+)
+
+var _ UserStore = &Memory{}
+
+// Memory 是基于内存的用户存储，进程退出后数据丢失
+type Memory struct {
+	mu     sync.RWMutex
+	users  map[string]*models.User
+	nextID uint64
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		users: make(map[string]*models.User),
+	}
+}
+
+func (m *Memory) GetUser(ctx context.Context, username string) (*models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	user, ok := m.users[username]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return cloneUser(user), nil
+}
+
+func (m *Memory) GetUserByID(ctx context.Context, id uint64) (*models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, user := range m.users {
+		if user.ID == id {
+			return cloneUser(user), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *Memory) ListUsers(ctx context.Context) ([]*models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	users := make([]*models.User, 0, len(m.users))
+	for _, user := range m.users {
+		users = append(users, cloneUser(user))
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].ID < users[j].ID
+	})
+	return users, nil
+}
+
+func (m *Memory) CreateUser(ctx context.Context, user *models.User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[user.Username]; ok {
+		return ErrExists
+	}
+	if user.ID == 0 {
+		m.nextID++
+		user.ID = m.nextID
+	} else if user.ID > m.nextID {
+		m.nextID = user.ID
+	}
+	m.users[user.Username] = cloneUser(user)
+	return nil
+}
+
+func (m *Memory) UpdateUser(ctx context.Context, user *models.User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	old, ok := m.users[user.Username]
+	if !ok {
+		return ErrNotFound
+	}
+	u := cloneUser(user)
+	u.ID = old.ID
+	m.users[user.Username] = u
+	return nil
+}
+
+func (m *Memory) DeleteUser(ctx context.Context, username string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[username]; !ok {
+		return ErrNotFound
+	}
+	delete(m.users, username)
+	return nil
+}
+
+func (m *Memory) AddCredential(ctx context.Context, username string, credential webauthn.Credential) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.users[username]
+	if !ok {
+		return ErrNotFound
+	}
+	user.Credentials = putCredential(user.Credentials, credential)
+	return nil
+}
+
+func (m *Memory) RemoveCredential(ctx context.Context, username string, credentialID []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.users[username]
+	if !ok {
+		return ErrNotFound
+	}
+	user.Credentials = deleteCredential(user.Credentials, credentialID)
+	return nil
+}
+
+func (m *Memory) SetTOTP(ctx context.Context, username, secret string, step int64, recoveryCodes []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.users[username]
+	if !ok {
+		return ErrNotFound
+	}
+	user.TOTPSecret = secret
+	user.TOTPLastStep = step
+	user.RecoveryCodes = append([]string(nil), recoveryCodes...)
+	return nil
+}
+
+func (m *Memory) UseTOTPStep(ctx context.Context, username, secret string, step int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.users[username]
+	if !ok {
+		return ErrNotFound
+	}
+	return useTOTPStep(user, secret, step)
+}
+
+func (m *Memory) UseRecoveryCode(ctx context.Context, username, code string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.users[username]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	if err := useRecoveryCode(user, code); err != nil {
+		return 0, err
+	}
+	return len(user.RecoveryCodes), nil
+}
+
+func (m *Memory) AddAccessToken(ctx context.Context, username string, token models.AccessToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.users[username]
+	if !ok {
+		return ErrNotFound
+	}
+	user.AccessTokens = append(user.AccessTokens, token)
+	return nil
+}
+
+func (m *Memory) RemoveAccessToken(ctx context.Context, username, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.users[username]
+	if !ok {
+		return ErrNotFound
+	}
+	user.AccessTokens = deleteAccessToken(user.AccessTokens, id)
+	return nil
+}
+
+func (m *Memory) GetUserByAccessToken(ctx context.Context, id string) (*models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, user := range m.users {
+		if user.FindAccessToken(id) != nil {
+			return cloneUser(user), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
+
+// putCredential 添加凭据，已存在相同 ID 的凭据时替换
+func putCredential(creds []webauthn.Credential, credential webauthn.Credential) []webauthn.Credential {
+	for i := range creds {
+		if bytes.Equal(creds[i].ID, credential.ID) {
+			creds[i] = credential
+			return creds
+		}
+	}
+	return append(creds, credential)
+}
+
+func deleteCredential(creds []webauthn.Credential, credentialID []byte) []webauthn.Credential {
+	result := creds[:0]
+	for _, c := range creds {
+		if !bytes.Equal(c.ID, credentialID) {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+func deleteAccessToken(tokens []models.AccessToken, id string) []models.AccessToken {
+	result := tokens[:0]
+	for _, t := range tokens {
+		if t.ID != id {
+			result = append(result, t)
+		}
+	}
+	return result
+}
@@ -0,0 +1,101 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/ipfans/authgate/models"
+	"github.com/ipfans/authgate/totp"
+)
+
+var (
+	// ErrNotFound 用户不存在
+	ErrNotFound = errors.New("user not found")
+	// ErrExists 用户名已被占用
+	ErrExists = errors.New("user already exists")
+	// ErrCodeUsed TOTP 验证码已经使用过，或恢复码不存在
+	ErrCodeUsed = errors.New("code already used")
+)
+
+// UserStore 是用户及其凭据的存储
+type UserStore interface {
+	// GetUser 按用户名查找用户
+	GetUser(ctx context.Context, username string) (*models.User, error)
+	// GetUserByID 按 ID 查找用户
+	GetUserByID(ctx context.Context, id uint64) (*models.User, error)
+	// ListUsers 返回全部用户
+	ListUsers(ctx context.Context) ([]*models.User, error)
+	// CreateUser 创建用户，用户 ID 为 0 时自动分配
+	CreateUser(ctx context.Context, user *models.User) error
+	// UpdateUser 更新已存在的用户
+	UpdateUser(ctx context.Context, user *models.User) error
+	// DeleteUser 删除用户
+	DeleteUser(ctx context.Context, username string) error
+	// AddCredential 为用户保存 WebAuthn 凭据，相同 ID 的凭据会被替换
+	AddCredential(ctx context.Context, username string, credential webauthn.Credential) error
+	// RemoveCredential 删除用户的 WebAuthn 凭据
+	RemoveCredential(ctx context.Context, username string, credentialID []byte) error
+	// SetTOTP 设置用户的 TOTP 密钥、最近使用的时间步和恢复码哈希，secret 为空时停用 TOTP
+	SetTOTP(ctx context.Context, username, secret string, step int64, recoveryCodes []string) error
+	// UseTOTPStep 在用户的 TOTP 密钥仍为 secret 且 step 比最近使用的时间步新时保存 step，
+	// 否则返回 ErrCodeUsed。读取和写入在同一事务中完成，并发请求只有一个能使用同一个验证码
+	UseTOTPStep(ctx context.Context, username, secret string, step int64) error
+	// UseRecoveryCode 删除与 code 匹配的恢复码，没有匹配时返回 ErrCodeUsed
+	UseRecoveryCode(ctx context.Context, username, code string) (remaining int, err error)
+	// AddAccessToken 为用户保存个人访问令牌
+	AddAccessToken(ctx context.Context, username string, token models.AccessToken) error
+	// RemoveAccessToken 删除用户的个人访问令牌
+	RemoveAccessToken(ctx context.Context, username, id string) error
+	// GetUserByAccessToken 按访问令牌 ID 查找所属用户
+	GetUserByAccessToken(ctx context.Context, id string) (*models.User, error)
+	// Close 释放存储占用的资源
+	Close() error
+}
+
+type Config struct {
+	Type string `koanf:"type"` // 存储类型: memory, bolt，默认 memory
+	Path string `koanf:"path"` // bolt 数据文件路径
+}
+
+// New 根据配置创建用户存储
+func New(cfg Config) (UserStore, error) {
+	switch cfg.Type {
+	case "", "memory":
+		return NewMemory(), nil
+	case "bolt":
+		return NewBolt(cfg.Path)
+	default:
+		return nil, fmt.Errorf("unknown store type: %s", cfg.Type)
+	}
+}
+
+// useTOTPStep 校验并保存 TOTP 时间步，调用方需持有用户的写锁或事务
+func useTOTPStep(user *models.User, secret string, step int64) error {
+	if user.TOTPSecret == "" || user.TOTPSecret != secret || step <= user.TOTPLastStep {
+		return ErrCodeUsed
+	}
+	user.TOTPLastStep = step
+	return nil
+}
+
+// useRecoveryCode 删除匹配的恢复码，调用方需持有用户的写锁或事务
+func useRecoveryCode(user *models.User, code string) error {
+	rest, ok := totp.UseRecoveryCode(user.RecoveryCodes, code)
+	if !ok {
+		return ErrCodeUsed
+	}
+	user.RecoveryCodes = rest
+	return nil
+}
+
+// cloneUser 复制用户，避免调用方修改存储中的数据
+func cloneUser(user *models.User) *models.User {
+	u := *user
+	u.Groups = append([]string(nil), user.Groups...)
+	u.RecoveryCodes = append([]string(nil), user.RecoveryCodes...)
+	u.Credentials = append([]webauthn.Credential(nil), user.Credentials...)
+	u.AccessTokens = append([]models.AccessToken(nil), user.AccessTokens...)
+	return &u
+}
@@ -0,0 +1,185 @@
+package store
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/ipfans/authgate/models"
+	"github.com/ipfans/authgate/totp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testUserStore 是所有 UserStore 实现共用的测试
+func testUserStore(t *testing.T, s UserStore) {
+	ctx := context.Background()
+
+	alice := &models.User{Username: "alice", DisplayName: "Alice", Email: "alice@example.com"}
+	require.NoError(t, s.CreateUser(ctx, alice))
+	assert.NotZero(t, alice.ID)
+	bob := &models.User{Username: "bob"}
+	require.NoError(t, s.CreateUser(ctx, bob))
+	assert.Greater(t, bob.ID, alice.ID)
+	assert.ErrorIs(t, s.CreateUser(ctx, &models.User{Username: "alice"}), ErrExists)
+
+	user, err := s.GetUser(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, alice, user)
+
+	user, err = s.GetUserByID(ctx, bob.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "bob", user.Username)
+
+	_, err = s.GetUser(ctx, "carol")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = s.GetUserByID(ctx, 1000)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	users, err := s.ListUsers(ctx)
+	require.NoError(t, err)
+	require.Len(t, users, 2)
+	assert.Equal(t, "alice", users[0].Username)
+	assert.Equal(t, "bob", users[1].Username)
+
+	// 更新时保留原有 ID
+	require.NoError(t, s.UpdateUser(ctx, &models.User{Username: "alice", DisplayName: "Alice Liddell"}))
+	user, err = s.GetUser(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, alice.ID, user.ID)
+	assert.Equal(t, "Alice Liddell", user.DisplayName)
+	assert.ErrorIs(t, s.UpdateUser(ctx, &models.User{Username: "carol"}), ErrNotFound)
+
+	// 凭据
+	require.NoError(t, s.AddCredential(ctx, "alice", webauthn.Credential{ID: []byte("1")}))
+	require.NoError(t, s.AddCredential(ctx, "alice", webauthn.Credential{ID: []byte("2")}))
+	require.NoError(t, s.AddCredential(ctx, "alice", webauthn.Credential{
+		ID:            []byte("1"),
+		Authenticator: webauthn.Authenticator{SignCount: 5},
+	}))
+	user, err = s.GetUser(ctx, "alice")
+	require.NoError(t, err)
+	require.Len(t, user.WebAuthnCredentials(), 2)
+	assert.Equal(t, uint32(5), user.WebAuthnCredentials()[0].Authenticator.SignCount)
+
+	require.NoError(t, s.RemoveCredential(ctx, "alice", []byte("1")))
+	user, err = s.GetUser(ctx, "alice")
+	require.NoError(t, err)
+	require.Len(t, user.WebAuthnCredentials(), 1)
+	assert.Equal(t, []byte("2"), user.WebAuthnCredentials()[0].ID)
+	assert.ErrorIs(t, s.AddCredential(ctx, "carol", webauthn.Credential{}), ErrNotFound)
+
+	// 访问令牌
+	require.NoError(t, s.AddAccessToken(ctx, "bob", models.AccessToken{ID: "t1", Name: "ci", Hash: "h1"}))
+	require.NoError(t, s.AddAccessToken(ctx, "bob", models.AccessToken{ID: "t2", Name: "deploy", Hash: "h2"}))
+	user, err = s.GetUserByAccessToken(ctx, "t2")
+	require.NoError(t, err)
+	assert.Equal(t, "bob", user.Username)
+	assert.Equal(t, "deploy", user.FindAccessToken("t2").Name)
+	require.NoError(t, s.RemoveAccessToken(ctx, "bob", "t2"))
+	_, err = s.GetUserByAccessToken(ctx, "t2")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = s.GetUserByAccessToken(ctx, "t1")
+	assert.NoError(t, err)
+	assert.ErrorIs(t, s.AddAccessToken(ctx, "carol", models.AccessToken{ID: "t3"}), ErrNotFound)
+
+	// TOTP
+	codes := []string{"code-1", "code-2"}
+	hashes := []string{totp.HashRecoveryCode(codes[0]), totp.HashRecoveryCode(codes[1])}
+	require.NoError(t, s.SetTOTP(ctx, "alice", "SECRET", 10, hashes))
+	assert.ErrorIs(t, s.UseTOTPStep(ctx, "alice", "SECRET", 10), ErrCodeUsed)
+	assert.ErrorIs(t, s.UseTOTPStep(ctx, "alice", "OTHER", 11), ErrCodeUsed)
+	assert.ErrorIs(t, s.UseTOTPStep(ctx, "carol", "SECRET", 11), ErrNotFound)
+	require.NoError(t, s.UseTOTPStep(ctx, "alice", "SECRET", 11))
+	assert.ErrorIs(t, s.UseTOTPStep(ctx, "alice", "SECRET", 11), ErrCodeUsed)
+
+	// 并发使用同一个恢复码时只有一个成功
+	var wg sync.WaitGroup
+	var used atomic.Int32
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.UseRecoveryCode(ctx, "alice", codes[0]); err == nil {
+				used.Add(1)
+			} else {
+				assert.ErrorIs(t, err, ErrCodeUsed)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), used.Load())
+	remaining, err := s.UseRecoveryCode(ctx, "alice", codes[1])
+	require.NoError(t, err)
+	assert.Zero(t, remaining)
+	user, err = s.GetUser(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, int64(11), user.TOTPLastStep)
+	assert.Empty(t, user.RecoveryCodes)
+	// 其他字段不受影响
+	assert.Len(t, user.WebAuthnCredentials(), 1)
+
+	require.NoError(t, s.SetTOTP(ctx, "alice", "", 0, nil))
+	user, err = s.GetUser(ctx, "alice")
+	require.NoError(t, err)
+	assert.False(t, user.TOTPEnabled())
+	assert.ErrorIs(t, s.UseTOTPStep(ctx, "alice", "", 12), ErrCodeUsed)
+
+	// 删除
+	require.NoError(t, s.DeleteUser(ctx, "bob"))
+	_, err = s.GetUserByAccessToken(ctx, "t1")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = s.GetUserByID(ctx, bob.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorIs(t, s.DeleteUser(ctx, "bob"), ErrNotFound)
+}
+
+func TestMemory(t *testing.T) {
+	s := NewMemory()
+	defer s.Close()
+	testUserStore(t, s)
+
+	// 返回的是副本
+	user, err := s.GetUser(context.Background(), "alice")
+	require.NoError(t, err)
+	user.DisplayName = "changed"
+	user, err = s.GetUser(context.Background(), "alice")
+	require.NoError(t, err)
+	assert.NotEqual(t, "changed", user.DisplayName)
+}
+
+func TestBolt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.db")
+	s, err := NewBolt(path)
+	require.NoError(t, err)
+	testUserStore(t, s)
+	require.NoError(t, s.Close())
+
+	// 重新打开后数据仍然存在
+	s, err = NewBolt(path)
+	require.NoError(t, err)
+	defer s.Close()
+	user, err := s.GetUser(context.Background(), "alice")
+	require.NoError(t, err)
+	assert.Len(t, user.WebAuthnCredentials(), 1)
+	next := &models.User{Username: "dave"}
+	require.NoError(t, s.CreateUser(context.Background(), next))
+	assert.Greater(t, next.ID, user.ID)
+}
+
+func TestNew(t *testing.T) {
+	s, err := New(Config{})
+	require.NoError(t, err)
+	assert.IsType(t, &Memory{}, s)
+
+	s, err = New(Config{Type: "bolt", Path: filepath.Join(t.TempDir(), "users.db")})
+	require.NoError(t, err)
+	assert.IsType(t, &Bolt{}, s)
+	s.Close()
+
+	_, err = New(Config{Type: "unknown"})
+	assert.Error(t, err)
+}
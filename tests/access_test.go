@@ -0,0 +1,147 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/cloudwego/hertz/pkg/app/server"
+	"github.com/cloudwego/hertz/pkg/common/ut"
+	"github.com/cloudwego/hertz/pkg/route"
+	"github.com/ipfans/authgate/access"
+	"github.com/ipfans/authgate/routers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupAccessServer(t *testing.T) *route.Engine {
+	cfg := loadTestConfig()
+	cfg.Routes.Backends = append(cfg.Routes.Backends, routers.Backend{
+		Host:     "admin.example.com",
+		UpStream: []string{"http://127.0.0.1:8082"},
+		Access: access.Config{
+			Rules: []access.Rule{
+				{Action: access.ActionPublic, Paths: []string{"/public/"}},
+				{Action: access.ActionAllow, Groups: []string{"admin"}},
+				{Action: access.ActionAllow, Methods: []string{"GET"}, Paths: []string{"/reports/*"}},
+			},
+		},
+	})
+	h := server.Default()
+	require.NoError(t, routers.RegisterRoutes(h, cfg.Routes))
+	return h.Engine
+}
+
+// assertProxied 判断请求通过了校验并交给了反向代理
+func assertProxied(t *testing.T, rec *ut.ResponseRecorder) {
+	t.Helper()
+	assert.NotEqual(t, http.StatusTemporaryRedirect, rec.Code)
+	assert.NotEqual(t, http.StatusForbidden, rec.Code)
+	assert.Equal(t, "No backend found", rec.Header().Get("X-Error"))
+}
+
+func TestAccessPolicy(t *testing.T) {
+	ts := setupAccessServer(t)
+	admin := hostHeader("admin.example.com")
+	alice := cookieHeader(loginAs(t, ts, "alice", "alicepass"))
+	testuser := cookieHeader(loginAs(t, ts, "testuser", "testpass"))
+
+	t.Run("Public path without login", func(t *testing.T) {
+		assertProxied(t, ut.PerformRequest(ts, "GET", "/public/logo.png", nil, admin))
+	})
+
+	t.Run("Protected path without login", func(t *testing.T) {
+		rec := ut.PerformRequest(ts, "GET", "/settings", nil, admin)
+		assert.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	})
+
+	t.Run("Allowed by group", func(t *testing.T) {
+		assertProxied(t, ut.PerformRequest(ts, "POST", "/settings", nil, admin, alice))
+	})
+
+	t.Run("Allowed by method and path", func(t *testing.T) {
+		assertProxied(t, ut.PerformRequest(ts, "GET", "/reports/daily", nil, admin, testuser))
+	})
+
+	t.Run("Forbidden", func(t *testing.T) {
+		rec := ut.PerformRequest(ts, "POST", "/reports/daily", nil, admin, testuser)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		rec = ut.PerformRequest(ts, "GET", "/settings", nil, admin, testuser)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		// 登录页路由同样受策略保护
+		rec = ut.PerformRequest(ts, "GET", "/login", nil, admin, testuser)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	})
+
+	t.Run("Backend without rules", func(t *testing.T) {
+		assertProxied(t, ut.PerformRequest(ts, "GET", "/anything", nil, hostHeader("test.example.com"), testuser))
+	})
+
+	t.Run("Forward auth", func(t *testing.T) {
+		forwarded := func(uri string) []ut.Header {
+			return []ut.Header{
+				hostHeader("authgate.internal"),
+				{Key: "X-Forwarded-Host", Value: "admin.example.com"},
+				{Key: "X-Forwarded-Uri", Value: uri},
+				{Key: "X-Forwarded-Method", Value: "GET"},
+			}
+		}
+		rec := ut.PerformRequest(ts, "GET", "/authgate/verify", nil, forwarded("/public/a.js")...)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		rec = ut.PerformRequest(ts, "GET", "/authgate/verify", nil, append(forwarded("/settings?tab=1"), testuser)...)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		rec = ut.PerformRequest(ts, "GET", "/authgate/verify", nil, append(forwarded("/reports/daily?tab=1"), testuser)...)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "testuser", rec.Header().Get("X-Auth-User"))
+	})
+}
+
+func TestInvalidAccessPolicy(t *testing.T) {
+	cfg := loadTestConfig()
+	cfg.Routes.Backends[0].Access.Rules = []access.Rule{{Action: "maybe"}}
+	assert.Error(t, routers.RegisterRoutes(server.Default(), cfg.Routes))
+}
+
+func TestAccessExpression(t *testing.T) {
+	cfg := loadTestConfig()
+	cfg.Routes.Backends = append(cfg.Routes.Backends, routers.Backend{
+		Host:     "expr.example.com",
+		UpStream: []string{"http://127.0.0.1:8082"},
+		Access: access.Config{
+			Rules: []access.Rule{
+				{Action: access.ActionAllow, When: `"admin" in groups`},
+				{Action: access.ActionAllow, When: `request.method == "GET" && request.headers[?"x-ticket"].orValue("").startsWith("OPS-")`},
+			},
+		},
+	})
+	h := server.Default()
+	require.NoError(t, routers.RegisterRoutes(h, cfg.Routes))
+	ts := h.Engine
+	host := hostHeader("expr.example.com")
+	alice := cookieHeader(loginAs(t, ts, "alice", "alicepass"))
+	testuser := cookieHeader(loginAs(t, ts, "testuser", "testpass"))
+	ticket := ut.Header{Key: "X-Ticket", Value: "OPS-42"}
+
+	assertProxied(t, ut.PerformRequest(ts, "DELETE", "/items", nil, host, alice))
+	assertProxied(t, ut.PerformRequest(ts, "GET", "/items", nil, host, testuser, ticket))
+	rec := ut.PerformRequest(ts, "GET", "/items", nil, host, testuser)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	rec = ut.PerformRequest(ts, "POST", "/items", nil, host, testuser, ticket)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	// 转发认证使用原始请求的方法和路径
+	rec = ut.PerformRequest(ts, "GET", "/authgate/verify", nil,
+		hostHeader("authgate.internal"),
+		ut.Header{Key: "X-Forwarded-Host", Value: "expr.example.com"},
+		ut.Header{Key: "X-Forwarded-Method", Value: "POST"},
+		testuser, ticket)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+func TestInvalidAccessExpression(t *testing.T) {
+	cfg := loadTestConfig()
+	cfg.Routes.Backends[0].Access.Rules = []access.Rule{{When: "user == \"alice\" &&\n  groups.exists(g, g == )"}}
+	err := routers.RegisterRoutes(server.Default(), cfg.Routes)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "access rule 0: when:")
+	assert.Contains(t, err.Error(), ":2:25:")
+}
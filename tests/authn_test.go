@@ -0,0 +1,130 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cloudwego/hertz/pkg/app/server"
+	"github.com/cloudwego/hertz/pkg/common/ut"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ipfans/authgate/authn"
+	"github.com/ipfans/authgate/passwd"
+	"github.com/ipfans/authgate/routers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthenticatorChain(t *testing.T) {
+	webhookUp := true
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !webhookUp {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		var req struct{ Username, Password string }
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		switch {
+		case req.Username != "erin":
+			w.WriteHeader(http.StatusNotFound)
+		case req.Password != "erin-remote":
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			_ = json.NewEncoder(w).Encode(map[string]any{"email": "erin@example.com", "groups": []string{"ops"}})
+		}
+	}))
+	defer hook.Close()
+
+	hash, err := passwd.Hash("break-glass")
+	require.NoError(t, err)
+	cfg := loadTestConfig()
+	cfg.Routes.Authenticators = []routers.AuthenticatorConfig{
+		{Type: "static", Users: []routers.UserConfig{{Username: "root", PasswordHash: hash, Groups: []string{"admins"}}}},
+		{Type: "webhook", Webhook: authn.WebhookConfig{URL: hook.URL}},
+		{Type: "local"},
+	}
+	h := server.Default()
+	require.NoError(t, routers.RegisterRoutes(h, cfg.Routes))
+	ts := h.Engine
+
+	groups := func(rec *ut.ResponseRecorder) []interface{} {
+		t.Helper()
+		claims := jwt.MapClaims{}
+		_, err := jwt.ParseWithClaims(responseCookie(rec, "authgate_token"), claims, func(*jwt.Token) (interface{}, error) {
+			return []byte("test_secret"), nil
+		})
+		require.NoError(t, err)
+		g, _ := claims["groups"].([]interface{})
+		return g
+	}
+
+	rec := passwordLogin(t, ts, "root", "break-glass", "")
+	require.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	assert.Equal(t, []interface{}{"admins"}, groups(rec))
+
+	rec = passwordLogin(t, ts, "erin", "erin-remote", "")
+	require.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	assert.Equal(t, []interface{}{"ops"}, groups(rec))
+
+	// webhook 明确拒绝时不再尝试后面的认证器
+	rec = passwordLogin(t, ts, "erin", "wrong", "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// webhook 不认识的用户交给本地账号
+	rec = passwordLogin(t, ts, "alice", "alicepass", "")
+	assert.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+
+	// 外部服务故障时，前面的应急账号和后面的本地账号仍然可用
+	webhookUp = false
+	rec = passwordLogin(t, ts, "root", "break-glass", "")
+	assert.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	rec = passwordLogin(t, ts, "alice", "alicepass", "")
+	assert.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	rec = passwordLogin(t, ts, "erin", "erin-remote", "")
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+}
+
+func TestAuthenticatorChainInvalid(t *testing.T) {
+	cfg := loadTestConfig()
+	cfg.Routes.Authenticators = []routers.AuthenticatorConfig{
+		{Type: "local"},
+		{Type: "htpasswd", File: "/nonexistent/htpasswd"},
+	}
+	err := routers.RegisterRoutes(server.Default(), cfg.Routes)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "authenticators[1]")
+}
+
+func TestAuthenticatorDuplicateAccounts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	require.NoError(t, os.WriteFile(path, []byte("bob:$2y$04$nHgUiXLCESMhPO3DNF8pne8NeUu6/SdgQUrbzY5qRZp65BAVUkqpK\n"), 0o600))
+	hash, err := passwd.Hash("break-glass")
+	require.NoError(t, err)
+
+	// 同一个账号只能配置在一处，否则从一处删除后仍然可以通过另一处登录
+	tests := []struct {
+		name          string
+		authenticator routers.AuthenticatorConfig
+	}{
+		{"Static user is also in users", routers.AuthenticatorConfig{Type: "static", Users: []routers.UserConfig{{Username: "alice", PasswordHash: hash}}}},
+		{"Top-level htpasswd file", routers.AuthenticatorConfig{Type: "htpasswd", File: path}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := loadTestConfig()
+			cfg.Routes.Htpasswd = path
+			cfg.Routes.Authenticators = []routers.AuthenticatorConfig{{Type: "local"}, tt.authenticator}
+			err := routers.RegisterRoutes(server.Default(), cfg.Routes)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "also configured as a local account")
+		})
+	}
+
+	// static 账号和 users 使用相同的配置格式，未指定密码时拒绝
+	cfg := loadTestConfig()
+	cfg.Routes.Authenticators = []routers.AuthenticatorConfig{{Type: "static", Users: []routers.UserConfig{{Username: "root"}}}}
+	assert.Error(t, routers.RegisterRoutes(server.Default(), cfg.Routes))
+}
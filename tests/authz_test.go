@@ -0,0 +1,102 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app/server"
+	"github.com/cloudwego/hertz/pkg/common/ut"
+	"github.com/ipfans/authgate/authz"
+	"github.com/ipfans/authgate/routers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthzWebhook(t *testing.T) {
+	var calls atomic.Int32
+	var down atomic.Bool
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var req authz.Request
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		// AuthGate 的 Cookie 不应发送给授权服务
+		assert.Empty(t, req.Headers["Cookie"])
+		// 只有值班的 alice 可以访问，其他人需要带上审批单号
+		if req.User.Username == "alice" || req.Headers["X-Ticket"] == "OPS-1" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer hook.Close()
+
+	cfg := loadTestConfig()
+	cfg.Routes.Backends = append(cfg.Routes.Backends,
+		routers.Backend{
+			Host:     "oncall.example.com",
+			UpStream: []string{"http://127.0.0.1:8082"},
+			AuthzURL: hook.URL,
+		},
+		routers.Backend{
+			Host:     "open.example.com",
+			UpStream: []string{"http://127.0.0.1:8082"},
+			AuthzURL: hook.URL,
+			Authz:    authz.Config{FailOpen: true, CacheTTL: time.Minute},
+		},
+	)
+	h := server.Default()
+	require.NoError(t, routers.RegisterRoutes(h, cfg.Routes))
+	ts := h.Engine
+	alice := cookieHeader(loginAs(t, ts, "alice", "alicepass"))
+	testuser := cookieHeader(loginAs(t, ts, "testuser", "testpass"))
+	oncall := hostHeader("oncall.example.com")
+
+	assertProxied(t, ut.PerformRequest(ts, "GET", "/deploy", nil, oncall, alice))
+	rec := ut.PerformRequest(ts, "GET", "/deploy", nil, oncall, testuser)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assertProxied(t, ut.PerformRequest(ts, "GET", "/deploy", nil, oncall, testuser, ut.Header{Key: "X-Ticket", Value: "OPS-1"}))
+
+	// 未登录时先跳转登录，不询问授权服务
+	before := calls.Load()
+	rec = ut.PerformRequest(ts, "GET", "/deploy", nil, oncall)
+	assert.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	assert.Equal(t, before, calls.Load())
+
+	// 转发认证同样经过授权服务
+	forwarded := []ut.Header{
+		hostHeader("authgate.internal"),
+		{Key: "X-Forwarded-Host", Value: "oncall.example.com"},
+		{Key: "X-Forwarded-Uri", Value: "/deploy"},
+	}
+	rec = ut.PerformRequest(ts, "GET", "/authgate/verify", nil, append(forwarded, testuser)...)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	rec = ut.PerformRequest(ts, "GET", "/authgate/verify", nil, append(forwarded, alice)...)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// 缓存的结果在有效期内直接使用
+	open := hostHeader("open.example.com")
+	assertProxied(t, ut.PerformRequest(ts, "GET", "/", nil, open, alice))
+	before = calls.Load()
+	assertProxied(t, ut.PerformRequest(ts, "GET", "/", nil, open, alice))
+	assert.Equal(t, before, calls.Load())
+
+	// 授权服务不可用时按 fail_open 处理
+	down.Store(true)
+	rec = ut.PerformRequest(ts, "GET", "/deploy", nil, oncall, alice)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assertProxied(t, ut.PerformRequest(ts, "GET", "/other", nil, open, testuser))
+}
+
+func TestInvalidAuthzURL(t *testing.T) {
+	cfg := loadTestConfig()
+	cfg.Routes.Backends[0].AuthzURL = "authz.example.com/check"
+	assert.Error(t, routers.RegisterRoutes(server.Default(), cfg.Routes))
+}
@@ -0,0 +1,234 @@
+package tests
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app/server"
+	"github.com/ipfans/authgate/access"
+	"github.com/ipfans/authgate/routers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCA{cert: cert, key: key}
+}
+
+// issue 签发证书，tmpl 中只需要填写主题、SAN 和用途
+func (ca *testCA) issue(t *testing.T, tmpl *x509.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	tmpl.SerialNumber = serial
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+	tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func (ca *testCA) clientCert(t *testing.T, cn string, ou []string, emails ...string) tls.Certificate {
+	return ca.issue(t, &x509.Certificate{
+		Subject:        pkix.Name{CommonName: cn, OrganizationalUnit: ou},
+		EmailAddresses: emails,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+}
+
+func writePEM(t *testing.T, path, typ string, der []byte) {
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600))
+}
+
+// startTLSServer 启动监听 HTTPS 的 AuthGate，返回地址和信任服务端证书的根证书池
+func startTLSServer(t *testing.T, cfg routers.Config) (string, *x509.CertPool) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	serverCert := ca.issue(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "authgate"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	key, err := x509.MarshalECPrivateKey(serverCert.PrivateKey.(*ecdsa.PrivateKey))
+	require.NoError(t, err)
+	cfg.TLS.Cert = filepath.Join(dir, "server.pem")
+	cfg.TLS.Key = filepath.Join(dir, "server.key")
+	writePEM(t, cfg.TLS.Cert, "CERTIFICATE", serverCert.Certificate[0])
+	writePEM(t, cfg.TLS.Key, "EC PRIVATE KEY", key)
+
+	tlsConfig, err := routers.ServerTLS(cfg)
+	require.NoError(t, err)
+	require.Equal(t, tls.RequestClientCert, tlsConfig.ClientAuth)
+
+	addr := freeAddr(t)
+	h := server.Default(server.WithHostPorts(addr), server.WithExitWaitTime(0), server.WithTLS(tlsConfig))
+	require.NoError(t, routers.RegisterRoutes(h, cfg))
+	go h.Spin()
+	t.Cleanup(func() {
+		// standard 传输层关闭时会等待 ctx 结束
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		h.Shutdown(ctx)
+	})
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+		}
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	return "https://" + addr, roots
+}
+
+func TestClientCertificate(t *testing.T) {
+	upstream := echoUpstream(t)
+	clientCA := newTestCA(t)
+	caFile := filepath.Join(t.TempDir(), "clients.pem")
+	writePEM(t, caFile, "CERTIFICATE", clientCA.cert.Raw)
+
+	cfg := loadTestConfig()
+	cfg.Routes.Backends = []routers.Backend{
+		{
+			Host:       "mtls.example.com",
+			UpStream:   []string{upstream.URL},
+			Identity:   routers.IdentityConfig{Headers: true},
+			ClientCert: routers.ClientCertConfig{CA: caFile},
+			Access: access.Config{Rules: []access.Rule{
+				{Action: access.ActionAllow, Groups: []string{"billing"}},
+			}},
+		},
+		{
+			Host:     "mapped.example.com",
+			UpStream: []string{upstream.URL},
+			Identity: routers.IdentityConfig{Headers: true},
+			ClientCert: routers.ClientCertConfig{
+				CA:       caFile,
+				Username: "email",
+				Users: []routers.ClientCertUser{
+					{Match: "ci@example.com", Username: "ci-bot", Groups: []string{"ci"}},
+				},
+			},
+		},
+		{
+			Host:     "plain.example.com",
+			UpStream: []string{upstream.URL},
+		},
+	}
+	base, roots := startTLSServer(t, cfg.Routes)
+
+	get := func(host string, cert *tls.Certificate) *http.Response {
+		tlsConfig := &tls.Config{RootCAs: roots}
+		if cert != nil {
+			tlsConfig.Certificates = []tls.Certificate{*cert}
+		}
+		client := &http.Client{
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+		req, err := http.NewRequest("GET", base+"/api", nil)
+		require.NoError(t, err)
+		req.Host = host
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+	upstreamHeaders := func(resp *http.Response) http.Header {
+		var headers http.Header
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&headers))
+		return headers
+	}
+
+	billing := clientCA.clientCert(t, "svc-billing", []string{"billing"})
+	reports := clientCA.clientCert(t, "svc-reports", []string{"reports"})
+	ci := clientCA.clientCert(t, "ci", nil, "ci@example.com")
+	untrusted := newTestCA(t).clientCert(t, "svc-billing", []string{"billing"})
+
+	t.Run("Verified certificate", func(t *testing.T) {
+		resp := get("mtls.example.com", &billing)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		headers := upstreamHeaders(resp)
+		assert.Equal(t, "svc-billing", headers.Get("X-Auth-User"))
+		assert.Equal(t, "billing", headers.Get("X-Auth-Groups"))
+	})
+
+	t.Run("Policy applies to certificate identity", func(t *testing.T) {
+		resp := get("mtls.example.com", &reports)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("SAN mapping", func(t *testing.T) {
+		resp := get("mapped.example.com", &ci)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		headers := upstreamHeaders(resp)
+		assert.Equal(t, "ci-bot", headers.Get("X-Auth-User"))
+		assert.Equal(t, "ci", headers.Get("X-Auth-Groups"))
+
+		// 不在映射中的证书
+		resp = get("mapped.example.com", &billing)
+		assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+	})
+
+	t.Run("Falls back to login", func(t *testing.T) {
+		for _, tt := range []struct {
+			host string
+			cert *tls.Certificate
+		}{
+			{"mtls.example.com", nil},
+			{"mtls.example.com", &untrusted},
+			// 未启用客户端证书认证的后端忽略证书
+			{"plain.example.com", &billing},
+		} {
+			resp := get(tt.host, tt.cert)
+			assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode, tt.host)
+		}
+	})
+}
+
+func TestClientCertificateConfig(t *testing.T) {
+	cfg := loadTestConfig()
+	cfg.Routes.Backends[0].ClientCert.CA = filepath.Join(t.TempDir(), "missing.pem")
+	_, err := routers.ServerTLS(cfg.Routes)
+	assert.ErrorContains(t, err, "tls.cert")
+	assert.Error(t, routers.RegisterRoutes(server.Default(), cfg.Routes))
+}
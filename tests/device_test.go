@@ -0,0 +1,184 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/cloudwego/hertz/pkg/app/server"
+	"github.com/cloudwego/hertz/pkg/common/ut"
+	"github.com/cloudwego/hertz/pkg/route"
+	"github.com/ipfans/authgate/passwd"
+	"github.com/ipfans/authgate/routers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type deviceCodeResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+type deviceTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	Error       string `json:"error"`
+}
+
+func setupDeviceServer(t *testing.T) *route.Engine {
+	cfg := loadTestConfig()
+	cfg.Routes.Device.Enabled = true
+	h := server.Default()
+	require.NoError(t, routers.RegisterRoutes(h, cfg.Routes))
+	return h.Engine
+}
+
+// startDevice 以命令行工具的身份申请 device_code
+func startDevice(t *testing.T, ts *route.Engine) deviceCodeResponse {
+	rec := ut.PerformRequest(ts, "POST", "/authgate/device/code", formBody(url.Values{"client_id": {"cli"}}),
+		hostHeader("auth.example.com"), formContentType)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var resp deviceCodeResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	return resp
+}
+
+// pollDevice 以命令行工具的身份轮询令牌
+func pollDevice(t *testing.T, ts *route.Engine, deviceCode string) (int, deviceTokenResponse) {
+	rec := ut.PerformRequest(ts, "POST", "/authgate/device/token", formBody(url.Values{
+		"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+		"device_code": {deviceCode},
+		"client_id":   {"cli"},
+	}), hostHeader("auth.example.com"), formContentType)
+	var resp deviceTokenResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	return rec.Code, resp
+}
+
+// submitDevice 在浏览器中打开确认页并提交 action
+func submitDevice(t *testing.T, ts *route.Engine, cookie, userCode, action string) *ut.ResponseRecorder {
+	rec := ut.PerformRequest(ts, "GET", "/authgate/device?user_code="+url.QueryEscape(userCode), nil,
+		hostHeader("auth.example.com"), cookieHeader(cookie))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	form := url.Values{}
+	for _, match := range hiddenInputPattern.FindAllStringSubmatch(rec.Body.String(), -1) {
+		form.Set(match[1], match[2])
+	}
+	form.Set("action", action)
+	csrf := "authgate_csrf=" + responseCookie(rec, "authgate_csrf")
+	return ut.PerformRequest(ts, "POST", "/authgate/device", formBody(form),
+		hostHeader("auth.example.com"), formContentType, cookieHeader(cookie, csrf))
+}
+
+func TestDeviceFlow(t *testing.T) {
+	ts := setupDeviceServer(t)
+
+	resp := startDevice(t, ts)
+	assert.NotEmpty(t, resp.DeviceCode)
+	assert.Regexp(t, `^[A-Z]{4}-[A-Z]{4}$`, resp.UserCode)
+	assert.Equal(t, "http://auth.example.com/authgate/device", resp.VerificationURI)
+	assert.Equal(t, 600, resp.ExpiresIn)
+	assert.Equal(t, 5, resp.Interval)
+
+	code, token := pollDevice(t, ts, resp.DeviceCode)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "authorization_pending", token.Error)
+	code, token = pollDevice(t, ts, resp.DeviceCode)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "slow_down", token.Error)
+
+	// 未登录时先跳转到登录页，登录后回到输入了 user_code 的页面
+	location, err := url.Parse(resp.VerificationURIComplete)
+	require.NoError(t, err)
+	rec := ut.PerformRequest(ts, "GET", location.RequestURI(), nil, hostHeader("auth.example.com"))
+	require.Equal(t, http.StatusFound, rec.Code)
+	login, err := url.Parse(rec.Header().Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, "/authgate/login", login.Path)
+	rec = passwordLogin(t, ts, "alice", "alicepass", login.Query().Get("state"))
+	require.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	assert.Equal(t, resp.VerificationURIComplete, rec.Header().Get("Location"))
+	cookie := "authgate_token=" + responseCookie(rec, "authgate_token")
+
+	rec = submitDevice(t, ts, cookie, resp.UserCode, "approve")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	code, token = pollDevice(t, ts, resp.DeviceCode)
+	require.Equal(t, http.StatusOK, code, token.Error)
+	assert.Equal(t, "Bearer", token.TokenType)
+	require.NotEmpty(t, token.AccessToken)
+
+	// device_code 只能兑换一次
+	code, token2 := pollDevice(t, ts, resp.DeviceCode)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "invalid_grant", token2.Error)
+
+	// 令牌与 Cookie 一样可以访问后端
+	rec = ut.PerformRequest(ts, "GET", "/api/data", nil, hostHeader("test.example.com"), bearerHeader(token.AccessToken))
+	assertProxied(t, rec)
+	rec = ut.PerformRequest(ts, "GET", "/authgate/verify", nil, hostHeader("authgate.internal"),
+		ut.Header{Key: "X-Forwarded-Host", Value: "test.example.com"}, bearerHeader(token.AccessToken))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "alice", rec.Header().Get("X-Auth-User"))
+}
+
+func TestDeviceFlowDenied(t *testing.T) {
+	ts := setupDeviceServer(t)
+	cookie := loginAs(t, ts, "alice", "alicepass")
+
+	resp := startDevice(t, ts)
+	rec := submitDevice(t, ts, cookie, resp.UserCode, "deny")
+	require.Equal(t, http.StatusOK, rec.Code)
+	code, token := pollDevice(t, ts, resp.DeviceCode)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "access_denied", token.Error)
+
+	// 已处理的 user_code 不能再次使用
+	rec = ut.PerformRequest(ts, "GET", "/authgate/device?user_code="+url.QueryEscape(resp.UserCode), nil,
+		hostHeader("auth.example.com"), cookieHeader(cookie))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = ut.PerformRequest(ts, "POST", "/authgate/device/token", formBody(url.Values{"grant_type": {"password"}}),
+		hostHeader("auth.example.com"), formContentType)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "unsupported_grant_type")
+}
+
+func TestDeviceFlowExternalUser(t *testing.T) {
+	hash, err := passwd.Hash("break-glass")
+	require.NoError(t, err)
+	cfg := loadTestConfig()
+	cfg.Routes.Device.Enabled = true
+	cfg.Routes.Authenticators = []routers.AuthenticatorConfig{
+		{Type: "static", Users: []routers.UserConfig{{Username: "root", PasswordHash: hash, Groups: []string{"admins"}}}},
+		{Type: "local"},
+	}
+	h := server.Default()
+	require.NoError(t, routers.RegisterRoutes(h, cfg.Routes))
+	ts := h.Engine
+
+	// 不在用户存储中的用户批准后，按批准时的登录信息签发令牌
+	cookie := loginAs(t, ts, "root", "break-glass")
+	resp := startDevice(t, ts)
+	rec := submitDevice(t, ts, cookie, resp.UserCode, "approve")
+	require.Equal(t, http.StatusOK, rec.Code)
+	code, token := pollDevice(t, ts, resp.DeviceCode)
+	require.Equal(t, http.StatusOK, code, token.Error)
+
+	rec = ut.PerformRequest(ts, "GET", "/authgate/verify", nil, hostHeader("authgate.internal"),
+		ut.Header{Key: "X-Forwarded-Host", Value: "test.example.com"}, bearerHeader(token.AccessToken))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "root", rec.Header().Get("X-Auth-User"))
+	assert.Equal(t, "admins", rec.Header().Get("X-Auth-Groups"))
+}
+
+func TestDeviceFlowDisabled(t *testing.T) {
+	ts := setupTestServer(t)
+	rec := ut.PerformRequest(ts, "POST", "/authgate/device/code", nil, hostHeader("auth.example.com"))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
@@ -0,0 +1,125 @@
+package tests
+
+import (
+	"html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/cloudwego/hertz/pkg/common/ut"
+	"github.com/cloudwego/hertz/pkg/protocol"
+	"github.com/cloudwego/hertz/pkg/route"
+	"github.com/stretchr/testify/require"
+)
+
+// responseCookie 从响应中读取指定 Cookie 的值
+func responseCookie(rec *ut.ResponseRecorder, name string) string {
+	value := ""
+	rec.Header().VisitAllCookie(func(key, raw []byte) {
+		if string(key) != name {
+			return
+		}
+		cookie := protocol.AcquireCookie()
+		defer protocol.ReleaseCookie(cookie)
+		if err := cookie.ParseBytes(raw); err == nil {
+			value = string(cookie.Value())
+		}
+	})
+	return value
+}
+
+// formBody 把表单编码为请求体
+func formBody(values url.Values) *ut.Body {
+	encoded := values.Encode()
+	return &ut.Body{
+		Body: strings.NewReader(encoded),
+		Len:  len(encoded),
+	}
+}
+
+var formContentType = ut.Header{
+	Key:   "Content-Type",
+	Value: "application/x-www-form-urlencoded",
+}
+
+func hostHeader(host string) ut.Header {
+	return ut.Header{Key: "Host", Value: host}
+}
+
+func cookieHeader(cookies ...string) ut.Header {
+	return ut.Header{Key: "Cookie", Value: strings.Join(cookies, "; ")}
+}
+
+var hiddenInputPattern = regexp.MustCompile(`type="hidden" name="([a-z_]+)" value="([^"]*)"`)
+
+// beginLogin 访问受保护域名触发登录跳转，返回 state 和受保护域名上的 state Cookie
+func beginLogin(t *testing.T, ts *route.Engine, host string) (state, cookie string) {
+	rec := ut.PerformRequest(ts, "GET", "/", nil, hostHeader(host))
+	require.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	location, err := url.Parse(rec.Header().Get("Location"))
+	require.NoError(t, err)
+	state = location.Query().Get("state")
+	require.NotEmpty(t, state)
+	nonce := responseCookie(rec, "authgate_state")
+	require.NotEmpty(t, nonce)
+	return state, "authgate_state=" + nonce
+}
+
+// openLoginForm 打开认证域名上的登录页，返回表单中的隐藏字段和 CSRF Cookie
+func openLoginForm(t *testing.T, ts *route.Engine, state string) (url.Values, string) {
+	rec := ut.PerformRequest(ts, "GET", "/login?state="+url.QueryEscape(state), nil, hostHeader("auth.example.com"))
+	require.Equal(t, http.StatusOK, rec.Code)
+	form := url.Values{}
+	for _, match := range hiddenInputPattern.FindAllStringSubmatch(rec.Body.String(), -1) {
+		form.Set(match[1], html.UnescapeString(match[2]))
+	}
+	require.NotEmpty(t, form.Get("csrf_token"))
+	return form, "authgate_csrf=" + responseCookie(rec, "authgate_csrf")
+}
+
+// postLogin 提交登录表单
+func postLogin(ts *route.Engine, form url.Values, csrfCookie string, headers ...ut.Header) *ut.ResponseRecorder {
+	headers = append([]ut.Header{hostHeader("auth.example.com"), formContentType, cookieHeader(csrfCookie)}, headers...)
+	return ut.PerformRequest(ts, "POST", "/login", formBody(form), headers...)
+}
+
+// passwordLogin 打开登录页并提交用户名和密码
+func passwordLogin(t *testing.T, ts *route.Engine, username, password, state string) *ut.ResponseRecorder {
+	form, csrf := openLoginForm(t, ts, state)
+	form.Set("username", username)
+	form.Set("password", password)
+	return postLogin(ts, form, csrf)
+}
+
+// loginAs 在认证域名上使用密码登录并返回认证域名上的 Cookie
+func loginAs(t *testing.T, ts *route.Engine, username, password string) string {
+	rec := passwordLogin(t, ts, username, password, "")
+	require.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	token := responseCookie(rec, "authgate_token")
+	require.NotEmpty(t, token)
+	return "authgate_token=" + token
+}
+
+// forwardAuth 以前置代理的身份调用转发认证接口，校验访问 host 的请求
+func forwardAuth(ts *route.Engine, host string, headers ...ut.Header) *ut.ResponseRecorder {
+	headers = append([]ut.Header{
+		hostHeader("authgate.internal"),
+		{Key: "X-Forwarded-Host", Value: host},
+	}, headers...)
+	return ut.PerformRequest(ts, "GET", "/authgate/verify", nil, headers...)
+}
+
+// logout 打开 host 上的确认注销页面并提交表单
+func logout(t *testing.T, ts *route.Engine, host, cookie string) *ut.ResponseRecorder {
+	rec := ut.PerformRequest(ts, "GET", "/authgate/logout", nil, hostHeader(host), cookieHeader(cookie))
+	require.Equal(t, http.StatusOK, rec.Code)
+	match := hiddenInputPattern.FindStringSubmatch(rec.Body.String())
+	require.NotNil(t, match)
+	require.Equal(t, "csrf_token", match[1])
+	form := url.Values{"csrf_token": {html.UnescapeString(match[2])}}
+	csrf := "authgate_csrf=" + responseCookie(rec, "authgate_csrf")
+	return ut.PerformRequest(ts, "POST", "/authgate/logout", formBody(form),
+		hostHeader(host), formContentType, cookieHeader(cookie, csrf))
+}
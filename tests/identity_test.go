@@ -0,0 +1,133 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app/server"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ipfans/authgate/access"
+	"github.com/ipfans/authgate/routers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// freeAddr 返回一个空闲的本地地址
+func freeAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+// startServer 启动真实监听的 AuthGate，用于需要经过反向代理的测试
+func startServer(t *testing.T, cfg routers.Config) string {
+	addr := freeAddr(t)
+	h := server.Default(server.WithHostPorts(addr), server.WithExitWaitTime(0))
+	require.NoError(t, routers.RegisterRoutes(h, cfg))
+	go h.Spin()
+	t.Cleanup(func() {
+		h.Shutdown(context.Background())
+	})
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+		}
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	return "http://" + addr
+}
+
+// echoUpstream 返回收到的请求头
+func echoUpstream(t *testing.T) *httptest.Server {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(r.Header)
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream
+}
+
+func TestIdentityInjection(t *testing.T) {
+	upstream := echoUpstream(t)
+	cfg := loadTestConfig()
+	cfg.Routes.Backends = []routers.Backend{
+		{
+			Host:     "app.example.com",
+			UpStream: []string{upstream.URL},
+			Identity: routers.IdentityConfig{Headers: true, Assertion: true},
+			Access: access.Config{Rules: []access.Rule{
+				{Action: access.ActionPublic, Paths: []string{"/public/"}},
+			}},
+		},
+		{
+			Host:     "plain.example.com",
+			UpStream: []string{upstream.URL},
+		},
+	}
+	base := startServer(t, cfg.Routes)
+	h := server.Default()
+	require.NoError(t, routers.RegisterRoutes(h, cfg.Routes))
+	cookie := loginAs(t, h.Engine, "alice", "alicepass")
+
+	spoofed := map[string]string{
+		"X-Auth-User":          "mallory",
+		"X-Auth-Email":         "mallory@example.com",
+		"X-Auth-Groups":        "admin",
+		"X-AuthGate-Assertion": "forged",
+	}
+	fetch := func(host, path string, withCookie bool) http.Header {
+		req, err := http.NewRequest("GET", base+path, nil)
+		require.NoError(t, err)
+		req.Host = host
+		req.Close = true
+		for k, v := range spoofed {
+			req.Header.Set(k, v)
+		}
+		if withCookie {
+			req.Header.Set("Cookie", cookie)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var received http.Header
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&received))
+		return received
+	}
+
+	t.Run("headers and assertion", func(t *testing.T) {
+		received := fetch("app.example.com", "/", true)
+		assert.Equal(t, "alice", received.Get("X-Auth-User"))
+		assert.Equal(t, "alice@example.com", received.Get("X-Auth-Email"))
+		assert.Equal(t, "admin,dev", received.Get("X-Auth-Groups"))
+
+		claims := &routers.AssertionClaims{}
+		_, err := jwt.ParseWithClaims(received.Get("X-AuthGate-Assertion"), claims, func(*jwt.Token) (interface{}, error) {
+			return []byte("test_secret"), nil
+		}, jwt.WithAudience("app.example.com"), jwt.WithIssuer("http://auth.example.com"))
+		require.NoError(t, err)
+		assert.Equal(t, "alice", claims.Subject)
+		assert.Equal(t, []string{"admin", "dev"}, claims.Groups)
+		assert.WithinDuration(t, time.Now().Add(time.Minute), claims.ExpiresAt.Time, 2*time.Second)
+	})
+
+	t.Run("public path strips spoofed headers", func(t *testing.T) {
+		received := fetch("app.example.com", "/public/index.html", false)
+		for k := range spoofed {
+			assert.Empty(t, received.Get(k), k)
+		}
+	})
+
+	t.Run("identity disabled strips spoofed headers", func(t *testing.T) {
+		received := fetch("plain.example.com", "/", true)
+		for k := range spoofed {
+			assert.Empty(t, received.Get(k), k)
+		}
+	})
+}
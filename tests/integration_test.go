@@ -1,9 +1,12 @@
 package tests
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -12,6 +15,8 @@ import (
 	"github.com/cloudwego/hertz/pkg/route"
 	"github.com/ipfans/authgate/config"
 	"github.com/ipfans/authgate/routers"
+	"github.com/ipfans/authgate/session"
+	"github.com/ipfans/authgate/store"
 	"github.com/ipfans/components/v2/configuration"
 	"github.com/knadh/koanf/parsers/yaml"
 	"github.com/knadh/koanf/providers/rawbytes"
@@ -36,6 +41,20 @@ routes:
   credential:
     username: "testuser"
     password: "testpass"
+  users:
+    - username: "alice"
+      # alicepass
+      password_hash: "$2a$04$Qyxq4MX2nP/gc6brmzmUv.PweBjoFYUsik3Qz91pkNEdigAMzqKsO"
+      email: "alice@example.com"
+      groups: ["admin", "dev"]
+  # ut 中连接的对端地址为 0.0.0.0
+  trusted_proxies: ["0.0.0.0"]
+  verify_hosts: ["authgate.internal"]
+  redirect_allowlist:
+    - "app.example.com"
+    - "https://nginx.example.com"
+    - "plain.example.com"
+    - "*.example.net"
   backends:
     - host: "test.example.com"
       load_balance: "round_robin"
@@ -73,7 +92,8 @@ func TestIntegration(t *testing.T) {
 			Value: "test.example.com",
 		})
 		assert.Equal(t, http.StatusTemporaryRedirect, rec.Code)
-		assert.Equal(t, "http://auth.example.com/authgate/login?host=http%3A%2F%2Ftest.example.com", rec.Header().Get("Location"))
+		assert.True(t, strings.HasPrefix(rec.Header().Get("Location"), "http://auth.example.com/authgate/login?host=http%3A%2F%2Ftest.example.com&state="))
+		assert.NotEmpty(t, responseCookie(rec, "authgate_state"))
 		rec = ut.PerformRequest(ts, "GET", "/api/protected", nil, ut.Header{
 			Key:   "Host",
 			Value: "test.example.com",
@@ -82,62 +102,58 @@ func TestIntegration(t *testing.T) {
 			Value: "https",
 		})
 		assert.Equal(t, http.StatusTemporaryRedirect, rec.Code)
-		assert.Equal(t, "http://auth.example.com/authgate/login?host=https%3A%2F%2Ftest.example.com", rec.Header().Get("Location"))
+		assert.True(t, strings.HasPrefix(rec.Header().Get("Location"), "http://auth.example.com/authgate/login?host=https%3A%2F%2Ftest.example.com&state="))
 	})
 
 	t.Run("Login failed", func(t *testing.T) {
-		formData := map[string][]string{
-			"username": {"admin"},
-			"password": {"admin"},
-			"host":     {"http://test.example.com"},
-		}
-		query := url.Values(formData)
-		length := len(query.Encode())
-		body := &ut.Body{
-			Body: strings.NewReader(query.Encode()),
-			Len:  length,
-		}
-		rec := ut.PerformRequest(ts, "POST", "/login", body, ut.Header{
-			Key:   "Host",
-			Value: "auth.example.com",
-		})
+		form, csrf := openLoginForm(t, ts, "")
+		form.Set("username", "admin")
+		form.Set("password", "admin")
+		rec := postLogin(ts, form, csrf)
 		assert.Equal(t, http.StatusUnauthorized, rec.Code)
 	})
 
+	t.Run("Login without CSRF token", func(t *testing.T) {
+		_, csrf := openLoginForm(t, ts, "")
+		rec := postLogin(ts, url.Values{
+			"username": {"testuser"},
+			"password": {"testpass"},
+		}, csrf)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	})
+
 	// 测试登录
 	t.Run("Login", func(t *testing.T) {
 		var rec *ut.ResponseRecorder
 		// 测试登录，账号密码正确
-		formData := map[string][]string{
-			"username": {"testuser"},
-			"password": {"testpass"},
-			"host":     {"http://test.example.com"},
-		}
-		query := url.Values(formData)
-		body := &ut.Body{
-			Body: strings.NewReader(query.Encode()),
-			Len:  len(query.Encode()),
-		}
-		rec = ut.PerformRequest(ts, "POST", "/login", body, ut.Header{
-			Key:   "Host",
-			Value: "auth.example.com",
-		}, ut.Header{
+		state, stateCookie := beginLogin(t, ts, "test.example.com")
+		form, csrf := openLoginForm(t, ts, state)
+		assert.Equal(t, state, form.Get("state"))
+		form.Set("username", "testuser")
+		form.Set("password", "testpass")
+		rec = postLogin(ts, form, csrf, ut.Header{
 			Key:   "X-Forwarded-Proto",
 			Value: "https",
-		}, ut.Header{
-			Key:   "Content-Type",
-			Value: "application/x-www-form-urlencoded",
 		})
 		require.Equal(t, http.StatusTemporaryRedirect, rec.Code)
-		assert.Contains(t, rec.Header().Get("Location"), "http://test.example.com/authgate/login/finish?token=eyJh")
+		assert.Contains(t, rec.Header().Get("Location"), "http://test.example.com/authgate/login/finish?code=")
 
-		// 测试完成登录
+		// 地址中只有一次性授权码，不包含 JWT
 		loc, _ := url.Parse(rec.Header().Get("Location"))
-		token := loc.Query().Get("token")
-		rec = ut.PerformRequest(ts, "GET", "/authgate/login/finish?token="+url.QueryEscape(token), nil, ut.Header{
+		assert.NotContains(t, loc.Query().Get("code"), ".")
+
+		// 其他浏览器不能完成登录
+		finish := "/authgate/login/finish?" + loc.RawQuery
+		rec = ut.PerformRequest(ts, "GET", finish, nil, hostHeader("test.example.com"))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		rec = ut.PerformRequest(ts, "GET", finish, nil, hostHeader("other.example.com"), cookieHeader(stateCookie))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+		// 测试完成登录
+		rec = ut.PerformRequest(ts, "GET", finish, nil, ut.Header{
 			Key:   "Host",
 			Value: "test.example.com",
-		})
+		}, cookieHeader(stateCookie))
 		assert.Equal(t, http.StatusTemporaryRedirect, rec.Code)
 		assert.Equal(t, "/", rec.Header().Get("Location"))
 		cookies := rec.Result().Header.GetCookies()
@@ -161,6 +177,10 @@ func TestIntegration(t *testing.T) {
 		assert.True(t, containsCookie, "authgate_token cookie not found")
 		assert.NotEmpty(t, jwtToken, "jwt token not found")
 
+		// 授权码只能使用一次
+		rec = ut.PerformRequest(ts, "GET", finish, nil, hostHeader("test.example.com"), cookieHeader(stateCookie))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+
 		// 测试使用token访问受保护资源
 		rec = ut.PerformRequest(ts, "GET", "/api/protected", nil, ut.Header{
 			Key:   "Host",
@@ -172,3 +192,74 @@ func TestIntegration(t *testing.T) {
 		assert.Equal(t, http.StatusNotFound, rec.Code)
 	})
 }
+
+func TestHashedPasswordLogin(t *testing.T) {
+	ts := setupTestServer(t)
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		want     int
+	}{
+		{"bcrypt user", "alice", "alicepass", http.StatusTemporaryRedirect},
+		{"wrong password", "alice", "testpass", http.StatusUnauthorized},
+		{"hash is not a password", "alice", "$2a$04$Qyxq4MX2nP/gc6brmzmUv.PweBjoFYUsik3Qz91pkNEdigAMzqKsO", http.StatusUnauthorized},
+		{"unknown user", "mallory", "alicepass", http.StatusUnauthorized},
+		{"legacy credential", "testuser", "testpass", http.StatusTemporaryRedirect},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := passwordLogin(t, ts, tt.username, tt.password, "")
+			assert.Equal(t, tt.want, rec.Code)
+		})
+	}
+}
+
+func TestHtpasswd(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	require.NoError(t, os.WriteFile(path, []byte("bob:$2y$04$nHgUiXLCESMhPO3DNF8pne8NeUu6/SdgQUrbzY5qRZp65BAVUkqpK\n"), 0o600))
+	cfg := loadTestConfig()
+	cfg.Routes.Htpasswd = path
+
+	h := server.Default()
+	require.NoError(t, routers.RegisterRoutes(h, cfg.Routes))
+	rec := passwordLogin(t, h.Engine, "bob", "bobpass", "")
+	assert.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+
+	// 不支持的哈希格式在启动时报错
+	require.NoError(t, os.WriteFile(path, []byte("bob:bobpass\n"), 0o600))
+	assert.Error(t, routers.RegisterRoutes(server.Default(), cfg.Routes))
+}
+
+func TestRemovedConfigUser(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "htpasswd")
+	require.NoError(t, os.WriteFile(path, []byte("bob:$2y$04$nHgUiXLCESMhPO3DNF8pne8NeUu6/SdgQUrbzY5qRZp65BAVUkqpK\n"), 0o600))
+	cfg := loadTestConfig()
+	cfg.Routes.Htpasswd = path
+	cfg.Routes.Store = store.Config{Type: "bolt", Path: filepath.Join(dir, "users.db")}
+	cfg.Routes.Session = session.Config{Type: "bolt", Path: filepath.Join(dir, "sessions.db")}
+	// start 启动服务，返回的函数关闭存储以便重启时重新打开
+	start := func() (*route.Engine, func()) {
+		h := server.Default()
+		require.NoError(t, routers.RegisterRoutes(h, cfg.Routes))
+		return h.Engine, func() {
+			for _, hook := range h.OnShutdown {
+				hook(context.Background())
+			}
+		}
+	}
+
+	ts, stop := start()
+	cookie := loginAs(t, ts, "bob", "bobpass")
+	stop()
+
+	// 从 htpasswd 中删除后重启，存储中的密码哈希和会话都不再有效
+	require.NoError(t, os.WriteFile(path, nil, 0o600))
+	ts, stop = start()
+	defer stop()
+	assert.Equal(t, http.StatusUnauthorized, passwordLogin(t, ts, "bob", "bobpass", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, forwardAuth(ts, "test.example.com", cookieHeader(cookie)).Code)
+	assert.Equal(t, http.StatusTemporaryRedirect, passwordLogin(t, ts, "alice", "alicepass", "").Code)
+}
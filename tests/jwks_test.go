@@ -0,0 +1,90 @@
+package tests
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cloudwego/hertz/pkg/app/server"
+	"github.com/cloudwego/hertz/pkg/common/ut"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ipfans/authgate/jwk"
+	"github.com/ipfans/authgate/keyring"
+	"github.com/ipfans/authgate/routers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeECKey 生成 P-256 私钥并写入 PEM 文件
+func writeECKey(t *testing.T, dir, name string) string {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	require.NoError(t, err)
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600))
+	return path
+}
+
+func TestJWKSRotation(t *testing.T) {
+	dir := t.TempDir()
+	oldKey := writeECKey(t, dir, "old.pem")
+	newKey := writeECKey(t, dir, "new.pem")
+
+	cfg := loadTestConfig()
+	cfg.Routes.JWTKeys = keyring.Config{
+		Keys: []keyring.KeyConfig{{ID: "old", Algorithm: "ES256", PrivateKey: oldKey}},
+	}
+	h := server.Default()
+	require.NoError(t, routers.RegisterRoutes(h, cfg.Routes))
+	cookie := loginAs(t, h.Engine, "alice", "alicepass")
+	token := strings.TrimPrefix(cookie, "authgate_token=")
+
+	// 轮换：新密钥签名，旧密钥仅用于校验
+	cfg.Routes.JWTKeys = keyring.Config{
+		SigningKey: "new",
+		Keys: []keyring.KeyConfig{
+			{ID: "old", Algorithm: "ES256", PrivateKey: oldKey},
+			{ID: "new", Algorithm: "ES256", PrivateKey: newKey},
+		},
+	}
+	h = server.Default()
+	require.NoError(t, routers.RegisterRoutes(h, cfg.Routes))
+	ts := h.Engine
+
+	rec := ut.PerformRequest(ts, "GET", "/.well-known/jwks.json", nil, hostHeader("auth.example.com"))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var set jwk.Set
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &set))
+	require.Len(t, set.Keys, 2)
+	keys := make(map[string]jwk.Key, len(set.Keys))
+	for _, k := range set.Keys {
+		keys[k.Kid] = k
+	}
+
+	// 旧令牌在轮换后仍然有效
+	rec = forwardAuth(ts, "test.example.com", cookieHeader(cookie))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// 新令牌使用新密钥签名，可以用 JWKS 中的公钥校验
+	newCookie := loginAs(t, ts, "alice", "alicepass")
+	parsed, err := jwt.Parse(strings.TrimPrefix(newCookie, "authgate_token="), func(tok *jwt.Token) (interface{}, error) {
+		assert.Equal(t, "new", tok.Header["kid"])
+		return keys[tok.Header["kid"].(string)].PublicKey()
+	}, jwt.WithValidMethods([]string{"ES256"}))
+	require.NoError(t, err)
+	assert.True(t, parsed.Valid)
+
+	_, err = jwt.Parse(token, func(tok *jwt.Token) (interface{}, error) {
+		return keys[tok.Header["kid"].(string)].PublicKey()
+	}, jwt.WithValidMethods([]string{"ES256"}))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,70 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/cloudwego/hertz/pkg/app/server"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ipfans/authgate/authn"
+	"github.com/ipfans/authgate/authn/ldaptest"
+	"github.com/ipfans/authgate/routers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLDAPLogin(t *testing.T) {
+	directory := ldaptest.NewServer(
+		ldaptest.Entry{DN: "cn=authgate,dc=example,dc=com", Password: "service-secret"},
+		ldaptest.Entry{
+			DN:         "uid=dave,ou=people,dc=example,dc=com",
+			Password:   "dave-ldap",
+			Attributes: map[string][]string{"uid": {"dave"}, "mail": {"dave@example.com"}},
+		},
+		ldaptest.Entry{
+			DN:         "cn=ops,ou=groups,dc=example,dc=com",
+			Attributes: map[string][]string{"cn": {"ops"}, "member": {"uid=dave,ou=people,dc=example,dc=com"}},
+		},
+	)
+	defer directory.Close()
+
+	cfg := loadTestConfig()
+	cfg.Routes.Authenticator = "ldap"
+	cfg.Routes.LDAP = authn.LDAPConfig{
+		URL:          directory.URL(),
+		BindDN:       "cn=authgate,dc=example,dc=com",
+		BindPassword: "service-secret",
+		BaseDN:       "dc=example,dc=com",
+	}
+	h := server.Default()
+	require.NoError(t, routers.RegisterRoutes(h, cfg.Routes))
+	ts := h.Engine
+
+	rec := passwordLogin(t, ts, "dave", "dave-ldap", "")
+	require.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(responseCookie(rec, "authgate_token"), claims, func(*jwt.Token) (interface{}, error) {
+		return []byte("test_secret"), nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "dave", claims["username"])
+	assert.Equal(t, "dave@example.com", claims["email"])
+	assert.Equal(t, []interface{}{"ops"}, claims["groups"])
+
+	rec = passwordLogin(t, ts, "dave", "wrong", "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	// 使用 LDAP 时不再接受本地配置的账号
+	rec = passwordLogin(t, ts, "alice", "alicepass", "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// 目录服务不可用时不能当作密码错误
+	directory.Close()
+	rec = passwordLogin(t, ts, "dave", "dave-ldap", "")
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+}
+
+func TestUnknownAuthenticator(t *testing.T) {
+	cfg := loadTestConfig()
+	cfg.Routes.Authenticator = "kerberos"
+	assert.Error(t, routers.RegisterRoutes(server.Default(), cfg.Routes))
+}
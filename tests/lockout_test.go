@@ -0,0 +1,137 @@
+package tests
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app/server"
+	"github.com/cloudwego/hertz/pkg/common/ut"
+	"github.com/ipfans/authgate/lockout"
+	"github.com/ipfans/authgate/routers"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoginLockout(t *testing.T) {
+	var logs bytes.Buffer
+	logger := log.Logger
+	log.Logger = zerolog.New(&logs)
+	defer func() { log.Logger = logger }()
+
+	cfg := loadTestConfig()
+	cfg.Routes.Lockout = lockout.Config{
+		User: lockout.Policy{MaxAttempts: 3, BaseDelay: time.Minute},
+		IP:   lockout.Policy{MaxAttempts: 5, BaseDelay: time.Minute},
+	}
+	cfg.Routes.TrustedProxies = []string{"0.0.0.0/0"}
+	h := server.Default()
+	require.NoError(t, routers.RegisterRoutes(h, cfg.Routes))
+	ts := h.Engine
+
+	form, csrf := openLoginForm(t, ts, "")
+	login := func(ip, username, password string) *ut.ResponseRecorder {
+		form.Set("username", username)
+		form.Set("password", password)
+		return postLogin(ts, form, csrf, ut.Header{Key: "X-Forwarded-For", Value: ip})
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, login("10.0.0.1", "alice", "wrong").Code)
+	assert.Equal(t, http.StatusUnauthorized, login("10.0.0.2", "alice", "wrong").Code)
+	rec := login("10.0.0.3", "alice", "wrong")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "60", rec.Header().Get("Retry-After"))
+
+	// 锁定期间即使密码正确也拒绝
+	rec = login("10.0.0.4", "alice", "alicepass")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+	// 其他用户不受影响
+	assert.Equal(t, http.StatusTemporaryRedirect, login("10.0.0.4", "testuser", "testpass").Code)
+
+	// 同一 IP 尝试多个用户名时按 IP 锁定
+	for _, username := range []string{"u1", "u2", "u3", "u4"} {
+		assert.Equal(t, http.StatusUnauthorized, login("10.0.0.9", username, "wrong").Code)
+	}
+	assert.Equal(t, http.StatusTooManyRequests, login("10.0.0.9", "u5", "wrong").Code)
+	assert.Equal(t, http.StatusTooManyRequests, login("10.0.0.9", "testuser", "testpass").Code)
+
+	// 审计日志
+	out := logs.String()
+	assert.Contains(t, out, `"audit":"login_failed","ip":"10.0.0.1","username":"alice","reason":"bad_password"`)
+	assert.Contains(t, out, `"audit":"login_failed","ip":"10.0.0.9","username":"u1","reason":"unknown_user"`)
+	assert.Contains(t, out, `"audit":"login_locked","ip":"10.0.0.4","username":"alice"`)
+	assert.Contains(t, out, `"audit":"login_succeeded","ip":"10.0.0.4","username":"testuser","amr":["pwd"]`)
+	assert.NotContains(t, out, "alicepass")
+}
+
+func TestConcurrentLoginLockout(t *testing.T) {
+	var logs bytes.Buffer
+	logger := log.Logger
+	log.Logger = zerolog.New(zerolog.SyncWriter(&logs))
+	defer func() { log.Logger = logger }()
+
+	cfg := loadTestConfig()
+	cfg.Routes.Lockout = lockout.Config{
+		User: lockout.Policy{MaxAttempts: 3, BaseDelay: time.Minute},
+		IP:   lockout.Policy{MaxAttempts: 5, BaseDelay: time.Minute},
+	}
+	cfg.Routes.TrustedProxies = []string{"0.0.0.0/0"}
+	h := server.Default()
+	require.NoError(t, routers.RegisterRoutes(h, cfg.Routes))
+	ts := h.Engine
+
+	form, csrf := openLoginForm(t, ts, "")
+	login := func(ip, username, password string) int {
+		form := url.Values{"csrf_token": {form.Get("csrf_token")}, "username": {username}, "password": {password}}
+		return postLogin(ts, form, csrf, ut.Header{Key: "X-Forwarded-For", Value: ip}).Code
+	}
+
+	// 同时提交的错误密码在校验前就已计数，只有 3 次会被校验，其余直接返回 429
+	codes := make([]int, 20)
+	var wg sync.WaitGroup
+	for i := range codes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i] = login(fmt.Sprintf("10.0.1.%d", i), "alice", "wrong")
+		}()
+	}
+	wg.Wait()
+	for _, code := range codes {
+		assert.Contains(t, []int{http.StatusUnauthorized, http.StatusTooManyRequests}, code)
+	}
+	assert.Equal(t, 3, strings.Count(logs.String(), `"reason":"bad_password"`))
+	assert.Equal(t, 17, strings.Count(logs.String(), `"audit":"login_locked"`))
+
+	// 成功的登录不占用 IP 的次数
+	for i := 0; i < 10; i++ {
+		require.Equal(t, http.StatusTemporaryRedirect, login("10.0.0.1", "testuser", "testpass"))
+	}
+}
+
+func TestUntrustedForwardedFor(t *testing.T) {
+	cfg := loadTestConfig()
+	cfg.Routes.Lockout = lockout.Config{IP: lockout.Policy{MaxAttempts: 2, BaseDelay: time.Minute}}
+	cfg.Routes.TrustedProxies = nil
+	h := server.Default()
+	require.NoError(t, routers.RegisterRoutes(h, cfg.Routes))
+
+	// 未配置可信代理时忽略 X-Forwarded-For，伪造来源 IP 无法绕过按 IP 的锁定
+	form, csrf := openLoginForm(t, h.Engine, "")
+	codes := make([]int, 0, 3)
+	for i, username := range []string{"u1", "u2", "u3"} {
+		form.Set("username", username)
+		form.Set("password", "wrong")
+		rec := postLogin(h.Engine, form, csrf, ut.Header{Key: "X-Forwarded-For", Value: "10.0.1." + string(rune('1'+i))})
+		codes = append(codes, rec.Code)
+	}
+	assert.Equal(t, []int{http.StatusUnauthorized, http.StatusTooManyRequests, http.StatusTooManyRequests}, codes)
+}
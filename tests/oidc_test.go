@@ -0,0 +1,154 @@
+package tests
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/cloudwego/hertz/pkg/app/server"
+	"github.com/cloudwego/hertz/pkg/common/ut"
+	"github.com/ipfans/authgate/oidc/oidctest"
+	"github.com/ipfans/authgate/routers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOIDCLogin(t *testing.T) {
+	idp := oidctest.NewServer()
+	defer idp.Close()
+
+	cfg := loadTestConfig()
+	cfg.Routes.OIDC.Issuer = idp.URL
+	cfg.Routes.OIDC.ClientID = "authgate"
+	h := server.Default()
+	require.NoError(t, routers.RegisterRoutes(h, cfg.Routes))
+	ts := h.Engine
+	authHost := hostHeader("auth.example.com")
+
+	state, stateCookie := beginLogin(t, ts, "test.example.com")
+	rec := ut.PerformRequest(ts, "GET", "/authgate/oidc/login?state="+url.QueryEscape(state), nil, authHost)
+	require.Equal(t, http.StatusFound, rec.Code)
+	authURL := rec.Header().Get("Location")
+	assert.Contains(t, authURL, idp.URL+"/authorize?")
+	assert.Contains(t, authURL, url.QueryEscape("http://auth.example.com/authgate/oidc/callback"))
+	browser := cookieHeader("authgate_oidc=" + responseCookie(rec, "authgate_oidc"))
+
+	callback, err := idp.Authorize(authURL)
+	require.NoError(t, err)
+	cb, err := url.Parse(callback)
+	require.NoError(t, err)
+
+	rec = ut.PerformRequest(ts, "GET", cb.RequestURI(), nil, authHost, browser)
+	require.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	finish, err := url.Parse(rec.Header().Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, "test.example.com", finish.Host)
+	assert.Equal(t, "/authgate/login/finish", finish.Path)
+
+	// 重放回调
+	rec = ut.PerformRequest(ts, "GET", cb.RequestURI(), nil, authHost, browser)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = ut.PerformRequest(ts, "GET", finish.RequestURI(), nil, hostHeader("test.example.com"), cookieHeader(stateCookie))
+	require.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	cookie := "authgate_token=" + responseCookie(rec, "authgate_token")
+
+	// 上游声明映射到 AuthGate 的 JWT 中
+	rec = forwardAuth(ts, "test.example.com", cookieHeader(cookie))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "oidc:user-1", rec.Header().Get("X-Auth-User"))
+	assert.Equal(t, "oidc-user@example.com", rec.Header().Get("X-Auth-Email"))
+	assert.Equal(t, "staff", rec.Header().Get("X-Auth-Groups"))
+}
+
+func TestOIDCCallbackFromOtherBrowser(t *testing.T) {
+	idp := oidctest.NewServer()
+	defer idp.Close()
+
+	cfg := loadTestConfig()
+	cfg.Routes.OIDC.Issuer = idp.URL
+	cfg.Routes.OIDC.ClientID = "authgate"
+	h := server.Default()
+	require.NoError(t, routers.RegisterRoutes(h, cfg.Routes))
+	ts := h.Engine
+	authHost := hostHeader("auth.example.com")
+
+	// begin 发起登录并在身份提供方完成授权，返回回调地址和发起登录的浏览器 Cookie
+	begin := func() (string, ut.Header) {
+		rec := ut.PerformRequest(ts, "GET", "/authgate/oidc/login", nil, authHost)
+		require.Equal(t, http.StatusFound, rec.Code)
+		callback, err := idp.Authorize(rec.Header().Get("Location"))
+		require.NoError(t, err)
+		cb, err := url.Parse(callback)
+		require.NoError(t, err)
+		return cb.RequestURI(), cookieHeader("authgate_oidc=" + responseCookie(rec, "authgate_oidc"))
+	}
+
+	// 攻击者发起登录并在回调前停下，把回调链接发给没有 Cookie 的受害者
+	callback, _ := begin()
+	rec := ut.PerformRequest(ts, "GET", callback, nil, authHost)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Empty(t, responseCookie(rec, "authgate_token"))
+
+	// 受害者自己发起过登录，Cookie 与攻击者的不同
+	callback, _ = begin()
+	_, victim := begin()
+	rec = ut.PerformRequest(ts, "GET", callback, nil, authHost, victim)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Empty(t, responseCookie(rec, "authgate_token"))
+
+	// 伪造的 Cookie 同样被拒绝
+	callback, _ = begin()
+	rec = ut.PerformRequest(ts, "GET", callback, nil, authHost, cookieHeader("authgate_oidc=forged"))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestOIDCUserIsNotLocalAccount(t *testing.T) {
+	idp := oidctest.NewServer()
+	defer idp.Close()
+	// 身份提供方中的用户把 preferred_username 设置成本地账号 alice
+	idp.SetClaims(map[string]interface{}{"preferred_username": "alice"})
+
+	cfg := loadTestConfig()
+	cfg.Routes.OIDC.Issuer = idp.URL
+	cfg.Routes.OIDC.ClientID = "authgate"
+	cfg.Routes.OIDC.UsernameClaim = "preferred_username"
+	h := server.Default()
+	require.NoError(t, routers.RegisterRoutes(h, cfg.Routes))
+	ts := h.Engine
+	authHost := hostHeader("auth.example.com")
+
+	rec := ut.PerformRequest(ts, "GET", "/authgate/oidc/login", nil, authHost)
+	require.Equal(t, http.StatusFound, rec.Code)
+	browser := cookieHeader("authgate_oidc=" + responseCookie(rec, "authgate_oidc"))
+	callback, err := idp.Authorize(rec.Header().Get("Location"))
+	require.NoError(t, err)
+	cb, err := url.Parse(callback)
+	require.NoError(t, err)
+	rec = ut.PerformRequest(ts, "GET", cb.RequestURI(), nil, authHost, browser)
+	require.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	session := cookieHeader("authgate_token=" + responseCookie(rec, "authgate_token"))
+
+	rec = forwardAuth(ts, "test.example.com", session)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "oidc:alice", rec.Header().Get("X-Auth-User"))
+
+	// 不能为 alice 注册通行密钥、创建访问令牌或启用 TOTP
+	form, csrf := openLoginForm(t, ts, "")
+	session = cookieHeader(session.Value, csrf)
+	for _, path := range []string{"/authgate/webauthn/register/begin", "/authgate/tokens", "/authgate/totp/enroll/begin"} {
+		rec = ut.PerformRequest(ts, "POST", path, formBody(url.Values{"name": {"ci"}}), authHost, formContentType, session,
+			ut.Header{Key: "X-CSRF-Token", Value: form.Get("csrf_token")})
+		assert.Equal(t, http.StatusForbidden, rec.Code, path)
+		assert.Contains(t, rec.Body.String(), "local_account_required", path)
+	}
+	rec = ut.PerformRequest(ts, "GET", "/authgate/tokens", nil, authHost, session)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Contains(t, rec.Body.String(), "local_account_required")
+}
+
+func TestOIDCDisabled(t *testing.T) {
+	ts := setupTestServer(t)
+	rec := ut.PerformRequest(ts, "GET", "/authgate/oidc/login", nil, hostHeader("auth.example.com"))
+	assert.NotEqual(t, http.StatusFound, rec.Code)
+}
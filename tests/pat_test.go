@@ -0,0 +1,206 @@
+package tests
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/cloudwego/hertz/pkg/app/server"
+	"github.com/cloudwego/hertz/pkg/common/ut"
+	"github.com/cloudwego/hertz/pkg/route"
+	"github.com/ipfans/authgate/passwd"
+	"github.com/ipfans/authgate/routers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type accessToken struct {
+	ID     string   `json:"id"`
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	Token  string   `json:"token"`
+}
+
+// tokenCSRF 通过令牌列表接口获取 CSRF 令牌，返回加上 CSRF Cookie 后的 Cookie 和 X-CSRF-Token 请求头
+func tokenCSRF(t *testing.T, ts *route.Engine, cookie string) (string, ut.Header) {
+	rec := ut.PerformRequest(ts, "GET", "/authgate/tokens", nil, hostHeader("auth.example.com"), cookieHeader(cookie))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var resp struct {
+		CSRF string `json:"csrf_token"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.NotEmpty(t, resp.CSRF)
+	require.Equal(t, resp.CSRF, responseCookie(rec, "authgate_csrf"))
+	return cookie + "; authgate_csrf=" + resp.CSRF, ut.Header{Key: "X-CSRF-Token", Value: resp.CSRF}
+}
+
+// createAccessToken 使用认证域名上的 Cookie 创建个人访问令牌
+func createAccessToken(ts *route.Engine, cookie string, form url.Values, headers ...ut.Header) *ut.ResponseRecorder {
+	headers = append([]ut.Header{hostHeader("auth.example.com"), formContentType, cookieHeader(cookie)}, headers...)
+	return ut.PerformRequest(ts, "POST", "/authgate/tokens", formBody(form), headers...)
+}
+
+func bearerHeader(token string) ut.Header {
+	return ut.Header{Key: "Authorization", Value: "Bearer " + token}
+}
+
+func basicHeader(username, password string) ut.Header {
+	return ut.Header{Key: "Authorization", Value: "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))}
+}
+
+func TestAccessTokens(t *testing.T) {
+	cfg := loadTestConfig()
+	cfg.Routes.AdminToken = "admin-secret"
+	h := server.Default()
+	require.NoError(t, routers.RegisterRoutes(h, cfg.Routes))
+	ts := h.Engine
+
+	cookie, csrf := tokenCSRF(t, ts, loginAs(t, ts, "alice", "alicepass"))
+
+	rec := createAccessToken(ts, "", url.Values{"name": {"ci"}}, csrf)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	rec = createAccessToken(ts, cookie, url.Values{"name": {"ci"}, "scope": {"https://test.example.com"}}, csrf)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = createAccessToken(ts, cookie, url.Values{"name": {"ci"}, "expires_in": {"9000h"}}, csrf)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = createAccessToken(ts, cookie, url.Values{"scope": {"test.example.com"}}, csrf)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = createAccessToken(ts, cookie, url.Values{"name": {"ci"}, "scope": {"test.example.com"}, "expires_in": {"720h"}}, csrf)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	var ci accessToken
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ci))
+	assert.True(t, strings.HasPrefix(ci.Token, "agp_"+ci.ID+"_"), ci.Token)
+	assert.Equal(t, []string{"test.example.com"}, ci.Scopes)
+
+	rec = createAccessToken(ts, cookie, url.Values{"name": {"expired"}, "expires_in": {"1ns"}}, csrf)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var expired accessToken
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &expired))
+
+	// 列表中不返回令牌明文和哈希
+	rec = ut.PerformRequest(ts, "GET", "/authgate/tokens", nil, hostHeader("auth.example.com"), cookieHeader(cookie))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.NotContains(t, rec.Body.String(), ci.Token)
+	assert.NotContains(t, rec.Body.String(), "hash")
+	var list struct {
+		Tokens []accessToken `json:"tokens"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+	require.Len(t, list.Tokens, 2)
+	assert.Equal(t, "ci", list.Tokens[0].Name)
+
+	t.Run("Bearer", func(t *testing.T) {
+		rec := ut.PerformRequest(ts, "GET", "/api/data", nil, hostHeader("test.example.com"), bearerHeader(ci.Token))
+		assertProxied(t, rec)
+	})
+
+	t.Run("Basic", func(t *testing.T) {
+		rec := ut.PerformRequest(ts, "GET", "/api/data", nil, hostHeader("test.example.com"), basicHeader("alice", ci.Token))
+		assertProxied(t, rec)
+		rec = ut.PerformRequest(ts, "GET", "/api/data", nil, hostHeader("test.example.com"), basicHeader("testuser", ci.Token))
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("Forward auth", func(t *testing.T) {
+		rec := ut.PerformRequest(ts, "GET", "/authgate/verify", nil, hostHeader("authgate.internal"),
+			ut.Header{Key: "X-Forwarded-Host", Value: "test.example.com"}, bearerHeader(ci.Token))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "alice", rec.Header().Get("X-Auth-User"))
+
+		// 超出 scope 的站点
+		rec = ut.PerformRequest(ts, "GET", "/authgate/verify", nil, hostHeader("authgate.internal"),
+			ut.Header{Key: "X-Forwarded-Host", Value: "app.example.com"}, bearerHeader(ci.Token))
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Empty(t, rec.Header().Get("Location"))
+	})
+
+	t.Run("Invalid credentials get 401 instead of redirect", func(t *testing.T) {
+		for _, header := range []ut.Header{
+			bearerHeader(expired.Token),
+			bearerHeader(ci.Token + "x"),
+			bearerHeader("agp_0000000000000000_secret"),
+			bearerHeader("not-an-authgate-token"),
+			basicHeader("alice", "alicepass"),
+		} {
+			rec := ut.PerformRequest(ts, "GET", "/api/data", nil, hostHeader("test.example.com"), header)
+			assert.Equal(t, http.StatusUnauthorized, rec.Code, header.Value)
+			assert.Contains(t, rec.Header().Get("WWW-Authenticate"), "Bearer")
+			assert.Empty(t, rec.Header().Get("Location"))
+		}
+	})
+
+	t.Run("Tokens cannot manage tokens", func(t *testing.T) {
+		rec := ut.PerformRequest(ts, "POST", "/authgate/tokens", formBody(url.Values{"name": {"x"}}),
+			hostHeader("auth.example.com"), formContentType, bearerHeader(ci.Token))
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("CSRF", func(t *testing.T) {
+		// 只带登录 Cookie 的跨站请求不能创建或删除令牌
+		rec := createAccessToken(ts, cookie, url.Values{"name": {"csrf"}})
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		rec = createAccessToken(ts, cookie, url.Values{"name": {"csrf"}}, ut.Header{Key: "X-CSRF-Token", Value: "wrong"})
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		rec = ut.PerformRequest(ts, "DELETE", "/authgate/tokens/"+ci.ID, nil, hostHeader("auth.example.com"), cookieHeader(cookie))
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+
+		// 表单字段同样可以携带 CSRF 令牌
+		rec = createAccessToken(ts, cookie, url.Values{"name": {"form"}, "csrf_token": {csrf.Value}})
+		require.Equal(t, http.StatusCreated, rec.Code)
+		var form accessToken
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &form))
+		rec = ut.PerformRequest(ts, "DELETE", "/authgate/tokens/"+form.ID, nil, hostHeader("auth.example.com"), cookieHeader(cookie), csrf)
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+	})
+
+	t.Run("Revoke", func(t *testing.T) {
+		rec := ut.PerformRequest(ts, "DELETE", "/authgate/tokens/"+ci.ID, nil, hostHeader("auth.example.com"), cookieHeader(cookie), csrf)
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+		rec = ut.PerformRequest(ts, "DELETE", "/authgate/tokens/"+ci.ID, nil, hostHeader("auth.example.com"), cookieHeader(cookie), csrf)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		rec = ut.PerformRequest(ts, "GET", "/api/data", nil, hostHeader("test.example.com"), bearerHeader(ci.Token))
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("Admin", func(t *testing.T) {
+		admin := bearerHeader("admin-secret")
+		rec := ut.PerformRequest(ts, "GET", "/authgate/admin/users/alice/tokens", nil, hostHeader("auth.example.com"))
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		rec = ut.PerformRequest(ts, "GET", "/authgate/admin/users/alice/tokens", nil, hostHeader("auth.example.com"), admin)
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), expired.ID)
+		rec = ut.PerformRequest(ts, "DELETE", "/authgate/admin/users/alice/tokens/"+expired.ID, nil, hostHeader("auth.example.com"), admin)
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+		rec = ut.PerformRequest(ts, "GET", "/authgate/admin/users/carol/tokens", nil, hostHeader("auth.example.com"), admin)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
+
+func TestAccessTokensRequireLocalAccount(t *testing.T) {
+	hash, err := passwd.Hash("break-glass")
+	require.NoError(t, err)
+	cfg := loadTestConfig()
+	cfg.Routes.Authenticators = []routers.AuthenticatorConfig{
+		{Type: "static", Users: []routers.UserConfig{{Username: "root", PasswordHash: hash}}},
+		{Type: "local"},
+	}
+	h := server.Default()
+	require.NoError(t, routers.RegisterRoutes(h, cfg.Routes))
+	ts := h.Engine
+	auth := hostHeader("auth.example.com")
+
+	// 应急账号不在用户存储中，不能创建个人访问令牌
+	cookie := loginAs(t, ts, "root", "break-glass")
+	rec := ut.PerformRequest(ts, "GET", "/authgate/tokens", nil, auth, cookieHeader(cookie))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Contains(t, rec.Body.String(), "local_account_required")
+
+	form, csrf := openLoginForm(t, ts, "")
+	rec = createAccessToken(ts, cookie+"; "+csrf, url.Values{"name": {"ci"}}, ut.Header{Key: "X-CSRF-Token", Value: form.Get("csrf_token")})
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Contains(t, rec.Body.String(), "local_account_required")
+}
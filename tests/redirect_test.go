@@ -0,0 +1,114 @@
+package tests
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/cloudwego/hertz/pkg/common/ut"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedirectAllowlist(t *testing.T) {
+	ts := setupTestServer(t)
+
+	tests := []struct {
+		name   string
+		header ut.Header
+		code   int
+	}{
+		{"backend", ut.Header{Key: "X-Original-URL", Value: "https://test.example.com/"}, http.StatusUnauthorized},
+		{"allowlist", ut.Header{Key: "X-Original-URL", Value: "https://app.example.com/"}, http.StatusUnauthorized},
+		{"wildcard", ut.Header{Key: "X-Original-URL", Value: "https://shop.example.net/"}, http.StatusUnauthorized},
+		{"wildcard apex", ut.Header{Key: "X-Original-URL", Value: "https://example.net/"}, http.StatusBadRequest},
+		{"scheme mismatch", ut.Header{Key: "X-Original-URL", Value: "http://nginx.example.com/"}, http.StatusBadRequest},
+		{"unknown host", ut.Header{Key: "X-Original-URL", Value: "https://evil.com/"}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run("verify "+tt.name, func(t *testing.T) {
+			rec := ut.PerformRequest(ts, "GET", "/authgate/verify", nil, hostHeader("authgate.internal"), tt.header)
+			assert.Equal(t, tt.code, rec.Code)
+			if tt.code == http.StatusBadRequest {
+				assert.Empty(t, rec.Header().Get("Location"))
+			}
+		})
+	}
+
+	t.Run("login page", func(t *testing.T) {
+		rec := ut.PerformRequest(ts, "GET", "/login?host="+url.QueryEscape("http://test.example.com"), nil, hostHeader("auth.example.com"))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		for _, host := range []string{"https://evil.com", "javascript://test.example.com", "http://user@evil.com"} {
+			rec = ut.PerformRequest(ts, "GET", "/login?host="+url.QueryEscape(host), nil, hostHeader("auth.example.com"))
+			assert.Equal(t, http.StatusBadRequest, rec.Code, host)
+			assert.Contains(t, rec.Body.String(), "not protected by AuthGate")
+		}
+	})
+
+	t.Run("login form", func(t *testing.T) {
+		form, csrf := openLoginForm(t, ts, "")
+		form.Set("host", "https://evil.com")
+		form.Set("username", "alice")
+		form.Set("password", "alicepass")
+		rec := postLogin(ts, form, csrf)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Empty(t, responseCookie(rec, "authgate_token"))
+	})
+}
+
+func TestDeepLink(t *testing.T) {
+	ts := setupTestServer(t)
+
+	// 登录后回到最初访问的页面
+	rec := ut.PerformRequest(ts, "GET", "/reports/2024?tab=summary&page=2", nil, hostHeader("test.example.com"))
+	require.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	location, err := url.Parse(rec.Header().Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, "http://test.example.com", location.Query().Get("host"))
+	state := location.Query().Get("state")
+	stateCookie := "authgate_state=" + responseCookie(rec, "authgate_state")
+
+	rec = passwordLogin(t, ts, "alice", "alicepass", state)
+	require.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	finish, err := url.Parse(rec.Header().Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, "/authgate/login/finish", finish.Path)
+
+	rec = ut.PerformRequest(ts, "GET", finish.RequestURI(), nil, hostHeader("test.example.com"), cookieHeader(stateCookie))
+	require.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	assert.Equal(t, "/reports/2024?tab=summary&page=2", rec.Header().Get("Location"))
+
+	// 转发认证同样保留原始地址
+	rec = ut.PerformRequest(ts, "GET", "/authgate/verify", nil, hostHeader("authgate.internal"),
+		ut.Header{Key: "X-Forwarded-Proto", Value: "https"},
+		ut.Header{Key: "X-Forwarded-Host", Value: "app.example.com"},
+		ut.Header{Key: "X-Forwarded-Uri", Value: "/dashboard?id=1"})
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+	location, err = url.Parse(rec.Header().Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, "https://app.example.com", location.Query().Get("host"))
+	stateCookie = "authgate_state=" + responseCookie(rec, "authgate_state")
+
+	rec = passwordLogin(t, ts, "alice", "alicepass", location.Query().Get("state"))
+	require.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	finish, err = url.Parse(rec.Header().Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, "app.example.com", finish.Host)
+	rec = ut.PerformRequest(ts, "GET", finish.RequestURI(), nil, hostHeader("app.example.com"), cookieHeader(stateCookie))
+	require.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	assert.Equal(t, "/dashboard?id=1", rec.Header().Get("Location"))
+
+	// 以 "//" 开头的路径可能被浏览器当作其他站点，只返回首页
+	rec = ut.PerformRequest(ts, "GET", "/authgate/verify", nil, hostHeader("authgate.internal"),
+		ut.Header{Key: "X-Forwarded-Host", Value: "app.example.com"},
+		ut.Header{Key: "X-Forwarded-Uri", Value: "//evil.com/path"})
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+	location, err = url.Parse(rec.Header().Get("Location"))
+	require.NoError(t, err)
+	stateCookie = "authgate_state=" + responseCookie(rec, "authgate_state")
+	rec = passwordLogin(t, ts, "alice", "alicepass", location.Query().Get("state"))
+	finish, err = url.Parse(rec.Header().Get("Location"))
+	require.NoError(t, err)
+	rec = ut.PerformRequest(ts, "GET", finish.RequestURI(), nil, hostHeader("app.example.com"), cookieHeader(stateCookie))
+	assert.Equal(t, "/", rec.Header().Get("Location"))
+}
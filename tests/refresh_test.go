@@ -0,0 +1,114 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app/server"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ipfans/authgate/routers"
+	"github.com/ipfans/authgate/session"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testClaims 与 routers.Claims 的 JSON 格式一致，用于构造和检查令牌
+type testClaims struct {
+	Username string           `json:"username"`
+	AuthTime *jwt.NumericDate `json:"auth_time,omitempty"`
+	jwt.RegisteredClaims
+}
+
+func signTestToken(t *testing.T, authTime, issuedAt, expiresAt time.Time) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &testClaims{
+		Username: "alice",
+		AuthTime: jwt.NewNumericDate(authTime),
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(issuedAt),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}).SignedString([]byte("test_secret"))
+	require.NoError(t, err)
+	return token
+}
+
+func parseTestToken(t *testing.T, token string) *testClaims {
+	claims := &testClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+		return []byte("test_secret"), nil
+	})
+	require.NoError(t, err)
+	return claims
+}
+
+func TestSlidingSession(t *testing.T) {
+	cfg := loadTestConfig()
+	cfg.Routes.Session = session.Config{Lifetime: 8 * time.Hour, IdleTimeout: 30 * time.Minute}
+	h := server.Default()
+	require.NoError(t, routers.RegisterRoutes(h, cfg.Routes))
+	ts := h.Engine
+
+	// 新登录的令牌按空闲时间过期，Cookie 按最长有效期保存
+	rec := passwordLogin(t, ts, "alice", "alicepass", "")
+	require.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	assert.Contains(t, rec.Header().Get("Set-Cookie"), "max-age=28800")
+	claims := parseTestToken(t, responseCookie(rec, "authgate_token"))
+	assert.WithinDuration(t, time.Now().Add(30*time.Minute), claims.ExpiresAt.Time, 2*time.Second)
+
+	now := time.Now()
+	tests := []struct {
+		name      string
+		token     string
+		status    int
+		refreshed bool
+		expires   time.Time
+	}{
+		{
+			name:   "fresh token",
+			token:  signTestToken(t, now.Add(-time.Hour), now.Add(-time.Minute), now.Add(29*time.Minute)),
+			status: http.StatusOK,
+		},
+		{
+			name:      "past refresh threshold",
+			token:     signTestToken(t, now.Add(-2*time.Hour), now.Add(-20*time.Minute), now.Add(10*time.Minute)),
+			status:    http.StatusOK,
+			refreshed: true,
+			expires:   now.Add(30 * time.Minute),
+		},
+		{
+			name:      "capped by lifetime",
+			token:     signTestToken(t, now.Add(-8*time.Hour+20*time.Minute), now.Add(-20*time.Minute), now.Add(10*time.Minute)),
+			status:    http.StatusOK,
+			refreshed: true,
+			expires:   now.Add(20 * time.Minute),
+		},
+		{
+			name:   "lifetime reached",
+			token:  signTestToken(t, now.Add(-8*time.Hour+5*time.Minute), now.Add(-20*time.Minute), now.Add(5*time.Minute)),
+			status: http.StatusOK,
+		},
+		{
+			name:   "idle timeout",
+			token:  signTestToken(t, now.Add(-time.Hour), now.Add(-31*time.Minute), now.Add(-time.Minute)),
+			status: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := forwardAuth(ts, "test.example.com", cookieHeader("authgate_token="+tt.token))
+			assert.Equal(t, tt.status, rec.Code)
+			cookie := responseCookie(rec, "authgate_token")
+			if !tt.refreshed {
+				assert.Empty(t, cookie)
+				return
+			}
+			require.NotEmpty(t, cookie)
+			old := parseTestToken(t, tt.token)
+			refreshed := parseTestToken(t, cookie)
+			assert.Equal(t, old.AuthTime.Unix(), refreshed.AuthTime.Unix())
+			assert.Equal(t, "alice", refreshed.Username)
+			assert.WithinDuration(t, tt.expires, refreshed.ExpiresAt.Time, 2*time.Second)
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package tests
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/cloudwego/hertz/pkg/app/server"
+	"github.com/cloudwego/hertz/pkg/common/ut"
+	"github.com/cloudwego/hertz/pkg/route"
+	"github.com/ipfans/authgate/routers"
+	"github.com/ipfans/authgate/session"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupSessionServer(t *testing.T) *route.Engine {
+	cfg := loadTestConfig()
+	cfg.Routes.Session = session.Config{Type: "memory"}
+	cfg.Routes.AdminToken = "admin-secret"
+	h := server.Default()
+	require.NoError(t, routers.RegisterRoutes(h, cfg.Routes))
+	return h.Engine
+}
+
+// assertLoggedIn 检查 Cookie 是否仍能通过转发认证
+func assertLoggedIn(t *testing.T, ts *route.Engine, cookie string, expected bool) {
+	t.Helper()
+	rec := forwardAuth(ts, "test.example.com", cookieHeader(cookie))
+	if expected {
+		assert.Equal(t, http.StatusOK, rec.Code)
+	} else {
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	ts := setupSessionServer(t)
+	cookie := loginAs(t, ts, "alice", "alicepass")
+	other := loginAs(t, ts, "alice", "alicepass")
+	assertLoggedIn(t, ts, cookie, true)
+
+	// GET 只显示确认页面，不会注销
+	rec := ut.PerformRequest(ts, "GET", "/authgate/logout", nil, hostHeader("test.example.com"), cookieHeader(cookie))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `<form method="post" action="/authgate/logout">`)
+	assert.Empty(t, responseCookie(rec, "authgate_token"))
+	assertLoggedIn(t, ts, cookie, true)
+
+	// 没有 CSRF 令牌的 POST 被拒绝
+	rec = ut.PerformRequest(ts, "POST", "/authgate/logout", nil, hostHeader("test.example.com"), cookieHeader(cookie))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assertLoggedIn(t, ts, cookie, true)
+
+	// 在受保护域名上注销后带着票据跳转到认证域名
+	rec = logout(t, ts, "test.example.com", cookie)
+	assert.Equal(t, http.StatusFound, rec.Code)
+	location, err := url.Parse(rec.Header().Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, "auth.example.com", location.Host)
+	assert.Equal(t, "/authgate/logout", location.Path)
+	assert.Contains(t, rec.Header().Get("Set-Cookie"), "authgate_token=;")
+	assert.Contains(t, rec.Header().Get("Set-Cookie"), "expires=Tue, 10 Nov 2009")
+
+	// 注销后旧令牌被拒绝，其他会话不受影响
+	assertLoggedIn(t, ts, cookie, false)
+	assertLoggedIn(t, ts, other, true)
+
+	// 票据只能注销同一会话在认证域名上的 Cookie
+	rec = ut.PerformRequest(ts, "GET", location.RequestURI(), nil, hostHeader("auth.example.com"), cookieHeader(other))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, responseCookie(rec, "authgate_token"))
+	assertLoggedIn(t, ts, other, true)
+	rec = ut.PerformRequest(ts, "GET", location.RequestURI(), nil, hostHeader("auth.example.com"), cookieHeader(cookie))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "logged out")
+	assert.Contains(t, rec.Header().Get("Set-Cookie"), "authgate_token=;")
+
+	rec = logout(t, ts, "auth.example.com", other)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "logged out")
+	assertLoggedIn(t, ts, other, false)
+}
+
+func TestRevokeSessions(t *testing.T) {
+	ts := setupSessionServer(t)
+	alice1 := loginAs(t, ts, "alice", "alicepass")
+	alice2 := loginAs(t, ts, "alice", "alicepass")
+	testuser := loginAs(t, ts, "testuser", "testpass")
+
+	tests := []struct {
+		name   string
+		auth   string
+		status int
+	}{
+		{"no token", "", http.StatusUnauthorized},
+		{"wrong token", "Bearer wrong", http.StatusUnauthorized},
+		{"valid token", "Bearer admin-secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := ut.PerformRequest(ts, "DELETE", "/authgate/admin/users/alice/sessions", nil,
+				hostHeader("auth.example.com"), ut.Header{Key: "Authorization", Value: tt.auth})
+			assert.Equal(t, tt.status, rec.Code)
+			if tt.status == http.StatusOK {
+				assert.JSONEq(t, `{"revoked":2}`, rec.Body.String())
+			}
+		})
+	}
+
+	assertLoggedIn(t, ts, alice1, false)
+	assertLoggedIn(t, ts, alice2, false)
+	assertLoggedIn(t, ts, testuser, true)
+}
+
+func TestLogoutWithoutSessions(t *testing.T) {
+	ts := setupTestServer(t)
+	cookie := loginAs(t, ts, "alice", "alicepass")
+	rec := logout(t, ts, "auth.example.com", cookie)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Header().Get("Set-Cookie"), "authgate_token=;")
+
+	// 未启用服务端会话时没有管理接口
+	rec = ut.PerformRequest(ts, "DELETE", "/authgate/admin/users/alice/sessions", nil,
+		hostHeader("auth.example.com"), ut.Header{Key: "Authorization", Value: "Bearer admin-secret"})
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
@@ -0,0 +1,181 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app/server"
+	"github.com/cloudwego/hertz/pkg/common/ut"
+	"github.com/cloudwego/hertz/pkg/route"
+	"github.com/ipfans/authgate/lockout"
+	"github.com/ipfans/authgate/routers"
+	"github.com/ipfans/authgate/totp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var ticketPattern = regexp.MustCompile(`name="ticket" value="([^"]+)"`)
+
+// passwordStep 提交密码，返回第二步页面中的票据
+func passwordStep(t *testing.T, ts *route.Engine) string {
+	state, _ := beginLogin(t, ts, "test.example.com")
+	rec := passwordLogin(t, ts, "alice", "alicepass", state)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, responseCookie(rec, "authgate_token"))
+	match := ticketPattern.FindStringSubmatch(rec.Body.String())
+	require.Len(t, match, 2)
+	return match[1]
+}
+
+// totpStep 提交第二步验证码
+func totpStep(ts *route.Engine, ticket, code string) *ut.ResponseRecorder {
+	return ut.PerformRequest(ts, "POST", "/login/totp", formBody(url.Values{
+		"ticket": {ticket},
+		"code":   {code},
+	}), hostHeader("auth.example.com"), formContentType)
+}
+
+func TestTOTP(t *testing.T) {
+	cfg := loadTestConfig()
+	cfg.Routes.Backends = append(cfg.Routes.Backends, routers.Backend{
+		Host:       "secure.example.com",
+		UpStream:   []string{"http://127.0.0.1:8083"},
+		Require2FA: true,
+	})
+	h := server.Default()
+	require.NoError(t, routers.RegisterRoutes(h, cfg.Routes))
+	ts := h.Engine
+	auth := hostHeader("auth.example.com")
+	verify := func(host, cookie string) int {
+		return forwardAuth(ts, host, cookieHeader(cookie)).Code
+	}
+
+	// 仅使用密码登录时不能访问要求两步验证的后端
+	cookie := loginAs(t, ts, "alice", "alicepass")
+	assert.Equal(t, http.StatusOK, verify("test.example.com", cookie))
+	assert.Equal(t, http.StatusForbidden, verify("secure.example.com", cookie))
+
+	// 启用 TOTP，缺少 CSRF 令牌时拒绝
+	rec := ut.PerformRequest(ts, "POST", "/authgate/totp/enroll/begin", nil, auth, cookieHeader(cookie))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	cookie, csrf := tokenCSRF(t, ts, cookie)
+	rec = ut.PerformRequest(ts, "POST", "/authgate/totp/enroll/begin", nil, auth, cookieHeader(cookie), csrf)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var enroll struct {
+		Secret string `json:"secret"`
+		URI    string `json:"uri"`
+		Ticket string `json:"ticket"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &enroll))
+	assert.Contains(t, enroll.URI, "otpauth://totp/AuthGate:alice?")
+
+	finish := func(code string) *ut.ResponseRecorder {
+		return ut.PerformRequest(ts, "POST", "/authgate/totp/enroll/finish", formBody(url.Values{
+			"ticket": {enroll.Ticket},
+			"code":   {code},
+		}), auth, formContentType, cookieHeader(cookie), csrf)
+	}
+	assert.Equal(t, http.StatusBadRequest, finish("000000").Code)
+	now := time.Now()
+	code, err := totp.Code(enroll.Secret, now)
+	require.NoError(t, err)
+	rec = finish(code)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var recovery struct {
+		Codes []string `json:"recovery_codes"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &recovery))
+	require.Len(t, recovery.Codes, totp.RecoveryCodeCount)
+	assert.Equal(t, http.StatusConflict, finish(code).Code)
+
+	t.Run("totp code", func(t *testing.T) {
+		ticket := passwordStep(t, ts)
+		// 启用时使用过的验证码不能再次使用
+		assert.Equal(t, http.StatusUnauthorized, totpStep(ts, ticket, code).Code)
+		next, err := totp.Code(enroll.Secret, now.Add(totp.Period))
+		require.NoError(t, err)
+		rec := totpStep(ts, ticket, next)
+		require.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+		location, err := url.Parse(rec.Header().Get("Location"))
+		require.NoError(t, err)
+		assert.Equal(t, "test.example.com", location.Host)
+		mfa := "authgate_token=" + responseCookie(rec, "authgate_token")
+		assert.Equal(t, http.StatusOK, verify("secure.example.com", mfa))
+
+		assert.Equal(t, http.StatusUnauthorized, totpStep(ts, ticket, next).Code)
+	})
+
+	t.Run("recovery code", func(t *testing.T) {
+		rec := totpStep(ts, passwordStep(t, ts), recovery.Codes[0])
+		require.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+		assert.Equal(t, http.StatusUnauthorized, totpStep(ts, passwordStep(t, ts), recovery.Codes[0]).Code)
+	})
+
+	t.Run("invalid ticket", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, totpStep(ts, enroll.Ticket, recovery.Codes[1]).Code)
+		assert.Equal(t, http.StatusUnauthorized, totpStep(ts, "invalid", recovery.Codes[1]).Code)
+	})
+
+	t.Run("disable", func(t *testing.T) {
+		disable := func(code string, headers ...ut.Header) int {
+			headers = append([]ut.Header{auth, formContentType, cookieHeader(cookie)}, headers...)
+			return ut.PerformRequest(ts, "POST", "/authgate/totp/disable", formBody(url.Values{
+				"code": {code},
+			}), headers...).Code
+		}
+		assert.Equal(t, http.StatusForbidden, disable(recovery.Codes[2]))
+		assert.Equal(t, http.StatusBadRequest, disable("000000", csrf))
+		assert.Equal(t, http.StatusNoContent, disable(recovery.Codes[2], csrf))
+		// 停用后恢复为单步密码登录
+		loginAs(t, ts, "alice", "alicepass")
+	})
+}
+
+// enableTOTP 为 cookie 对应的用户启用 TOTP，返回密钥
+func enableTOTP(t *testing.T, ts *route.Engine, cookie string) string {
+	cookie, csrf := tokenCSRF(t, ts, cookie)
+	rec := ut.PerformRequest(ts, "POST", "/authgate/totp/enroll/begin", nil, hostHeader("auth.example.com"), cookieHeader(cookie), csrf)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var enroll struct {
+		Secret string `json:"secret"`
+		Ticket string `json:"ticket"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &enroll))
+	code, err := totp.Code(enroll.Secret, time.Now())
+	require.NoError(t, err)
+	rec = ut.PerformRequest(ts, "POST", "/authgate/totp/enroll/finish", formBody(url.Values{
+		"ticket": {enroll.Ticket},
+		"code":   {code},
+	}), hostHeader("auth.example.com"), formContentType, cookieHeader(cookie), csrf)
+	require.Equal(t, http.StatusOK, rec.Code)
+	return enroll.Secret
+}
+
+func TestTOTPDisableLockout(t *testing.T) {
+	cfg := loadTestConfig()
+	cfg.Routes.Lockout = lockout.Config{User: lockout.Policy{MaxAttempts: 2, BaseDelay: time.Minute}}
+	h := server.Default()
+	require.NoError(t, routers.RegisterRoutes(h, cfg.Routes))
+	ts := h.Engine
+	cookie := loginAs(t, ts, "alice", "alicepass")
+	secret := enableTOTP(t, ts, cookie)
+	cookie, csrf := tokenCSRF(t, ts, cookie)
+
+	disable := func(code string) *ut.ResponseRecorder {
+		return ut.PerformRequest(ts, "POST", "/authgate/totp/disable", formBody(url.Values{
+			"code": {code},
+		}), hostHeader("auth.example.com"), formContentType, cookieHeader(cookie), csrf)
+	}
+	assert.Equal(t, http.StatusBadRequest, disable("000000").Code)
+	assert.Equal(t, http.StatusTooManyRequests, disable("000001").Code)
+	// 锁定期间正确的验证码同样被拒绝
+	code, err := totp.Code(secret, time.Now().Add(totp.Period))
+	require.NoError(t, err)
+	rec := disable(code)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+}
@@ -0,0 +1,116 @@
+package tests
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cloudwego/hertz/pkg/app/server"
+	"github.com/cloudwego/hertz/pkg/common/ut"
+	"github.com/ipfans/authgate/access"
+	"github.com/ipfans/authgate/routers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoginPage(t *testing.T) {
+	ts := setupTestServer(t)
+
+	// 受保护域名跳转到的 /authgate/login 直接可用
+	for _, path := range []string{"/authgate/login", "/login"} {
+		rec := ut.PerformRequest(ts, "GET", path, nil, hostHeader("auth.example.com"))
+		assert.Equal(t, http.StatusOK, rec.Code, path)
+		assert.Contains(t, rec.Body.String(), `<form method="post" action="/login">`)
+		assert.Contains(t, rec.Body.String(), `href="/authgate/static/authgate.css"`)
+		assert.Contains(t, rec.Body.String(), "<title>Log in · AuthGate</title>")
+	}
+
+	// 静态资源在受保护域名上同样可用，错误页面需要引用
+	rec := ut.PerformRequest(ts, "GET", "/authgate/static/authgate.css", nil, hostHeader("test.example.com"))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, string(rec.Header().ContentType()), "text/css")
+	rec = ut.PerformRequest(ts, "GET", "/authgate/static/logo.svg", nil, hostHeader("auth.example.com"))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, string(rec.Header().ContentType()), "image/svg+xml")
+	rec = ut.PerformRequest(ts, "GET", "/authgate/static/missing.css", nil, hostHeader("auth.example.com"))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = ut.PerformRequest(ts, "GET", "/authgate/logout", nil, hostHeader("auth.example.com"))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "<title>Log out · AuthGate</title>")
+	rec = logout(t, ts, "auth.example.com", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "<h1>Logged out</h1>")
+}
+
+func TestCustomUI(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "templates"), 0o755))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "static"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "templates", "forbidden.html"),
+		[]byte(`{{define "content"}}<h1>No entry</h1><p>{{.T .Data.Message}}</p>{{end}}`), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "static", "brand.png"), []byte("\x89PNG\r\n\x1a\n"), 0o644))
+
+	cfg := loadTestConfig()
+	cfg.Routes.UI.Dir = dir
+	cfg.Routes.UI.Title = "Example SSO"
+	cfg.Routes.UI.Logo = "/authgate/static/brand.png"
+	cfg.Routes.UI.PrimaryColor = "#0f766e"
+	cfg.Routes.Backends[0].Access.Rules = []access.Rule{{Action: access.ActionDeny}}
+	h := server.Default()
+	require.NoError(t, routers.RegisterRoutes(h, cfg.Routes))
+	ts := h.Engine
+
+	rec := ut.PerformRequest(ts, "GET", "/authgate/login", nil, hostHeader("auth.example.com"))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "<title>Log in · Example SSO</title>")
+	assert.Contains(t, rec.Body.String(), `src="/authgate/static/brand.png"`)
+	assert.Contains(t, rec.Body.String(), "--primary: #0f766e")
+
+	rec = ut.PerformRequest(ts, "GET", "/authgate/static/brand.png", nil, hostHeader("auth.example.com"))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "image/png", string(rec.Header().ContentType()))
+
+	// 覆盖的模板用于 403 页面
+	cookie := loginAs(t, ts, "alice", "alicepass")
+	rec = ut.PerformRequest(ts, "GET", "/", nil, hostHeader("test.example.com"), cookieHeader(cookie))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Contains(t, rec.Body.String(), "<h1>No entry</h1>")
+	assert.Contains(t, rec.Body.String(), "You do not have permission")
+
+	// 模板语法错误时启动失败
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "templates", "login.html"), []byte(`{{define "content"}}{{`), 0o644))
+	assert.Error(t, routers.RegisterRoutes(server.Default(), cfg.Routes))
+}
+
+func TestLocalizedPages(t *testing.T) {
+	ts := setupTestServer(t)
+
+	rec := ut.PerformRequest(ts, "GET", "/authgate/login", nil, hostHeader("auth.example.com"),
+		ut.Header{Key: "Accept-Language", Value: "zh-CN,zh;q=0.9,en;q=0.8"})
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "<h1>登录</h1>")
+	assert.Equal(t, "zh", rec.Header().Get("Content-Language"))
+
+	// lang Cookie 优先于 Accept-Language
+	rec = ut.PerformRequest(ts, "GET", "/authgate/login", nil, hostHeader("auth.example.com"),
+		ut.Header{Key: "Accept-Language", Value: "zh-CN"}, cookieHeader("lang=en"))
+	assert.Contains(t, rec.Body.String(), "<h1>Log in</h1>")
+	assert.Equal(t, "en", rec.Header().Get("Content-Language"))
+
+	// 错误页面同样翻译
+	rec = ut.PerformRequest(ts, "GET", "/login?host="+url.QueryEscape("https://evil.com"), nil, hostHeader("auth.example.com"),
+		ut.Header{Key: "Accept-Language", Value: "zh"})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "<h1>请求无效</h1>")
+	assert.Contains(t, rec.Body.String(), "您要登录的站点不受 AuthGate 保护。")
+
+	form, csrf := openLoginForm(t, ts, "")
+	form.Set("username", "alice")
+	form.Set("password", "wrong")
+	rec = postLogin(ts, form, csrf, ut.Header{Key: "Accept-Language", Value: "zh"})
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Contains(t, rec.Body.String(), "用户名或密码错误。")
+}
@@ -0,0 +1,120 @@
+package tests
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/cloudwego/hertz/pkg/app/server"
+	"github.com/cloudwego/hertz/pkg/common/ut"
+	"github.com/ipfans/authgate/routers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForwardAuth(t *testing.T) {
+	ts := setupTestServer(t)
+
+	tests := []struct {
+		name     string
+		headers  []ut.Header
+		location string
+	}{
+		{
+			name: "traefik",
+			headers: []ut.Header{
+				hostHeader("authgate.internal"),
+				{Key: "X-Forwarded-Method", Value: "GET"},
+				{Key: "X-Forwarded-Proto", Value: "https"},
+				{Key: "X-Forwarded-Host", Value: "app.example.com"},
+				{Key: "X-Forwarded-Uri", Value: "/dashboard?id=1"},
+			},
+			location: "http://auth.example.com/authgate/login?host=https%3A%2F%2Fapp.example.com",
+		},
+		{
+			name: "nginx",
+			headers: []ut.Header{
+				hostHeader("authgate.internal"),
+				{Key: "X-Original-URL", Value: "https://nginx.example.com/path"},
+			},
+			location: "http://auth.example.com/authgate/login?host=https%3A%2F%2Fnginx.example.com",
+		},
+		{
+			name: "nginx with X-Original-URI",
+			headers: []ut.Header{
+				hostHeader("authgate.internal"),
+				{Key: "X-Forwarded-Host", Value: "plain.example.com"},
+				{Key: "X-Original-URI", Value: "/path"},
+			},
+			location: "http://auth.example.com/authgate/login?host=http%3A%2F%2Fplain.example.com",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+" unauthorized", func(t *testing.T) {
+			rec := ut.PerformRequest(ts, "GET", "/authgate/verify", nil, tt.headers...)
+			assert.Equal(t, http.StatusUnauthorized, rec.Code)
+			assert.True(t, strings.HasPrefix(rec.Header().Get("Location"), tt.location+"&state="), rec.Header().Get("Location"))
+			assert.NotEmpty(t, responseCookie(rec, "authgate_state"))
+		})
+	}
+
+	t.Run("Authorized", func(t *testing.T) {
+		cookie := loginAs(t, ts, "alice", "alicepass")
+		rec := ut.PerformRequest(ts, "GET", "/authgate/verify", nil,
+			hostHeader("authgate.internal"),
+			ut.Header{Key: "X-Forwarded-Host", Value: "app.example.com"},
+			cookieHeader(cookie),
+		)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "alice", rec.Header().Get("X-Auth-User"))
+		assert.Equal(t, "alice@example.com", rec.Header().Get("X-Auth-Email"))
+		assert.Equal(t, "admin,dev", rec.Header().Get("X-Auth-Groups"))
+	})
+
+	t.Run("Invalid token", func(t *testing.T) {
+		rec := ut.PerformRequest(ts, "GET", "/authgate/verify", nil,
+			hostHeader("authgate.internal"),
+			ut.Header{Key: "X-Forwarded-Host", Value: "app.example.com"},
+			cookieHeader("authgate_token=invalid"),
+		)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+}
+
+func TestForwardAuthRestricted(t *testing.T) {
+	ts := setupTestServer(t)
+	cookie := cookieHeader(loginAs(t, ts, "alice", "alicepass"))
+	forwarded := ut.Header{Key: "X-Forwarded-Host", Value: "app.example.com"}
+
+	// 受保护站点上不提供转发认证接口
+	rec := ut.PerformRequest(ts, "GET", "/authgate/verify", nil, hostHeader("test.example.com"), forwarded, cookie)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Empty(t, rec.Header().Get("X-Auth-User"))
+
+	// 认证域名上可用
+	rec = ut.PerformRequest(ts, "GET", "/authgate/verify", nil, hostHeader("auth.example.com"), forwarded, cookie)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "alice", rec.Header().Get("X-Auth-User"))
+
+	// 缺少原始域名
+	rec = ut.PerformRequest(ts, "GET", "/authgate/verify", nil, hostHeader("authgate.internal"), cookie)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestForwardAuthUntrustedProxy(t *testing.T) {
+	cfg := loadTestConfig()
+	cfg.Routes.TrustedProxies = []string{"10.0.0.0/8"}
+	h := server.Default()
+	require.NoError(t, routers.RegisterRoutes(h, cfg.Routes))
+	ts := h.Engine
+
+	rec := forwardAuth(ts, "app.example.com", cookieHeader(loginAs(t, ts, "alice", "alicepass")))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Empty(t, rec.Header().Get("X-Auth-User"))
+}
+
+func TestVerifyHostIsBackend(t *testing.T) {
+	cfg := loadTestConfig()
+	cfg.Routes.VerifyHosts = []string{"test.example.com"}
+	assert.Error(t, routers.RegisterRoutes(server.Default(), cfg.Routes))
+}
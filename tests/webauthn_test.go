@@ -0,0 +1,287 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app/server"
+	"github.com/cloudwego/hertz/pkg/common/ut"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/ipfans/authgate/lockout"
+	"github.com/ipfans/authgate/routers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const webauthnOrigin = "http://auth.example.com"
+
+// softAuthenticator 是测试用的软件认证器，使用 none 格式的证明
+type softAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	signCount    uint32
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	id := make([]byte, 16)
+	_, err = rand.Read(id)
+	require.NoError(t, err)
+	return &softAuthenticator{key: key, credentialID: id}
+}
+
+func (a *softAuthenticator) authData(attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte("auth.example.com"))
+	buf := bytes.NewBuffer(rpIDHash[:])
+	flags := byte(0x01 | 0x04) // UP | UV
+	if attested {
+		flags |= 0x40 // AT
+	}
+	buf.WriteByte(flags)
+	a.signCount++
+	_ = binary.Write(buf, binary.BigEndian, a.signCount)
+	if attested {
+		buf.Write(make([]byte, 16)) // AAGUID
+		_ = binary.Write(buf, binary.BigEndian, uint16(len(a.credentialID)))
+		buf.Write(a.credentialID)
+		pub, _ := cbor.Marshal(map[int]interface{}{
+			1:  2,  // kty: EC2
+			3:  -7, // alg: ES256
+			-1: 1,  // crv: P-256
+			-2: a.key.PublicKey.X.FillBytes(make([]byte, 32)),
+			-3: a.key.PublicKey.Y.FillBytes(make([]byte, 32)),
+		})
+		buf.Write(pub)
+	}
+	return buf.Bytes()
+}
+
+func clientData(typ, challenge string) []byte {
+	data, _ := json.Marshal(map[string]string{
+		"type":      typ,
+		"challenge": challenge,
+		"origin":    webauthnOrigin,
+	})
+	return data
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func (a *softAuthenticator) attestation(challenge string) []byte {
+	attestationObject, _ := cbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": a.authData(true),
+	})
+	body, _ := json.Marshal(map[string]interface{}{
+		"id":    b64(a.credentialID),
+		"rawId": b64(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64(clientData("webauthn.create", challenge)),
+			"attestationObject": b64(attestationObject),
+		},
+	})
+	return body
+}
+
+func (a *softAuthenticator) assertion(t *testing.T, challenge string) []byte {
+	authData := a.authData(false)
+	cd := clientData("webauthn.get", challenge)
+	cdHash := sha256.Sum256(cd)
+	digest := sha256.Sum256(append(authData, cdHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	require.NoError(t, err)
+	body, _ := json.Marshal(map[string]interface{}{
+		"id":    b64(a.credentialID),
+		"rawId": b64(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64(cd),
+			"authenticatorData": b64(authData),
+			"signature":         b64(signature),
+		},
+	})
+	return body
+}
+
+func jsonBody(data []byte) *ut.Body {
+	return &ut.Body{Body: bytes.NewReader(data), Len: len(data)}
+}
+
+func challengeOf(t *testing.T, rec *ut.ResponseRecorder) string {
+	var options struct {
+		PublicKey struct {
+			Challenge string `json:"challenge"`
+		} `json:"publicKey"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &options))
+	require.NotEmpty(t, options.PublicKey.Challenge)
+	return options.PublicKey.Challenge
+}
+
+// allowedCredentials 返回登录挑战中允许使用的凭据 ID
+func allowedCredentials(t *testing.T, rec *ut.ResponseRecorder) []string {
+	var options struct {
+		PublicKey struct {
+			AllowCredentials []struct {
+				ID string `json:"id"`
+			} `json:"allowCredentials"`
+		} `json:"publicKey"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &options))
+	ids := make([]string, 0, len(options.PublicKey.AllowCredentials))
+	for _, credential := range options.PublicKey.AllowCredentials {
+		ids = append(ids, credential.ID)
+	}
+	return ids
+}
+
+func TestWebAuthn(t *testing.T) {
+	ts := setupTestServer(t)
+	authHost := hostHeader("auth.example.com")
+	jsonType := ut.Header{Key: "Content-Type", Value: "application/json"}
+
+	t.Run("Register requires login", func(t *testing.T) {
+		rec := ut.PerformRequest(ts, "POST", "/authgate/webauthn/register/begin", nil, authHost)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("Login without credentials", func(t *testing.T) {
+		// 没有通行密钥的用户和不存在的用户得到与已注册用户相同形式的挑战
+		begin := func(username string) []string {
+			rec := ut.PerformRequest(ts, "POST", "/authgate/webauthn/login/begin", formBody(url.Values{
+				"username": {username},
+			}), authHost, formContentType)
+			require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+			challengeOf(t, rec)
+			return allowedCredentials(t, rec)
+		}
+		noPasskey := begin("testuser")
+		assert.Len(t, noPasskey, 1)
+		assert.Equal(t, noPasskey, begin("testuser"))
+		unknown := begin("nobody")
+		assert.Len(t, unknown, 1)
+		assert.Equal(t, unknown, begin("nobody"))
+		assert.NotEqual(t, noPasskey, unknown)
+
+		rec := ut.PerformRequest(ts, "POST", "/authgate/webauthn/login/begin", formBody(url.Values{
+			"username": {"nobody"},
+		}), authHost, formContentType)
+		challenge := challengeOf(t, rec)
+		session := responseCookie(rec, "authgate_webauthn")
+		rec = ut.PerformRequest(ts, "POST", "/authgate/webauthn/login/finish",
+			jsonBody(newSoftAuthenticator(t).assertion(t, challenge)), authHost, jsonType, cookieHeader("authgate_webauthn="+session))
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("Finish without session", func(t *testing.T) {
+		rec := ut.PerformRequest(ts, "POST", "/authgate/webauthn/login/finish", jsonBody([]byte("{}")), authHost, jsonType)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("Register and login", func(t *testing.T) {
+		authenticator := newSoftAuthenticator(t)
+		loginCookie := loginAs(t, ts, "testuser", "testpass")
+
+		// 注册通行密钥
+		rec := ut.PerformRequest(ts, "POST", "/authgate/webauthn/register/begin", nil, authHost, cookieHeader(loginCookie))
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		challenge := challengeOf(t, rec)
+		session := responseCookie(rec, "authgate_webauthn")
+		require.NotEmpty(t, session)
+
+		rec = ut.PerformRequest(ts, "POST", "/authgate/webauthn/register/finish", jsonBody(authenticator.attestation(challenge)),
+			authHost, jsonType, cookieHeader(loginCookie, "authgate_webauthn="+session))
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		// 会话只能使用一次
+		rec = ut.PerformRequest(ts, "POST", "/authgate/webauthn/register/finish", jsonBody(authenticator.attestation(challenge)),
+			authHost, jsonType, cookieHeader(loginCookie, "authgate_webauthn="+session))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+		// 使用通行密钥登录
+		rec = ut.PerformRequest(ts, "POST", "/authgate/webauthn/login/begin", formBody(url.Values{
+			"username": {"testuser"},
+		}), authHost, formContentType)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		challenge = challengeOf(t, rec)
+		session = responseCookie(rec, "authgate_webauthn")
+
+		state, stateCookie := beginLogin(t, ts, "test.example.com")
+		rec = ut.PerformRequest(ts, "POST", "/authgate/webauthn/login/finish?state="+url.QueryEscape(state),
+			jsonBody(authenticator.assertion(t, challenge)), authHost, jsonType, cookieHeader("authgate_webauthn="+session))
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var result struct {
+			Redirect string `json:"redirect"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+		assert.True(t, strings.HasPrefix(result.Redirect, "http://test.example.com/authgate/login/finish?code="), result.Redirect)
+
+		// 与密码登录相同的交接流程
+		loc, _ := url.Parse(result.Redirect)
+		rec = ut.PerformRequest(ts, "GET", loc.RequestURI(), nil, hostHeader("test.example.com"), cookieHeader(stateCookie))
+		assert.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+		assert.NotEmpty(t, responseCookie(rec, "authgate_token"))
+	})
+
+	t.Run("Login with wrong signature", func(t *testing.T) {
+		rec := ut.PerformRequest(ts, "POST", "/authgate/webauthn/login/begin", formBody(url.Values{
+			"username": {"testuser"},
+		}), authHost, formContentType)
+		require.Equal(t, http.StatusOK, rec.Code)
+		challenge := challengeOf(t, rec)
+		session := responseCookie(rec, "authgate_webauthn")
+
+		// 未注册的认证器
+		other := newSoftAuthenticator(t)
+		rec = ut.PerformRequest(ts, "POST", "/authgate/webauthn/login/finish",
+			jsonBody(other.assertion(t, challenge)), authHost, jsonType, cookieHeader("authgate_webauthn="+session))
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+}
+
+func TestWebAuthnLockout(t *testing.T) {
+	cfg := loadTestConfig()
+	cfg.Routes.Lockout = lockout.Config{User: lockout.Policy{MaxAttempts: 2, BaseDelay: time.Minute}}
+	h := server.Default()
+	require.NoError(t, routers.RegisterRoutes(h, cfg.Routes))
+	ts := h.Engine
+	authHost := hostHeader("auth.example.com")
+	jsonType := ut.Header{Key: "Content-Type", Value: "application/json"}
+
+	attempt := func() *ut.ResponseRecorder {
+		rec := ut.PerformRequest(ts, "POST", "/authgate/webauthn/login/begin", formBody(url.Values{
+			"username": {"alice"},
+		}), authHost, formContentType)
+		if rec.Code != http.StatusOK {
+			return rec
+		}
+		challenge := challengeOf(t, rec)
+		session := responseCookie(rec, "authgate_webauthn")
+		return ut.PerformRequest(ts, "POST", "/authgate/webauthn/login/finish",
+			jsonBody(newSoftAuthenticator(t).assertion(t, challenge)), authHost, jsonType, cookieHeader("authgate_webauthn="+session))
+	}
+	assert.Equal(t, http.StatusUnauthorized, attempt().Code)
+	assert.Equal(t, http.StatusTooManyRequests, attempt().Code)
+	// 锁定期间不再发放挑战
+	rec := attempt()
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+	// 通行密钥失败同样锁定密码登录
+	assert.Equal(t, http.StatusTooManyRequests, passwordLogin(t, ts, "alice", "alicepass", "").Code)
+}
@@ -0,0 +1,56 @@
+package totp
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/hex"
+	"strings"
+)
+
+// RecoveryCodeCount 是每次生成的恢复码数量
+const RecoveryCodeCount = 10
+
+var recoveryEncoding = base32.NewEncoding("abcdefghijkmnpqrstuvwxyz23456789").WithPadding(base32.NoPadding)
+
+// GenerateRecoveryCodes 生成一组一次性恢复码，返回明文和对应的哈希，
+// 明文只展示给用户一次，存储时只保存哈希
+func GenerateRecoveryCodes() (codes, hashes []string, err error) {
+	for i := 0; i < RecoveryCodeCount; i++ {
+		raw := make([]byte, 10)
+		if _, err = rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+		s := recoveryEncoding.EncodeToString(raw)
+		code := s[:8] + "-" + s[8:]
+		codes = append(codes, code)
+		hashes = append(hashes, HashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode 返回恢复码的哈希，忽略大小写、空格和连字符。
+// 恢复码是 80 位随机数，无需使用慢哈希
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// UseRecoveryCode 在哈希列表中查找恢复码，找到时返回删除该恢复码后的列表
+func UseRecoveryCode(hashes []string, code string) ([]string, bool) {
+	hash := []byte(HashRecoveryCode(code))
+	index := -1
+	for i, h := range hashes {
+		if subtle.ConstantTimeCompare([]byte(h), hash) == 1 {
+			index = i
+		}
+	}
+	if index < 0 {
+		return hashes, false
+	}
+	rest := make([]string, 0, len(hashes)-1)
+	rest = append(rest, hashes[:index]...)
+	return append(rest, hashes[index+1:]...), true
+}
@@ -0,0 +1,91 @@
+package totp
+
+import (
+	"encoding/base32"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rfcSecret 是 RFC 6238 附录 B 中 SHA1 测试用的密钥
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestCode(t *testing.T) {
+	// RFC 6238 附录 B 的测试向量，取后 6 位
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		code, err := Code(rfcSecret, time.Unix(tt.unix, 0))
+		require.NoError(t, err)
+		assert.Equal(t, tt.code, code, tt.unix)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	require.NoError(t, err)
+	now := time.Now()
+	code, err := Code(secret, now)
+	require.NoError(t, err)
+
+	step, ok := Validate(secret, code, now)
+	assert.True(t, ok)
+	assert.Equal(t, Step(now), step)
+
+	// 允许一个时间步的偏差
+	_, ok = Validate(secret, code, now.Add(Period))
+	assert.True(t, ok)
+	_, ok = Validate(secret, code, now.Add(3*Period))
+	assert.False(t, ok)
+
+	_, ok = Validate(secret, "000000x", now)
+	assert.False(t, ok)
+	_, ok = Validate("not base32!", code, now)
+	assert.False(t, ok)
+}
+
+func TestURI(t *testing.T) {
+	u, err := url.Parse(URI("AuthGate", "alice@example.com", "JBSWY3DPEHPK3PXP"))
+	require.NoError(t, err)
+	assert.Equal(t, "otpauth", u.Scheme)
+	assert.Equal(t, "totp", u.Host)
+	assert.Equal(t, "/AuthGate:alice@example.com", u.Path)
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", u.Query().Get("secret"))
+	assert.Equal(t, "AuthGate", u.Query().Get("issuer"))
+	assert.Equal(t, "30", u.Query().Get("period"))
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := GenerateRecoveryCodes()
+	require.NoError(t, err)
+	require.Len(t, codes, RecoveryCodeCount)
+	require.Len(t, hashes, RecoveryCodeCount)
+	assert.Regexp(t, `^[a-z2-9]{8}-[a-z2-9]{8}$`, codes[0])
+	assert.NotContains(t, hashes, codes[0])
+
+	// 忽略大小写和连字符
+	rest, ok := UseRecoveryCode(hashes, strings.ToUpper(strings.ReplaceAll(codes[3], "-", "")))
+	require.True(t, ok)
+	assert.Len(t, rest, RecoveryCodeCount-1)
+	assert.NotContains(t, rest, hashes[3])
+	assert.Len(t, hashes, RecoveryCodeCount)
+
+	// 每个恢复码只能使用一次
+	_, ok = UseRecoveryCode(rest, codes[3])
+	assert.False(t, ok)
+	_, ok = UseRecoveryCode(rest, "unknown")
+	assert.False(t, ok)
+}
@@ -0,0 +1,129 @@
+package ui
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// catalog 是一种语言的翻译，键为消息 ID
+type catalog map[string]string
+
+// loadCatalogs 读取 locales/ 下的 <lang>.json，覆盖目录中的同名文件会合并到内置翻译上
+func loadCatalogs(base, dir fs.FS) (map[string]catalog, error) {
+	catalogs := make(map[string]catalog)
+	for _, files := range []fs.FS{base, dir} {
+		if files == nil {
+			continue
+		}
+		entries, err := fs.ReadDir(files, "locales")
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if entry.IsDir() || path.Ext(name) != ".json" {
+				continue
+			}
+			data, err := fs.ReadFile(files, path.Join("locales", name))
+			if err != nil {
+				return nil, err
+			}
+			var messages catalog
+			if err := json.Unmarshal(data, &messages); err != nil {
+				return nil, fmt.Errorf("locales/%s: %w", name, err)
+			}
+			lang := strings.ToLower(strings.TrimSuffix(name, ".json"))
+			if catalogs[lang] == nil {
+				catalogs[lang] = make(catalog, len(messages))
+			}
+			for k, v := range messages {
+				catalogs[lang][k] = v
+			}
+		}
+	}
+	return catalogs, nil
+}
+
+// Language 按 lang Cookie 和 Accept-Language 选择页面语言，都不支持时使用默认语言
+func (u *UI) Language(acceptLanguage, cookie string) string {
+	if lang, ok := u.match(cookie); ok {
+		return lang
+	}
+	for _, tag := range parseAcceptLanguage(acceptLanguage) {
+		if lang, ok := u.match(tag); ok {
+			return lang
+		}
+	}
+	return u.language
+}
+
+// match 查找支持的语言，如 zh-CN 没有对应翻译时使用 zh
+func (u *UI) match(tag string) (string, bool) {
+	tag = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(tag), "_", "-"))
+	for tag != "" {
+		if _, ok := u.catalogs[tag]; ok {
+			return tag, true
+		}
+		i := strings.LastIndexByte(tag, '-')
+		if i < 0 {
+			break
+		}
+		tag = tag[:i]
+	}
+	return "", false
+}
+
+// parseAcceptLanguage 按权重从高到低返回 Accept-Language 中的语言，忽略 q=0 和 *
+func parseAcceptLanguage(header string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var tags []weighted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.TrimSpace(tag)
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			tags = append(tags, weighted{tag, q})
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+	result := make([]string, len(tags))
+	for i, t := range tags {
+		result[i] = t.tag
+	}
+	return result
+}
+
+// Translate 返回 lang 下的消息，缺少翻译时依次使用默认语言和消息 ID，args 非空时按 fmt 格式化
+func (u *UI) Translate(lang, key string, args ...any) string {
+	msg, ok := u.catalogs[lang][key]
+	if !ok {
+		if msg, ok = u.catalogs[u.language][key]; !ok {
+			msg = key
+		}
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+	return msg
+}
@@ -0,0 +1,77 @@
+package ui
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLanguage(t *testing.T) {
+	u, err := New(Config{})
+	require.NoError(t, err)
+
+	tests := []struct {
+		accept string
+		cookie string
+		lang   string
+	}{
+		{"", "", "en"},
+		{"zh-CN,zh;q=0.9,en;q=0.8", "", "zh"},
+		{"fr-FR, en;q=0.5, zh;q=0.7", "", "zh"},
+		{"zh;q=0, en", "", "en"},
+		{"fr, de;q=0.9", "", "en"},
+		{"*", "", "en"},
+		{"en-US", "zh", "zh"},
+		{"zh-TW", "fr", "zh"},
+		{"en", "zh_CN", "zh"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.lang, u.Language(tt.accept, tt.cookie), "accept=%q cookie=%q", tt.accept, tt.cookie)
+	}
+
+	zh, err := New(Config{Language: "zh"})
+	require.NoError(t, err)
+	assert.Equal(t, "zh", zh.Language("fr", ""))
+	_, err = New(Config{Language: "fr"})
+	assert.Error(t, err)
+}
+
+func TestTranslate(t *testing.T) {
+	u, err := New(Config{})
+	require.NoError(t, err)
+
+	assert.Equal(t, "登录", u.Translate("zh", "login.title"))
+	assert.Equal(t, "Log in", u.Translate("en", "login.title"))
+	assert.Equal(t, "Log in", u.Translate("fr", "login.title"))
+	assert.Equal(t, "missing.key", u.Translate("zh", "missing.key"))
+
+	var b strings.Builder
+	require.NoError(t, u.Render(&b, "zh", "login.html", map[string]string{"State": "s"}))
+	assert.Contains(t, b.String(), `<html lang="zh">`)
+	assert.Contains(t, b.String(), "<h1>登录</h1>")
+}
+
+func TestCustomLocales(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "locales"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "locales", "fr.json"), []byte(`{"login.title": "Connexion"}`), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "locales", "zh.json"), []byte(`{"login.submit": "进入"}`), 0o644))
+
+	u, err := New(Config{Dir: dir})
+	require.NoError(t, err)
+	assert.Equal(t, "fr", u.Language("fr-CA", ""))
+	assert.Equal(t, "Connexion", u.Translate("fr", "login.title"))
+	// 缺少的消息使用默认语言
+	assert.Equal(t, "Password", u.Translate("fr", "login.password"))
+	// 覆盖部分消息，其余保留内置翻译
+	assert.Equal(t, "进入", u.Translate("zh", "login.submit"))
+	assert.Equal(t, "密码", u.Translate("zh", "login.password"))
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "locales", "de.json"), []byte(`{`), 0o644))
+	_, err = New(Config{Dir: dir})
+	assert.Error(t, err)
+}
@@ -0,0 +1,160 @@
+// Package ui 渲染登录、错误、注销和 403 等页面。默认模板、静态资源和翻译内嵌在程序中，
+// 可以通过配置目录中的同名文件覆盖，并按配置设置标题、Logo 和配色
+package ui
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+)
+
+//go:embed templates static locales
+var embedded embed.FS
+
+// Pages 是需要渲染的页面模板，均使用 layout.html 作为布局
+var Pages = []string{"login.html", "totp.html", "error.html", "logout.html", "forbidden.html", "device.html"}
+
+type Config struct {
+	Dir             string `koanf:"dir"`              // 覆盖目录，包含 templates/ 和 static/，只需放入要替换的文件
+	Title           string `koanf:"title"`            // 站点名称，默认 AuthGate
+	Logo            string `koanf:"logo"`             // Logo 地址，默认 /authgate/static/logo.svg，设置为 "-" 时不显示
+	PrimaryColor    string `koanf:"primary_color"`    // 按钮和链接颜色
+	BackgroundColor string `koanf:"background_color"` // 页面背景颜色
+	Language        string `koanf:"language"`         // 无法从请求中确定语言时使用的语言，默认 en
+}
+
+// Brand 是模板中可以使用的品牌信息
+type Brand struct {
+	Title           string
+	Logo            string
+	PrimaryColor    string
+	BackgroundColor string
+}
+
+// view 是传给模板的数据，模板通过 .Brand 和 .Data 访问，通过 .T 翻译消息
+type view struct {
+	Lang  string
+	Brand Brand
+	Data  any
+	ui    *UI
+}
+
+// T 返回当前语言下的消息，模板中使用 {{$.T "login.title"}}
+func (v view) T(key string, args ...any) string {
+	return v.ui.Translate(v.Lang, key, args...)
+}
+
+// UI 保存解析后的模板和静态资源
+type UI struct {
+	brand     Brand
+	templates map[string]*template.Template
+	static    fs.FS
+	language  string
+	catalogs  map[string]catalog
+}
+
+// overlay 优先从覆盖目录读取文件，不存在时使用内嵌的默认文件
+type overlay struct {
+	dir  fs.FS
+	base fs.FS
+}
+
+func (o overlay) Open(name string) (fs.File, error) {
+	if o.dir != nil {
+		f, err := o.dir.Open(name)
+		if err == nil {
+			return f, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return o.base.Open(name)
+}
+
+// New 加载模板、静态资源和翻译，模板或翻译文件有误时返回错误
+func New(cfg Config) (*UI, error) {
+	files := overlay{base: embedded}
+	if cfg.Dir != "" {
+		if _, err := os.Stat(cfg.Dir); err != nil {
+			return nil, fmt.Errorf("ui dir: %w", err)
+		}
+		files.dir = os.DirFS(cfg.Dir)
+	}
+	u := &UI{
+		brand: Brand{
+			Title:           cfg.Title,
+			Logo:            cfg.Logo,
+			PrimaryColor:    cfg.PrimaryColor,
+			BackgroundColor: cfg.BackgroundColor,
+		},
+		templates: make(map[string]*template.Template, len(Pages)),
+		language:  strings.ToLower(cfg.Language),
+	}
+	if u.brand.Title == "" {
+		u.brand.Title = "AuthGate"
+	}
+	switch u.brand.Logo {
+	case "":
+		u.brand.Logo = "/authgate/static/logo.svg"
+	case "-":
+		u.brand.Logo = ""
+	}
+	if u.brand.PrimaryColor == "" {
+		u.brand.PrimaryColor = "#2563eb"
+	}
+	if u.brand.BackgroundColor == "" {
+		u.brand.BackgroundColor = "#f3f4f6"
+	}
+	catalogs, err := loadCatalogs(embedded, files.dir)
+	if err != nil {
+		return nil, err
+	}
+	u.catalogs = catalogs
+	if u.language == "" {
+		u.language = "en"
+	}
+	if _, ok := u.catalogs[u.language]; !ok {
+		return nil, fmt.Errorf("ui: no translation for language %q", u.language)
+	}
+	for _, name := range Pages {
+		t, err := template.New(name).ParseFS(files, "templates/layout.html", path.Join("templates", name))
+		if err != nil {
+			return nil, err
+		}
+		u.templates[name] = t
+	}
+	static, err := fs.Sub(files, "static")
+	if err != nil {
+		return nil, err
+	}
+	u.static = static
+	return u, nil
+}
+
+// Render 使用 lang 渲染页面模板，data 在模板中通过 .Data 访问
+func (u *UI) Render(w io.Writer, lang, name string, data any) error {
+	t, ok := u.templates[name]
+	if !ok {
+		return fmt.Errorf("ui: unknown page %q", name)
+	}
+	// 先渲染到缓冲区，避免出错时输出不完整的页面
+	var buf bytes.Buffer
+	if err := t.ExecuteTemplate(&buf, "layout", view{Lang: lang, Brand: u.brand, Data: data, ui: u}); err != nil {
+		return err
+	}
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// Static 返回静态资源文件系统，路径相对于 static/ 目录
+func (u *UI) Static() fs.FS {
+	return u.static
+}
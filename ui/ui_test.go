@@ -0,0 +1,74 @@
+package ui
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderDefaults(t *testing.T) {
+	u, err := New(Config{})
+	require.NoError(t, err)
+
+	var b strings.Builder
+	require.NoError(t, u.Render(&b, "en", "error.html", map[string]string{"Title": "Oops", "Message": "<script>"}))
+	page := b.String()
+	assert.Contains(t, page, "<title>Oops · AuthGate</title>")
+	assert.Contains(t, page, `src="/authgate/static/logo.svg"`)
+	assert.Contains(t, page, "--primary: #2563eb")
+	assert.Contains(t, page, "&lt;script&gt;")
+
+	for _, name := range Pages {
+		assert.NoError(t, u.Render(&strings.Builder{}, "en", name, map[string]string{"Title": "status.400", "Message": "error.invalid_state"}), name)
+	}
+	assert.Error(t, u.Render(&b, "en", "missing.html", nil))
+
+	css, err := fs.ReadFile(u.Static(), "authgate.css")
+	require.NoError(t, err)
+	assert.Contains(t, string(css), "--primary")
+}
+
+func TestOverride(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "templates"), 0o755))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "static"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "templates", "logout.html"),
+		[]byte(`{{define "content"}}<p>{{.T "logout.message"}} Bye from {{.Brand.Title}}</p>{{end}}`), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "static", "logo.svg"), []byte("<svg/>"), 0o644))
+
+	u, err := New(Config{Dir: dir, Title: "Example", Logo: "-", PrimaryColor: "#ff0000"})
+	require.NoError(t, err)
+
+	var b strings.Builder
+	require.NoError(t, u.Render(&b, "en", "logout.html", nil))
+	assert.Contains(t, b.String(), "<p>You have been logged out. Bye from Example</p>")
+	assert.Contains(t, b.String(), "--primary: #ff0000")
+	assert.NotContains(t, b.String(), "<img")
+
+	// 未覆盖的文件使用默认内容
+	b.Reset()
+	require.NoError(t, u.Render(&b, "en", "error.html", map[string]string{"Title": "Oops", "Message": "error.invalid_state"}))
+	assert.Contains(t, b.String(), "<h1>Oops</h1>")
+
+	logo, err := fs.ReadFile(u.Static(), "logo.svg")
+	require.NoError(t, err)
+	assert.Equal(t, "<svg/>", string(logo))
+	_, err = fs.ReadFile(u.Static(), "authgate.css")
+	assert.NoError(t, err)
+}
+
+func TestInvalidTemplate(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "templates"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "templates", "login.html"), []byte(`{{define "content"}}{{.Data`), 0o644))
+
+	_, err := New(Config{Dir: dir})
+	assert.Error(t, err)
+	_, err = New(Config{Dir: filepath.Join(dir, "missing")})
+	assert.Error(t, err)
+}
@@ -0,0 +1,15 @@
+package random
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+)
+
+// String 生成 n 字节的随机数据并以 URL 安全的 base64 编码返回
+func String(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
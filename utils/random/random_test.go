@@ -0,0 +1,21 @@
+package random
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestString(t *testing.T) {
+	s1, err := String(32)
+	require.NoError(t, err)
+	s2, err := String(32)
+	require.NoError(t, err)
+
+	raw, err := base64.RawURLEncoding.DecodeString(s1)
+	require.NoError(t, err)
+	assert.Len(t, raw, 32)
+	assert.NotEqual(t, s1, s2)
+}
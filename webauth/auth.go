@@ -18,7 +18,8 @@ func Init(cfg Config) (*webauthn.WebAuthn, error) {
 		return nil, err
 	}
 	config := &webauthn.Config{
-		RPID:          defaults.Get(u.Host, "authgate"),
+		// RPID 不能包含端口
+		RPID:          defaults.Get(u.Hostname(), "authgate"),
 		RPDisplayName: defaults.Get(cfg.DisplayName, "AuthGate"),
 		RPOrigins:     []string{defaults.Get(cfg.Origin, cfg.Origin)},
 	}
@@ -0,0 +1,70 @@
+package webauth
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/ipfans/authgate/utils/random"
+)
+
+// SessionTimeout 注册/登录仪式的有效期
+const SessionTimeout = 5 * time.Minute
+
+// Session 保存一次注册或登录仪式的上下文
+type Session struct {
+	Data     webauthn.SessionData
+	Username string
+	expires  time.Time
+}
+
+// Sessions 是仪式会话的内存存储，会话只能被取出一次
+type Sessions struct {
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+func NewSessions() *Sessions {
+	return &Sessions{
+		sessions: make(map[string]*Session),
+	}
+}
+
+// Save 保存会话并返回会话 ID
+func (s *Sessions) Save(username string, data *webauthn.SessionData) (string, error) {
+	id, err := random.String(32)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// 顺便清理已过期的会话
+	for k, v := range s.sessions {
+		if now.After(v.expires) {
+			delete(s.sessions, k)
+		}
+	}
+	s.sessions[id] = &Session{
+		Data:     *data,
+		Username: username,
+		expires:  now.Add(SessionTimeout),
+	}
+	return id, nil
+}
+
+// Take 取出并删除会话，会话不存在或已过期时返回 false
+func (s *Sessions) Take(id string) (*Session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, false
+	}
+	delete(s.sessions, id)
+	if time.Now().After(session.expires) {
+		return nil, false
+	}
+	return session, true
+}
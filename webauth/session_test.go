@@ -0,0 +1,27 @@
+package webauth
+
+import (
+	"testing"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessions(t *testing.T) {
+	s := NewSessions()
+	id, err := s.Save("alice", &webauthn.SessionData{Challenge: "challenge"})
+	require.NoError(t, err)
+
+	session, ok := s.Take(id)
+	require.True(t, ok)
+	assert.Equal(t, "alice", session.Username)
+	assert.Equal(t, "challenge", session.Data.Challenge)
+
+	// 会话只能使用一次
+	_, ok = s.Take(id)
+	assert.False(t, ok)
+
+	_, ok = s.Take("unknown")
+	assert.False(t, ok)
+}